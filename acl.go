@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// POSIX ACL xattr format, from linux/posix_acl_xattr.h
+const (
+	aclXattr        = "system.posix_acl_access"
+	aclVersion      = 2
+	aclTagUser      = 0x02
+	aclTagGroup     = 0x08
+	aclTagMask      = 0x10
+	aclEntrySize    = 8
+	aclHeaderLength = 4
+)
+
+// aclEntry is a single entry of a POSIX access ACL
+type aclEntry struct {
+	Tag  uint16
+	Perm uint16
+	ID   uint32
+}
+
+// getACL reads a file's access ACL. Files without one have no entries.
+func getACL(path string) (entries []aclEntry, err error) {
+	buf, err := getXattr(path, aclXattr)
+	if err == errNoXattr {
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+	if len(buf) < aclHeaderLength || binary.LittleEndian.Uint32(buf) != aclVersion {
+		log.Fatal("Unsupported POSIX ACL format on: " + path)
+	}
+	for i := aclHeaderLength; i+aclEntrySize <= len(buf); i += aclEntrySize {
+		entries = append(entries, aclEntry{
+			Tag:  binary.LittleEndian.Uint16(buf[i:]),
+			Perm: binary.LittleEndian.Uint16(buf[i+2:]),
+			ID:   binary.LittleEndian.Uint32(buf[i+4:]),
+		})
+	}
+	return entries, nil
+}
+
+// parseACLQualifier parses "user:alice" or "group:devs" (or u:/g:, with names
+// or numeric IDs) into an ACL tag and ID
+func parseACLQualifier(qualifier string) (tag uint16, id uint32) {
+	parts := strings.SplitN(qualifier, ":", 2)
+	if len(parts) != 2 {
+		log.Fatal("ACL entry must look like user:name or group:name, got: " + qualifier)
+	}
+	var idStr string
+	switch parts[0] {
+	case "user", "u":
+		tag = aclTagUser
+		idStr = parts[1]
+		if usr, err := user.Lookup(parts[1]); err == nil {
+			idStr = usr.Uid
+		}
+	case "group", "g":
+		tag = aclTagGroup
+		idStr = parts[1]
+		if group, err := user.LookupGroup(parts[1]); err == nil {
+			idStr = group.Gid
+		}
+	default:
+		log.Fatal("ACL entry must be for a user or group, got: " + qualifier)
+	}
+	parsed, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		log.Fatal("No such user or group: " + qualifier)
+	}
+	return tag, uint32(parsed)
+}
+
+// parseACLPerms turns a string like "rw" or "r-x" into permission bits
+func parseACLPerms(perms string) (bits uint16) {
+	for _, r := range perms {
+		switch r {
+		case 'r':
+			bits |= 4
+		case 'w':
+			bits |= 2
+		case 'x':
+			bits |= 1
+		case '-':
+		default:
+			log.Fatal("Invalid permissions, expected some of rwx: " + perms)
+		}
+	}
+	return bits
+}
+
+// formatACLPerms turns permission bits into a string like "r-x"
+func formatACLPerms(bits uint16) string {
+	perms := []byte("---")
+	for i, r := range "rwx" {
+		if bits&(4>>uint(i)) != 0 {
+			perms[i] = byte(r)
+		}
+	}
+	return string(perms)
+}
+
+// aclEffectivePerms returns the permissions a file's ACL gives a named user
+// or group, limited by the ACL mask as the kernel does
+func aclEffectivePerms(entries []aclEntry, tag uint16, id uint32) uint16 {
+	var perms uint16
+	mask := uint16(7)
+	for _, entry := range entries {
+		if entry.Tag == tag && entry.ID == id {
+			perms = entry.Perm
+		} else if entry.Tag == aclTagMask {
+			mask = entry.Perm
+		}
+	}
+	return perms & mask
+}
+
+// fileACL checks that a file's ACL gives a named user or group all of these
+// permissions or, if lacks is true, none of them. It is an abstraction of
+// fileACLGrants and fileACLDenies.
+func fileACL(path string, qualifier string, perms string, lacks bool) Thunk {
+	tag, id := parseACLQualifier(qualifier)
+	wanted := parseACLPerms(perms)
+	return func() (exitCode int, exitMessage string) {
+		entries, err := getACL(path)
+		if err != nil {
+			return 1, "Couldn't read ACL of: " + path + "\n\t" + err.Error()
+		}
+		effective := aclEffectivePerms(entries, tag, id)
+		if !lacks && effective&wanted == wanted {
+			return 0, ""
+		} else if lacks && effective&wanted == 0 {
+			return 0, ""
+		}
+		msg := "ACL does not grant permissions: " + path + ": " + qualifier
+		if lacks {
+			msg = "ACL grants permissions: " + path + ": " + qualifier
+		}
+		return genericError(msg, perms, []string{formatACLPerms(effective)})
+	}
+}
+
+// fileACLGrants checks that a file's ACL gives a named user or group all of
+// these permissions
+func fileACLGrants(path string, qualifier string, perms string) Thunk {
+	return fileACL(path, qualifier, perms, false)
+}
+
+// fileACLDenies checks that a file's ACL gives a named user or group none of
+// these permissions
+func fileACLDenies(path string, qualifier string, perms string) Thunk {
+	return fileACL(path, qualifier, perms, true)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupArtifact is the newest file found at a backup location
+type backupArtifact struct {
+	Path    string
+	ModTime time.Time
+	Size    uint64
+}
+
+// newestLocalBackup returns the most recently modified file matching the
+// given path or glob (e.g. /mnt/backups/db-*.tar.gz)
+func newestLocalBackup(pattern string) (newest backupArtifact, found bool) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Fatal("Invalid backup path: " + pattern + "\n\t" + err.Error())
+	}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if !found || info.ModTime().After(newest.ModTime) {
+			newest = backupArtifact{match, info.ModTime(), uint64(info.Size())}
+			found = true
+		}
+	}
+	return newest, found
+}
+
+// newestS3Backup returns the most recently modified object under the given
+// s3:// key or prefix, as listed by the AWS CLI
+func newestS3Backup(url string) (newest backupArtifact, found bool) {
+	out, err := exec.Command("aws", "s3", "ls", url).CombinedOutput()
+	// aws s3 ls exits 1 when nothing matches
+	if err != nil && strings.TrimSpace(string(out)) != "" {
+		msg := "Error while executing `aws s3 ls`:"
+		msg += "\n\tURL: " + url
+		msg += "\n\tOutput: " + strings.TrimSpace(string(out))
+		log.Fatal(msg)
+	}
+	// lines look like: 2015-07-04 03:00:12   52428800 db.tar.gz
+	prefix := url[:strings.LastIndex(url, "/")+1]
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "PRE" {
+			continue
+		}
+		modTime, err := time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], time.Local)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		if !found || modTime.After(newest.ModTime) {
+			name := strings.Join(fields[3:], " ")
+			newest = backupArtifact{prefix + name, modTime, size}
+			found = true
+		}
+	}
+	return newest, found
+}
+
+// backupFresh checks that the newest backup at a location was written within
+// maxAge, and is at least minSize. The location can be a local or NFS path,
+// a glob, or an s3:// key or prefix.
+func backupFresh(location string, maxAge time.Duration, minSize uint64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var newest backupArtifact
+		var found bool
+		if strings.HasPrefix(location, "s3://") {
+			newest, found = newestS3Backup(location)
+		} else {
+			newest, found = newestLocalBackup(location)
+		}
+		if !found {
+			return 1, "No backup found at: " + location
+		}
+		age := time.Since(newest.ModTime)
+		if age > maxAge {
+			msg := "Newest backup is too old: " + newest.Path
+			return genericError(msg, maxAge.String(), []string{age.String()})
+		}
+		if newest.Size < minSize {
+			msg := "Newest backup is too small: " + newest.Path
+			return genericError(msg, formatSize(minSize), []string{formatSize(newest.Size)})
+		}
+		return 0, ""
+	}
+}
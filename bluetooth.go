@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// getBluezProperty reads a boolean property of a BlueZ D-Bus object with
+// busctl, which prints booleans like "b true"
+func getBluezProperty(path string, iface string, property string) (bool, error) {
+	out, err := exec.Command("busctl", "--system", "get-property", "org.bluez",
+		path, iface, property).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Bluetooth checks require busctl (from systemd)")
+	} else if err != nil {
+		return false, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)) == "b true", nil
+}
+
+// bluezAdapterPath returns the D-Bus path of a Bluetooth adapter (e.g.
+// "hci0", the default)
+func bluezAdapterPath(adapter string) string {
+	if adapter == "" {
+		adapter = "hci0"
+	}
+	return "/org/bluez/" + adapter
+}
+
+// bluezDevicePath returns the D-Bus path BlueZ gives a device with this
+// address (e.g. "AA:BB:CC:DD:EE:FF") on an adapter
+func bluezDevicePath(address string, adapter string) string {
+	name := "dev_" + strings.Replace(strings.ToUpper(address), ":", "_", -1)
+	return bluezAdapterPath(adapter) + "/" + name
+}
+
+// bluetoothPowered checks that a Bluetooth adapter exists and is powered on
+func bluetoothPowered(adapter string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		path := bluezAdapterPath(adapter)
+		powered, err := getBluezProperty(path, "org.bluez.Adapter1", "Powered")
+		if err != nil {
+			return 1, "Couldn't find Bluetooth adapter: " + path + "\n\t" + err.Error()
+		}
+		if powered {
+			return 0, ""
+		}
+		return 1, "Bluetooth adapter is not powered: " + path
+	}
+}
+
+// bluetoothDevice checks that the device with this address has the property
+// (Paired or Connected) set. An abstraction of bluetoothDevicePaired and
+// bluetoothDeviceConnected.
+func bluetoothDevice(address string, property string, adapter string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		path := bluezDevicePath(address, adapter)
+		set, err := getBluezProperty(path, "org.bluez.Device1", property)
+		if err != nil {
+			return 1, "Bluetooth device is not known: " + address + "\n\t" + err.Error()
+		}
+		if set {
+			return 0, ""
+		}
+		return 1, "Bluetooth device is not " + strings.ToLower(property) + ": " + address
+	}
+}
+
+// bluetoothDevicePaired checks that the device with this address is paired
+func bluetoothDevicePaired(address string, adapter string) Thunk {
+	return bluetoothDevice(address, "Paired", adapter)
+}
+
+// bluetoothDeviceConnected checks that the device with this address is
+// connected
+func bluetoothDeviceConnected(address string, adapter string) Thunk {
+	return bluetoothDevice(address, "Connected", adapter)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clocksourcePath is where the kernel exposes its clock sources
+const clocksourcePath = "/sys/devices/system/clocksource/clocksource0/"
+
+// getClocksource returns the kernel's current clock source, e.g. tsc
+func getClocksource() string {
+	return strings.TrimSpace(fileToString(clocksourcePath + "current_clocksource"))
+}
+
+// getAvailableClocksources returns the clock sources the kernel considers
+// usable. The kernel removes tsc from this list when it marks it unstable.
+func getAvailableClocksources() []string {
+	return strings.Fields(fileToString(clocksourcePath + "available_clocksource"))
+}
+
+// getCPUFlags returns the feature flags of the first CPU in /proc/cpuinfo
+func getCPUFlags() []string {
+	for _, line := range strings.Split(fileToString("/proc/cpuinfo"), "\n") {
+		if strings.HasPrefix(line, "flags") {
+			if i := strings.Index(line, ":"); i != -1 {
+				return strings.Fields(line[i+1:])
+			}
+		}
+	}
+	return []string{}
+}
+
+// clocksourceIs checks that the kernel's current clock source is this one,
+// e.g. tsc or kvm-clock
+func clocksourceIs(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		current := getClocksource()
+		if current == name {
+			return 0, ""
+		}
+		return genericError("Clock source does not match", name, []string{current})
+	}
+}
+
+// tscReliable checks that the CPU advertises an invariant TSC (constant_tsc
+// and nonstop_tsc), and that the kernel hasn't marked it unstable
+func tscReliable() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		flags := getCPUFlags()
+		for _, flag := range []string{"constant_tsc", "nonstop_tsc"} {
+			if !strIn(flag, flags) {
+				return 1, "CPU does not have flag: " + flag
+			}
+		}
+		available := getAvailableClocksources()
+		if !strIn("tsc", available) {
+			msg := "TSC is not an available clock source"
+			return genericError(msg, "tsc", available)
+		}
+		return 0, ""
+	}
+}
+
+// timeSyncStatus is what an NTP client reports about the system clock
+type timeSyncStatus struct {
+	Client       string
+	Synchronized bool
+	// Offset is the absolute offset from NTP time, in milliseconds. It is
+	// negative if the client doesn't report one.
+	Offset float64
+}
+
+// parseOffsetMs parses a signed number with an optional unit suffix, e.g.
+// "+1.234ms" or "-56us", into absolute milliseconds
+func parseOffsetMs(str string, defaultUnit string) (float64, bool) {
+	re := regexp.MustCompile(`^([+-]?[0-9.]+)(ms|us|µs|ns|s)?$`)
+	match := re.FindStringSubmatch(strings.TrimSpace(str))
+	if match == nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := match[2]
+	if unit == "" {
+		unit = defaultUnit
+	}
+	switch unit {
+	case "s":
+		value *= 1000
+	case "us", "µs":
+		value /= 1000
+	case "ns":
+		value /= 1000000
+	}
+	return math.Abs(value), true
+}
+
+// colonValue returns the value after "key:" in colon-separated output like
+// `chronyc tracking` or `timedatectl timesync-status`
+func colonValue(output string, key string) string {
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// chronySyncStatus reads `chronyc tracking`
+func chronySyncStatus() timeSyncStatus {
+	status := timeSyncStatus{Client: "chrony", Offset: -1}
+	out, err := exec.Command("chronyc", "tracking").Output()
+	if err != nil {
+		return status
+	}
+	status.Synchronized = colonValue(string(out), "Leap status") != "Not synchronised"
+	// System time     : 0.000012345 seconds fast of NTP time
+	fields := strings.Fields(colonValue(string(out), "System time"))
+	if len(fields) > 0 {
+		if offset, ok := parseOffsetMs(fields[0], "s"); ok {
+			status.Offset = offset
+		}
+	}
+	return status
+}
+
+// ntpdSyncStatus reads the system variables from `ntpq -c rv`, where leap=11
+// means the clock is unsynchronized and offset is given in milliseconds
+func ntpdSyncStatus() timeSyncStatus {
+	status := timeSyncStatus{Client: "ntpd", Offset: -1}
+	out, err := exec.Command("ntpq", "-c", "rv").Output()
+	if err != nil {
+		return status
+	}
+	variables := make(map[string]string)
+	for _, field := range strings.FieldsFunc(string(out), func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) == 2 {
+			variables[parts[0]] = strings.Trim(parts[1], `"`)
+		}
+	}
+	leap, ok := variables["leap"]
+	status.Synchronized = ok && leap != "11" && leap != "alarm"
+	if offset, ok := parseOffsetMs(variables["offset"], "ms"); ok {
+		status.Offset = offset
+	}
+	return status
+}
+
+// timesyncdSyncStatus asks timedatectl, which works for systemd-timesyncd
+func timesyncdSyncStatus() timeSyncStatus {
+	status := timeSyncStatus{Client: "timedatectl", Offset: -1}
+	out, err := exec.Command("timedatectl", "show", "-p", "NTPSynchronized", "--value").Output()
+	if err == nil {
+		status.Synchronized = strings.TrimSpace(string(out)) == "yes"
+	} else {
+		// older versions of timedatectl don't support `show`
+		out, _ = exec.Command("timedatectl", "status").Output()
+		synced := colonValue(string(out), "System clock synchronized")
+		if synced == "" {
+			synced = colonValue(string(out), "NTP synchronized")
+		}
+		status.Synchronized = synced == "yes"
+	}
+	out, err = exec.Command("timedatectl", "timesync-status").Output()
+	if err == nil {
+		if offset, ok := parseOffsetMs(colonValue(string(out), "Offset"), "s"); ok {
+			status.Offset = offset
+		}
+	}
+	return status
+}
+
+// getTimeSyncStatus asks whichever NTP client is installed, preferring chrony,
+// then ntpd, then systemd-timesyncd
+func getTimeSyncStatus() timeSyncStatus {
+	clients := []struct {
+		executable string
+		status     func() timeSyncStatus
+	}{
+		{"chronyc", chronySyncStatus},
+		{"ntpq", ntpdSyncStatus},
+		{"timedatectl", timesyncdSyncStatus},
+	}
+	for _, client := range clients {
+		if _, err := exec.LookPath(client.executable); err == nil {
+			return client.status()
+		}
+	}
+	log.Fatal("Couldn't find an NTP client: tried chronyc, ntpq, and timedatectl")
+	return timeSyncStatus{}
+}
+
+// timeSynchronized checks that the system clock is synchronized to NTP, and
+// that its offset is below this many milliseconds (when the client reports it)
+func timeSynchronized(maxOffset float64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		status := getTimeSyncStatus()
+		if !status.Synchronized {
+			return 1, "System clock is not synchronized, according to " + status.Client
+		}
+		if status.Offset > maxOffset {
+			msg := "Clock offset exceeds maximum (ms), according to " + status.Client
+			actual := fmt.Sprintf("%.3f", status.Offset)
+			return genericError(msg, fmt.Sprint(maxOffset), []string{actual})
+		}
+		return 0, ""
+	}
+}
+
+// rtcPath is where the kernel exposes the first hardware clock
+const rtcPath = "/sys/class/rtc/rtc0/"
+
+// getAdjtimeMode returns whether the hardware clock keeps UTC or local time,
+// from the third line of /etc/adjtime. Without the file, UTC is assumed.
+func getAdjtimeMode() string {
+	data, err := ioutil.ReadFile("/etc/adjtime")
+	if err != nil {
+		return "UTC"
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 3 {
+		return "UTC"
+	}
+	return strings.TrimSpace(lines[2])
+}
+
+// getRTCTime reads the hardware clock. The kernel reports its reading as
+// seconds since the epoch as though it kept UTC, so it is corrected when
+// /etc/adjtime says the clock keeps local time.
+func getRTCTime() (time.Time, error) {
+	data, err := ioutil.ReadFile(rtcPath + "since_epoch")
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rtc := time.Unix(seconds, 0)
+	if getAdjtimeMode() == "LOCAL" {
+		_, offset := rtc.Zone()
+		rtc = rtc.Add(-time.Duration(offset) * time.Second)
+	}
+	return rtc, nil
+}
+
+// rtcInSync checks that the hardware clock exists, is readable, and agrees
+// with the system clock within the tolerance, catching failed RTC batteries
+func rtcInSync(tolerance time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		rtc, err := getRTCTime()
+		if err != nil {
+			return 1, "Couldn't read hardware clock:\n\t" + err.Error()
+		}
+		drift := time.Since(rtc)
+		if drift < 0 {
+			drift = -drift
+		}
+		// the RTC only has second precision
+		if drift <= tolerance+time.Second {
+			return 0, ""
+		}
+		msg := "Hardware clock differs from system time"
+		return genericError(msg, tolerance.String(), []string{drift.String()})
+	}
+}
+
+// rtcMode checks that /etc/adjtime says the hardware clock keeps UTC or LOCAL
+// time, since dual-booted hosts often disagree about it
+func rtcMode(mode string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		actual := getAdjtimeMode()
+		if strings.EqualFold(actual, mode) {
+			return 0, ""
+		}
+		return genericError("Hardware clock mode does not match", mode, []string{actual})
+	}
+}
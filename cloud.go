@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cloudInitStage is one stage's entry in cloud-init's status.json
+type cloudInitStage struct {
+	Errors   []string
+	Start    *float64
+	Finished *float64
+}
+
+// cloudInitStages are the stages cloud-init runs, in order
+var cloudInitStages = []string{"init-local", "init", "modules-config", "modules-final"}
+
+// cloudInitDone checks that cloud-init has finished all of its stages, and
+// that none of them reported errors. cloud-init writes status.json as it goes,
+// and result.json only once the final stage is done.
+func cloudInitDone() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		statusPath := "/run/cloud-init/status.json"
+		resultPath := "/run/cloud-init/result.json"
+		data, err := ioutil.ReadFile(statusPath)
+		if err != nil {
+			return 1, "cloud-init has not run: couldn't read " + statusPath
+		}
+		var status struct {
+			V1 map[string]json.RawMessage `json:"v1"`
+		}
+		if err := json.Unmarshal(data, &status); err != nil {
+			return 1, "Couldn't parse " + statusPath + ":\n\t" + err.Error()
+		}
+		var errors []string
+		for _, name := range cloudInitStages {
+			var stage cloudInitStage
+			raw, ok := status.V1[name]
+			if ok && string(raw) != "null" {
+				json.Unmarshal(raw, &stage)
+			}
+			// init-local doesn't run on every datasource
+			if stage.Start == nil && name == "init-local" {
+				continue
+			}
+			if stage.Finished == nil {
+				return 1, "cloud-init has not finished: stage " + name + " is not done"
+			}
+			errors = append(errors, stage.Errors...)
+		}
+		data, err = ioutil.ReadFile(resultPath)
+		if err != nil {
+			return 1, "cloud-init has not finished: couldn't read " + resultPath
+		}
+		var result struct {
+			V1 struct {
+				Errors []string
+			} `json:"v1"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return 1, "Couldn't parse " + resultPath + ":\n\t" + err.Error()
+		}
+		// result.json repeats the stages' errors
+		for _, e := range result.V1.Errors {
+			if !strIn(e, errors) {
+				errors = append(errors, e)
+			}
+		}
+		if len(errors) == 0 {
+			return 0, ""
+		}
+		return genericError("cloud-init finished with errors", "no errors", errors)
+	}
+}
+
+// cloudInitModuleRan checks that a cloud-init config module (e.g.
+// "write_files" or "cc_runcmd") ran for this instance, going by the semaphore
+// file cloud-init leaves for it. Modules that run on every boot leave none.
+func cloudInitModuleRan(module string) Thunk {
+	name := strings.Replace(strings.TrimPrefix(module, "cc_"), "-", "_", -1)
+	semaphores := []string{
+		"/var/lib/cloud/instance/sem/config_" + name,
+		"/var/lib/cloud/sem/config_" + name + ".once",
+	}
+	return func() (exitCode int, exitMessage string) {
+		for _, path := range semaphores {
+			if _, err := os.Stat(path); err == nil {
+				return 0, ""
+			}
+		}
+		var ran []string
+		found, _ := filepath.Glob("/var/lib/cloud/instance/sem/config_*")
+		for _, path := range found {
+			ran = append(ran, strings.TrimPrefix(filepath.Base(path), "config_"))
+		}
+		msg := "cloud-init module did not run: " + module
+		return genericError(msg, fmt.Sprint(semaphores), ran)
+	}
+}
+
+// imdsEndpoint is the address of the EC2 instance metadata service
+var imdsEndpoint = "http://169.254.169.254"
+
+// imdsRequest makes a request to the instance metadata service, with a
+// session token if one is given, and returns the response status and body
+func imdsRequest(method string, path string, token string) (int, string, error) {
+	req, err := http.NewRequest(method, imdsEndpoint+path, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if method == "PUT" {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	} else if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(body)), err
+}
+
+// imdsGet reads a metadata path using an IMDSv2 session token
+func imdsGet(path string) (string, error) {
+	status, token, err := imdsRequest("PUT", "/latest/api/token", "")
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("couldn't get a metadata token: status %d", status)
+	}
+	if err != nil {
+		return "", err
+	}
+	status, body, err := imdsRequest("GET", path, token)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("couldn't read %s: status %d", path, status)
+	}
+	return body, err
+}
+
+// imdsV2Required checks that the instance metadata service refuses requests
+// without a session token (IMDSv1), as it does when configured with
+// HttpTokens=required
+func imdsV2Required() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		status, _, err := imdsRequest("GET", "/latest/meta-data/", "")
+		if err != nil {
+			return 1, "Couldn't reach the instance metadata service:\n\t" + err.Error()
+		}
+		if status == http.StatusUnauthorized {
+			return 0, ""
+		}
+		msg := "Instance metadata service answers requests without a token (IMDSv1)"
+		return genericError(msg, fmt.Sprint(http.StatusUnauthorized), []string{fmt.Sprint(status)})
+	}
+}
+
+// imdsHopLimitAtMost checks that the instance's metadata PUT response hop
+// limit is at most this many hops. A limit of 1 keeps containers on the
+// instance from getting tokens. The limit can't be seen from inside the
+// instance, so it's read with `aws ec2 describe-instances`, which needs
+// ec2:DescribeInstances permission.
+func imdsHopLimitAtMost(max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		id, err := imdsGet("/latest/meta-data/instance-id")
+		var region string
+		if err == nil {
+			region, err = imdsGet("/latest/meta-data/placement/region")
+		}
+		if err != nil {
+			return 1, "Couldn't read instance metadata:\n\t" + err.Error()
+		}
+		query := "Reservations[0].Instances[0].MetadataOptions"
+		out, err := exec.Command("aws", "ec2", "describe-instances", "--region", region,
+			"--instance-ids", id, "--query", query, "--output", "json").Output()
+		if err != nil {
+			msg := "Error while executing `aws ec2 describe-instances`:\n\t" + err.Error()
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				msg += "\n\tOutput: " + strings.TrimSpace(string(exitErr.Stderr))
+			}
+			return 1, msg
+		}
+		var options struct {
+			HttpPutResponseHopLimit int
+		}
+		if err := json.Unmarshal(out, &options); err != nil {
+			return 1, "Couldn't parse instance metadata options:\n\t" + err.Error()
+		}
+		if options.HttpPutResponseHopLimit <= max {
+			return 0, ""
+		}
+		msg := "Instance metadata hop limit is too high: " + id
+		return genericError(msg, fmt.Sprint(max), []string{fmt.Sprint(options.HttpPutResponseHopLimit)})
+	}
+}
+
+// cloudInstance is what a cloud's metadata service says about this instance
+type cloudInstance struct {
+	Provider     string // "aws", "gce", or "azure"
+	InstanceType string
+	Region       string
+	Identity     string // IAM role, service account, or managed identity
+	Tags         map[string]string
+}
+
+// metadataGet reads a path from the metadata service with these headers,
+// returning the body, or an error for any status but 200
+func metadataGet(path string, headers map[string]string) (string, http.Header, error) {
+	req, err := http.NewRequest("GET", imdsEndpoint+path, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("couldn't read %s: status %d", path, resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), resp.Header, err
+}
+
+// getGCEInstance reads this instance's details from the GCE metadata server.
+// Instance tags are its custom metadata attributes, since labels aren't
+// exposed there.
+func getGCEInstance() (instance cloudInstance, err error) {
+	google := map[string]string{"Metadata-Flavor": "Google"}
+	get := func(path string) string {
+		var value string
+		if err == nil {
+			value, _, err = metadataGet("/computeMetadata/v1/instance/"+path, google)
+		}
+		return value
+	}
+	instance.Provider = "gce"
+	// machine types and zones are given as e.g. "projects/1/zones/us-east1-b"
+	instance.InstanceType = filepath.Base(get("machine-type"))
+	zone := filepath.Base(get("zone"))
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		instance.Region = zone[:i]
+	}
+	attributes := get("attributes/?recursive=true")
+	if err != nil {
+		return instance, err
+	}
+	json.Unmarshal([]byte(attributes), &instance.Tags)
+	// instances without a service account 404 here
+	instance.Identity, _, _ = metadataGet("/computeMetadata/v1/instance/service-accounts/default/email", google)
+	return instance, nil
+}
+
+// getAzureInstance reads this instance's details from the Azure instance
+// metadata service. The identity is the client ID of its managed identity.
+func getAzureInstance() (instance cloudInstance, err error) {
+	azure := map[string]string{"Metadata": "true"}
+	body, _, err := metadataGet("/metadata/instance?api-version=2021-02-01", azure)
+	if err != nil {
+		return instance, err
+	}
+	var metadata struct {
+		Compute struct {
+			VMSize   string `json:"vmSize"`
+			Location string `json:"location"`
+			TagsList []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"tagsList"`
+		} `json:"compute"`
+	}
+	if err := json.Unmarshal([]byte(body), &metadata); err != nil {
+		return instance, err
+	}
+	instance.Provider = "azure"
+	instance.InstanceType = metadata.Compute.VMSize
+	instance.Region = metadata.Compute.Location
+	instance.Tags = make(map[string]string)
+	for _, tag := range metadata.Compute.TagsList {
+		instance.Tags[tag.Name] = tag.Value
+	}
+	// instances without a managed identity get an error here
+	path := "/metadata/identity/oauth2/token?api-version=2018-02-01" +
+		"&resource=https%3A%2F%2Fmanagement.azure.com%2F"
+	if body, _, err := metadataGet(path, azure); err == nil {
+		var token struct {
+			ClientID string `json:"client_id"`
+		}
+		json.Unmarshal([]byte(body), &token)
+		instance.Identity = token.ClientID
+	}
+	return instance, nil
+}
+
+// getAWSInstance reads this instance's details from the EC2 instance
+// metadata service. Tags are only there if instance metadata tags are
+// enabled.
+func getAWSInstance() (instance cloudInstance, err error) {
+	instance.Provider = "aws"
+	instance.InstanceType, err = imdsGet("/latest/meta-data/instance-type")
+	if err == nil {
+		instance.Region, err = imdsGet("/latest/meta-data/placement/region")
+	}
+	if err != nil {
+		return instance, err
+	}
+	// instances without a role 404 here
+	if roles, err := imdsGet("/latest/meta-data/iam/security-credentials/"); err == nil {
+		instance.Identity = strings.TrimSpace(strings.Split(roles, "\n")[0])
+	}
+	instance.Tags = make(map[string]string)
+	if keys, err := imdsGet("/latest/meta-data/tags/instance"); err == nil {
+		for _, key := range strings.Split(keys, "\n") {
+			if value, err := imdsGet("/latest/meta-data/tags/instance/" + key); err == nil {
+				instance.Tags[key] = value
+			}
+		}
+	}
+	return instance, nil
+}
+
+// getCloudInstance finds which cloud this instance runs in, by which
+// metadata service answers, and returns what it says about the instance
+func getCloudInstance() (cloudInstance, error) {
+	google := map[string]string{"Metadata-Flavor": "Google"}
+	_, header, err := metadataGet("/computeMetadata/v1/", google)
+	if err == nil && header.Get("Metadata-Flavor") == "Google" {
+		return getGCEInstance()
+	} else if err != nil && header == nil {
+		// nothing is listening, so no other cloud will answer either
+		return cloudInstance{}, fmt.Errorf("no cloud metadata service found: %s", err.Error())
+	}
+	if instance, err := getAzureInstance(); err == nil {
+		return instance, nil
+	}
+	instance, err := getAWSInstance()
+	if err != nil {
+		return instance, fmt.Errorf("no cloud metadata service found: %s", err.Error())
+	}
+	return instance, nil
+}
+
+// cloudInstanceIs checks that the field of this instance's metadata named
+// by what, as returned by field, is this value. An empty value matches any
+// non-empty field.
+func cloudInstanceIs(what string, value string, field func(cloudInstance) string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		instance, err := getCloudInstance()
+		if err != nil {
+			return 1, "Couldn't read cloud instance metadata:\n\t" + err.Error()
+		}
+		actual := field(instance)
+		if actual == "" {
+			return 1, "Instance has no " + what + " (" + instance.Provider + ")"
+		} else if value == "" || actual == value {
+			return 0, ""
+		}
+		msg := "Instance " + what + " did not match (" + instance.Provider + ")"
+		return genericError(msg, value, []string{actual})
+	}
+}
+
+// instanceTypeIs checks that this is a cloud instance of this type, like
+// "m5.large", "n2-standard-4", or "Standard_D2s_v3"
+func instanceTypeIs(instanceType string) Thunk {
+	return cloudInstanceIs("type", instanceType, func(instance cloudInstance) string {
+		return instance.InstanceType
+	})
+}
+
+// inRegion checks that this cloud instance runs in this region, like
+// "us-east-1", "us-central1", or "eastus"
+func inRegion(region string) Thunk {
+	return cloudInstanceIs("region", region, func(instance cloudInstance) string {
+		return instance.Region
+	})
+}
+
+// hasCloudIdentity checks that this cloud instance has an IAM role, service
+// account, or managed identity, and that it's this one if a name is given
+func hasCloudIdentity(name string) Thunk {
+	return cloudInstanceIs("identity", name, func(instance cloudInstance) string {
+		return instance.Identity
+	})
+}
+
+// instanceTagEquals checks that this cloud instance has a tag (custom
+// metadata attribute, on GCE) with this key and value
+func instanceTagEquals(key string, value string) Thunk {
+	return cloudInstanceIs("tag "+key, value, func(instance cloudInstance) string {
+		return instance.Tags[key]
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// resultKey identifies a check across hosts, by its checklist, name, type,
+// and parameters, since checklists may live at different paths on each
+func (entry runLogEntry) resultKey() string {
+	key := entry.Checklist + " / " + entry.Check + " " + fmt.Sprint(entry.Parameters)
+	if entry.Name != "" {
+		key = entry.Checklist + " / " + entry.Name + " (" + entry.Check + " " +
+			fmt.Sprint(entry.Parameters) + ")"
+	}
+	return key
+}
+
+// parseRunLog reads run log entries, one JSON object per line, keeping only the
+// latest result for each check. Lines that aren't entries are skipped.
+func parseRunLog(data []byte) map[string]runLogEntry {
+	results := make(map[string]runLogEntry)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var entry runLogEntry
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		if entry.Check != "" {
+			results[entry.resultKey()] = entry
+		}
+	}
+	return results
+}
+
+// getResults loads the results for one side of a comparison. A target like
+// "ssh://web1" runs the checklist on that host over SSH, and anything else is
+// read as a run log written with -l.
+func getResults(target string, checklist string) map[string]runLogEntry {
+	if !strings.HasPrefix(target, "ssh://") {
+		results := parseRunLog(fileToBytes(target))
+		if len(results) == 0 {
+			log.Fatal("No check results found in " + target)
+		}
+		return results
+	}
+	host := strings.TrimPrefix(target, "ssh://")
+	if checklist == "" {
+		log.Fatal("Comparing live hosts needs a checklist path with -f")
+	}
+	// the report goes to stdout, so results are logged to stderr instead, with
+	// verbosity 1 so that failure messages have their details
+	cmd := exec.Command("ssh", "-o", "BatchMode=yes", host, "distributive",
+		"-v", "1", "-f", checklist, "-l", "/dev/stderr", "-log-max-size", "0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	results := parseRunLog(stderr.Bytes())
+	// failing checks make distributive exit 1, which is expected here
+	if len(results) == 0 {
+		msg := "Couldn't run distributive on " + host
+		if err != nil {
+			msg += ":\n\t" + err.Error()
+		}
+		log.Fatal(msg + "\n\t" + strings.TrimSpace(stderr.String()))
+	}
+	return results
+}
+
+// resultStatus describes a result for the comparison table
+func resultStatus(entry runLogEntry, ok bool) string {
+	if !ok {
+		return "missing"
+	} else if entry.Code == 0 {
+		return "pass"
+	}
+	return "FAIL"
+}
+
+// compareResults prints the checks whose results differ between two hosts,
+// and returns whether there were any
+func compareResults(nameA string, a map[string]runLogEntry, nameB string, b map[string]runLogEntry) bool {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	differ := 0
+	for _, key := range keys {
+		entryA, okA := a[key]
+		entryB, okB := b[key]
+		passA := okA && entryA.Code == 0
+		passB := okB && entryB.Code == 0
+		if okA == okB && passA == passB {
+			continue
+		}
+		differ++
+		fmt.Println(key)
+		fmt.Println("\t" + nameA + ": " + resultStatus(entryA, okA))
+		fmt.Println("\t" + nameB + ": " + resultStatus(entryB, okB))
+		for _, entry := range []runLogEntry{entryA, entryB} {
+			if entry.Code != 0 && entry.Message != "" {
+				msg := strings.Replace(entry.Message, "\n", "\n\t", -1)
+				fmt.Println("\t" + entry.Host + ": " + msg)
+			}
+		}
+	}
+	verbosityPrint("Same: "+fmt.Sprint(len(keys)-differ), minVerbosity+1)
+	verbosityPrint("Different: "+fmt.Sprint(differ), minVerbosity+1)
+	return differ > 0
+}
+
+// runCompare implements "distributive compare A B", exiting with 1 if any
+// check passes on one host but fails or is missing on the other
+func runCompare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: distributive compare [-f checklist] A B")
+		fmt.Fprintln(os.Stderr, "\tA and B are run logs written with -l, or ssh://host")
+		flags.PrintDefaults()
+	}
+	checklist := flags.String("f", "", "Run the checklist at this path on ssh:// hosts")
+	flags.IntVar(&verbosity, "v", 1, "Output verbosity level")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	nameA, nameB := flags.Arg(0), flags.Arg(1)
+	resultsA := getResults(nameA, *checklist)
+	resultsB := getResults(nameB, *checklist)
+	if compareResults(nameA, resultsA, nameB, resultsB) {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
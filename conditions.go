@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// conditionOperators are the comparisons a When condition can make between a
+// fact and a value. "contains" is for list facts, like interfaces.
+var conditionOperators = []string{"==", "!=", "=~", " contains "}
+
+// splitOutsideQuotes splits s around sep like strings.SplitN, but ignores
+// separators inside double-quoted values, e.g. the "||" in `os =~ "a||b"`
+func splitOutsideQuotes(s string, sep string, n int) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++ // skip the escaped character
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(s[i:], sep) && (n < 0 || len(parts) < n-1):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i = start - 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// evalComparison evaluates one comparison, like `virt == "kvm"`, against
+// these facts
+func evalComparison(comparison string, facts map[string]string) bool {
+	for _, operator := range conditionOperators {
+		parts := splitOutsideQuotes(comparison, operator, 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		fact, ok := facts[name]
+		if !ok {
+			unknownFact("When condition", name)
+		}
+		switch strings.TrimSpace(operator) {
+		case "==":
+			return fact == value
+		case "!=":
+			return fact != value
+		case "=~":
+			return compileRegex(value).MatchString(fact)
+		case "contains":
+			return strIn(value, strings.Split(fact, ","))
+		}
+	}
+	msg := "Could not parse When condition: " + comparison
+	msg += "\n\tExample: os_family == \"debian\" && virt != \"none\""
+	log.Fatal(msg)
+	return false
+}
+
+// evalCondition evaluates a When condition, made of comparisons joined by &&
+// and ||, against these facts. && binds more tightly than ||.
+func evalCondition(condition string, facts map[string]string) (result bool) {
+	// every comparison is evaluated, so a typo fails on every host, not just
+	// the ones where it's reached
+	for _, alternative := range splitOutsideQuotes(condition, "||", -1) {
+		all := true
+		for _, comparison := range splitOutsideQuotes(alternative, "&&", -1) {
+			if !evalComparison(comparison, facts) {
+				all = false
+			}
+		}
+		if all {
+			result = true
+		}
+	}
+	return result
+}
+
+// checkApplies reports whether this check should be run on this host, i.e.
+// whether it has no When condition, or its condition holds
+func checkApplies(chk Check) bool {
+	return chk.When == "" || evalCondition(chk.When, getFacts())
+}
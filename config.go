@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configValidator describes how to run a program's own configuration syntax
+// check. The config path, if given, is passed after pathFlag (or on its own
+// if pathFlag is empty).
+type configValidator struct {
+	command  []string
+	pathFlag string
+}
+
+// configValidators are the programs whose configuration configValid can check
+var configValidators = map[string]configValidator{
+	"nginx":   {[]string{"nginx", "-t"}, "-c"},
+	"apache":  {[]string{"apachectl", "-t"}, "-f"},
+	"named":   {[]string{"named-checkconf"}, ""},
+	"haproxy": {[]string{"haproxy", "-c", "-f", "/etc/haproxy/haproxy.cfg"}, "-f"},
+	"sshd":    {[]string{"sshd", "-t"}, "-f"},
+	"sudoers": {[]string{"visudo", "-c"}, "-f"},
+}
+
+// validateConfig runs the validator for the given program, optionally on a
+// config file other than its default, and returns whether it succeeded along
+// with its output
+func validateConfig(program string, path string) (bool, string) {
+	validator, ok := configValidators[strings.ToLower(program)]
+	if !ok {
+		var supported []string
+		for name := range configValidators {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		msg := "Unsupported program for config validation: " + program
+		msg += "\n\tSupported: " + strings.Join(supported, ", ")
+		log.Fatal(msg)
+	}
+	args := append([]string{}, validator.command[1:]...)
+	if path != "" {
+		// replace a default path, if the validator has one
+		for i, arg := range args {
+			if arg == validator.pathFlag && i+1 < len(args) {
+				args = args[:i]
+				break
+			}
+		}
+		if validator.pathFlag != "" {
+			args = append(args, validator.pathFlag)
+		}
+		args = append(args, path)
+	}
+	out, err := exec.Command(validator.command[0], args...).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Executable not found: " + validator.command[0])
+	}
+	return err == nil, strings.TrimSpace(string(out))
+}
+
+// configValid checks that a program's configuration has valid syntax, using
+// the program's own checker (e.g. `nginx -t`, `sshd -t`, `visudo -c`), so
+// broken configs are caught before the next restart. If path is not empty, it
+// is checked instead of the program's default config file.
+func configValid(program string, path string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		valid, output := validateConfig(program, path)
+		if valid {
+			return 0, ""
+		}
+		msg := "Configuration is invalid:"
+		msg += "\n\tProgram: " + program
+		if path != "" {
+			msg += "\n\tPath: " + path
+		}
+		msg += "\n\tOutput: " + output
+		return 1, msg
+	}
+}
+
+// sshdMultiValued are sshd_config keywords that may be given several times,
+// with every value taking effect. For all others, the first value wins.
+var sshdMultiValued = []string{
+	"acceptenv", "allowgroups", "allowusers", "denygroups", "denyusers",
+	"hostkey", "listenaddress", "port", "setenv",
+}
+
+// readSshdConfigFile parses an sshd_config file and the files it Includes,
+// appending each keyword's values (lowercased keyword) in the order sshd
+// reads them. Match blocks are skipped, since they only apply conditionally.
+func readSshdConfigFile(path string, directives map[string][]string) {
+	inMatch := false
+	for _, line := range strings.Split(fileToString(hostPath(path)), "\n") {
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		keyword := strings.ToLower(fields[0])
+		switch {
+		case keyword == "match":
+			inMatch = true
+		case keyword == "include":
+			for _, pattern := range fields[1:] {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join("/etc/ssh", pattern)
+				}
+				matches, _ := hostGlob(pattern)
+				sort.Strings(matches)
+				for _, match := range matches {
+					readSshdConfigFile(match, directives)
+				}
+			}
+		case !inMatch && len(fields) > 1:
+			directives[keyword] = append(directives[keyword], strings.Join(fields[1:], " "))
+		}
+	}
+}
+
+// getSshdConfig returns the effective sshd configuration. It prefers
+// `sshd -T`, which prints every setting after defaults and Includes are
+// applied, and falls back to parsing the config file, since `sshd -T` needs
+// root and valid host keys.
+func getSshdConfig(path string) (directives map[string][]string) {
+	directives = make(map[string][]string)
+	args := []string{"-T"}
+	if path != "" {
+		args = append(args, "-f", path)
+	}
+	// the host's sshd would read the host's config, not the image's
+	if imageRoot == "" {
+		if out, err := exec.Command("sshd", args...).Output(); err == nil {
+			for _, line := range strings.Split(string(out), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) > 1 {
+					keyword := strings.ToLower(fields[0])
+					directives[keyword] = append(directives[keyword], strings.Join(fields[1:], " "))
+				}
+			}
+			return directives
+		}
+	}
+	if path == "" {
+		path = "/etc/ssh/sshd_config"
+		// sshd isn't installed, so nothing is configured
+		if _, err := os.Stat(hostPath(path)); err != nil {
+			return directives
+		}
+	}
+	readSshdConfigFile(path, directives)
+	for keyword, values := range directives {
+		if !strIn(keyword, sshdMultiValued) {
+			directives[keyword] = values[:1]
+		}
+	}
+	return directives
+}
+
+// sshdConfig checks that the effective sshd configuration sets this directive
+// to this value, e.g. PermitRootLogin no. Keywords and values are compared
+// case-insensitively. If path is not empty, it is read instead of
+// /etc/ssh/sshd_config.
+func sshdConfig(directive string, value string, path string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		values := getSshdConfig(path)[strings.ToLower(directive)]
+		wanted := strings.Join(strings.Fields(value), " ")
+		for _, actual := range values {
+			if strings.EqualFold(actual, wanted) {
+				return 0, ""
+			}
+		}
+		msg := "sshd directive does not have value: " + directive
+		return genericError(msg, value, values)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// consulAPI fetches a path from the local Consul agent's HTTP API, at
+// $CONSUL_HTTP_ADDR or 127.0.0.1:8500, with the ACL token in
+// $CONSUL_HTTP_TOKEN if there is one. It returns the status code and body.
+func consulAPI(path string) (int, []byte, error) {
+	addr := os.Getenv("CONSUL_HTTP_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8500"
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+path, nil)
+	if err != nil {
+		log.Fatal("Could not parse Consul address: " + addr)
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// consulMemberStatuses are the values of a Consul member's Status field
+var consulMemberStatuses = []string{"none", "alive", "leaving", "left", "failed"}
+
+// consulMemberAlive checks that this node (this host, by default) is an alive
+// member of the Consul cluster, as seen by the local agent
+func consulMemberAlive(node string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if node == "" {
+			node, _ = os.Hostname()
+		}
+		code, body, err := consulAPI("/v1/agent/members")
+		if err == nil && code != http.StatusOK {
+			err = fmt.Errorf("unexpected status: %d %s", code, strings.TrimSpace(string(body)))
+		}
+		if err != nil {
+			return 1, "Couldn't list Consul members:\n\t" + err.Error()
+		}
+		var members []struct {
+			Name   string
+			Status int
+		}
+		if err := json.Unmarshal(body, &members); err != nil {
+			return 1, "Couldn't parse Consul members:\n\t" + err.Error()
+		}
+		for _, member := range members {
+			if member.Name != node {
+				continue
+			} else if member.Status == 1 {
+				return 0, ""
+			}
+			status := fmt.Sprint(member.Status)
+			if member.Status >= 0 && member.Status < len(consulMemberStatuses) {
+				status = consulMemberStatuses[member.Status]
+			}
+			msg := "Consul member is not alive: " + node
+			return genericError(msg, "alive", []string{status})
+		}
+		return 1, "Not a Consul member: " + node
+	}
+}
+
+// consulServiceHealthy checks that this service is registered with the local
+// Consul agent, and that all of its health checks are passing
+func consulServiceHealthy(service string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		path := "/v1/agent/health/service/name/" + url.PathEscape(service)
+		code, body, err := consulAPI(path)
+		if err != nil {
+			return 1, "Couldn't get Consul service health:\n\t" + err.Error()
+		}
+		// the agent answers with the aggregated status as the HTTP status
+		switch code {
+		case http.StatusOK:
+			return 0, ""
+		case http.StatusNotFound:
+			return 1, "Service not registered with the local Consul agent: " + service
+		}
+		var instances []struct {
+			AggregatedStatus string
+		}
+		var statuses []string
+		if json.Unmarshal(body, &instances) == nil {
+			for _, instance := range instances {
+				statuses = append(statuses, instance.AggregatedStatus)
+			}
+		} else {
+			statuses = append(statuses, strings.TrimSpace(string(body)))
+		}
+		msg := "Consul service is not healthy: " + service
+		return genericError(msg, "passing", statuses)
+	}
+}
+
+// etcdEndpointHealthy checks that the etcd member at this client URL (e.g.
+// "http://127.0.0.1:2379") reports itself healthy, which requires the
+// cluster to have quorum
+func etcdEndpointHealthy(endpoint string) Thunk {
+	if parsed, err := url.Parse(endpoint); err != nil || parsed.Host == "" {
+		log.Fatal("Could not parse etcd endpoint URL: " + endpoint)
+	}
+	return func() (exitCode int, exitMessage string) {
+		body, err := httpGetBody(strings.TrimSuffix(endpoint, "/") + "/health")
+		var health struct {
+			Health string `json:"health"`
+			Reason string `json:"reason"`
+		}
+		if err == nil {
+			err = json.Unmarshal([]byte(body), &health)
+		}
+		if err != nil {
+			return 1, "Couldn't get etcd health: " + endpoint + "\n\t" + err.Error()
+		} else if health.Health == "true" {
+			return 0, ""
+		}
+		msg := "etcd endpoint is not healthy: " + endpoint
+		return genericError(msg, "true", []string{health.Health + " " + health.Reason})
+	}
+}
+
+// zookeeperRuok checks that the ZooKeeper server at this address (port 2181
+// by default) answers the "ruok" four letter word with "imok". Since
+// ZooKeeper 3.5, "ruok" must be in 4lw.commands.whitelist.
+func zookeeperRuok(address string) Thunk {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "2181")
+	}
+	return func() (exitCode int, exitMessage string) {
+		conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+		if err != nil {
+			return 1, "ZooKeeper is not reachable: " + address + "\n\t" + err.Error()
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		conn.Write([]byte("ruok"))
+		reply, _ := ioutil.ReadAll(conn)
+		if string(reply) == "imok" {
+			return 0, ""
+		}
+		msg := "ZooKeeper didn't answer ruok: " + address
+		return genericError(msg, "imok", []string{strings.TrimSpace(string(reply))})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// coredumpDir is where systemd-coredump stores core dumps
+const coredumpDir = "/var/lib/systemd/coredump"
+
+// getRecentCoredumps lists the core dumps from within the given window, as
+// reported by coredumpctl, or by the files in coredumpDir when coredumpctl
+// isn't available
+func getRecentCoredumps(window time.Duration) (dumps []string) {
+	since := time.Now().Add(-window).Format("2006-01-02 15:04:05")
+	out, err := exec.Command("coredumpctl", "list", "--no-pager", "--no-legend",
+		"--since="+since).Output()
+	if _, ok := err.(*exec.ExitError); ok || err == nil {
+		// coredumpctl exits 1 when there are no matching core dumps
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				dumps = append(dumps, line)
+			}
+		}
+		return dumps
+	}
+	entries, _ := ioutil.ReadDir(coredumpDir)
+	for _, entry := range entries {
+		if time.Since(entry.ModTime()) < window {
+			dumps = append(dumps, filepath.Join(coredumpDir, entry.Name()))
+		}
+	}
+	return dumps
+}
+
+// noRecentCoredumps checks that no process dumped core within the window,
+// which would suggest something is crash looping
+func noRecentCoredumps(window time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		dumps := getRecentCoredumps(window)
+		if len(dumps) == 0 {
+			return 0, ""
+		}
+		msg := "Found core dumps from the last " + window.String()
+		return genericError(msg, "none", dumps)
+	}
+}
+
+// oomKillRe matches the kernel's messages when the OOM killer kills a process
+var oomKillRe = regexp.MustCompile(`Out of memory: Kill|Memory cgroup out of memory: Kill|oom-kill:`)
+
+// getKernelMessages returns the kernel's log messages from within the given
+// window, from the journal, or from dmesg when there's no journal
+func getKernelMessages(window time.Duration) (lines []string) {
+	since := time.Now().Add(-window)
+	out, err := exec.Command("journalctl", "--no-pager", "--quiet", "--output=cat",
+		"--dmesg", "--since="+since.Format("2006-01-02 15:04:05")).Output()
+	if err == nil {
+		return strings.Split(strings.TrimSpace(string(out)), "\n")
+	}
+	// dmesg lines look like "2015-07-04T12:00:00,123456+00:00 message"
+	out, _ = exec.Command("dmesg", "--time-format", "iso").Output()
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) < 2 {
+			continue
+		}
+		stamp, err := time.Parse("2006-01-02T15:04:05,999999-07:00", fields[0])
+		if err == nil && stamp.After(since) {
+			lines = append(lines, fields[1])
+		}
+	}
+	return lines
+}
+
+// noRecentOOMKills checks that the kernel's OOM killer didn't kill any
+// process within the window, a sign of memory pressure
+func noRecentOOMKills(window time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var kills []string
+		for _, line := range getKernelMessages(window) {
+			if oomKillRe.MatchString(line) {
+				kills = append(kills, line)
+			}
+		}
+		if len(kills) == 0 {
+			return 0, ""
+		}
+		msg := "Found OOM kills from the last " + window.String()
+		return genericError(msg, "none", kills)
+	}
+}
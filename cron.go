@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cronJob is a single job from a crontab
+type cronJob struct {
+	Schedule, Command string
+}
+
+// parseCrontab reads the jobs from the crontab at path. System crontabs have
+// a user field between the schedule and the command, user crontabs don't.
+func parseCrontab(path string, system bool) (jobs []cronJob) {
+	envRegex := regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*\\s*=")
+	for _, line := range strings.Split(fileToString(path), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || envRegex.MatchString(line) {
+			continue
+		}
+		fields := strings.Fields(line)
+		// @daily, @reboot, etc. replace all five time fields
+		scheduleLength := 5
+		if strings.HasPrefix(fields[0], "@") {
+			scheduleLength = 1
+		}
+		commandStart := scheduleLength
+		if system {
+			commandStart++
+		}
+		if len(fields) <= commandStart {
+			continue
+		}
+		jobs = append(jobs, cronJob{
+			Schedule: strings.Join(fields[:scheduleLength], " "),
+			Command:  strings.Join(fields[commandStart:], " "),
+		})
+	}
+	return jobs
+}
+
+// getCronJobs returns the jobs from the system crontab, /etc/cron.d, and all
+// user crontabs
+func getCronJobs() (jobs []cronJob) {
+	glob := func(pattern string) []string {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Fatal("Couldn't read crontabs:\n\t" + err.Error())
+		}
+		var files []string
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				files = append(files, match)
+			}
+		}
+		return files
+	}
+	system := append(glob("/etc/crontab"), glob("/etc/cron.d/*")...)
+	for _, path := range system {
+		jobs = append(jobs, parseCrontab(path, true)...)
+	}
+	// Debian keeps user crontabs in crontabs/, Red Hat directly in cron/
+	user := append(glob("/var/spool/cron/crontabs/*"), glob("/var/spool/cron/*")...)
+	for _, path := range user {
+		jobs = append(jobs, parseCrontab(path, false)...)
+	}
+	return jobs
+}
+
+// cronEntry checks that some crontab has a job with the given schedule (e.g.
+// "0 3 * * *" or "@daily") whose command matches the given regular expression
+func cronEntry(schedule string, command string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		re, err := regexp.Compile(command)
+		if err != nil {
+			log.Fatal("Invalid regular expression: " + command + "\n\t" + err.Error())
+		}
+		wanted := strings.Join(strings.Fields(schedule), " ")
+		var entries []string
+		for _, job := range getCronJobs() {
+			if job.Schedule == wanted && re.MatchString(job.Command) {
+				return 0, ""
+			}
+			entries = append(entries, job.Schedule+" "+job.Command)
+		}
+		return genericError("Cron job not found", wanted+" "+command, entries)
+	}
+}
+
+// cronDirHasScript checks that a cron directory like /etc/cron.daily contains
+// an executable script with the given name
+func cronDirHasScript(dir string, script string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		info, err := os.Stat(filepath.Join(dir, script))
+		if err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return 0, ""
+		}
+		var scripts []string
+		if files, err := filepath.Glob(filepath.Join(dir, "*")); err == nil {
+			for _, file := range files {
+				scripts = append(scripts, filepath.Base(file))
+			}
+		}
+		msg := "Executable script not found in " + dir
+		return genericError(msg, script, scripts)
+	}
+}
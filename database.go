@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseDatabaseURL parses a database URL, like "postgres://app@db1:5432/app"
+// or "redis://:secret@cache1:6379", failing if it isn't one
+func parseDatabaseURL(str string) *url.URL {
+	parsed, err := url.Parse(str)
+	if err != nil || parsed.Host == "" {
+		log.Fatal("Could not parse database URL: " + str)
+	}
+	return parsed
+}
+
+// psqlQuery runs a query with psql in a read-only transaction, and returns its
+// unaligned, headerless output. A password in the URL is passed with
+// $PGPASSWORD, so it isn't visible in psql's arguments, and otherwise libpq's
+// usual places are used: $PGPASSWORD, $PGPASSFILE, or ~/.pgpass.
+func psqlQuery(dbURL string, query string) (string, error) {
+	parsed := parseDatabaseURL(dbURL)
+	password, hasPassword := parsed.User.Password()
+	if hasPassword {
+		parsed.User = url.User(parsed.User.Username())
+	}
+	cmd := exec.Command("psql", parsed.String(), "-X", "-t", "-A", "-v", "ON_ERROR_STOP=1", "-c", query)
+	cmd.Env = append(os.Environ(), "PGOPTIONS=-c default_transaction_read_only=on",
+		"PGCONNECT_TIMEOUT=10")
+	if hasPassword {
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+password)
+	}
+	return databaseCommandOutput(cmd)
+}
+
+// mysqlQuery runs a query with the mysql client in a read-only transaction,
+// and returns its tab-separated, headerless output. A password in the URL is
+// passed with $MYSQL_PWD, and otherwise the client's option files (e.g.
+// ~/.my.cnf) are used.
+func mysqlQuery(dbURL string, query string) (string, error) {
+	parsed := parseDatabaseURL(dbURL)
+	args := []string{"--batch", "--skip-column-names", "--connect-timeout=10",
+		"--host=" + parsed.Hostname()}
+	if parsed.Port() != "" {
+		args = append(args, "--port="+parsed.Port())
+	}
+	if parsed.User != nil && parsed.User.Username() != "" {
+		args = append(args, "--user="+parsed.User.Username())
+	}
+	if db := strings.TrimPrefix(parsed.Path, "/"); db != "" {
+		args = append(args, "--database="+db)
+	}
+	args = append(args, "--execute=SET SESSION TRANSACTION READ ONLY; "+query)
+	cmd := exec.Command("mysql", args...)
+	cmd.Env = os.Environ()
+	if password, ok := parsed.User.Password(); ok {
+		cmd.Env = append(cmd.Env, "MYSQL_PWD="+password)
+	}
+	return databaseCommandOutput(cmd)
+}
+
+// databaseCommandOutput runs a database client, returning its trimmed output,
+// or an error with whatever it printed if it fails
+func databaseCommandOutput(cmd *exec.Cmd) (string, error) {
+	out, err := cmd.CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Database checks require the client `" + cmd.Args[0] + "`")
+	} else if err != nil {
+		output := strings.TrimSpace(string(out))
+		return "", fmt.Errorf("%s", strings.Replace(output, "\n", "\n\t", -1))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sqlQuery runs a read-only query against the PostgreSQL or MySQL database at
+// this URL, depending on its scheme
+func sqlQuery(dbURL string, query string) (string, error) {
+	switch parseDatabaseURL(dbURL).Scheme {
+	case "postgres", "postgresql":
+		return psqlQuery(dbURL, query)
+	case "mysql":
+		return mysqlQuery(dbURL, query)
+	}
+	log.Fatal("Unsupported database URL (use postgres:// or mysql://): " + dbURL)
+	return "", nil // never reaches this return
+}
+
+// redactURL hides the password in a URL, for messages and the run log.
+// Anything that isn't a URL is returned unchanged.
+func redactURL(dbURL string) string {
+	parsed, err := url.Parse(dbURL)
+	if err != nil || parsed.Host == "" {
+		// not a URL, so there's nothing to redact
+		return dbURL
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+	}
+	return parsed.String()
+}
+
+// sqlReachable checks that the database at this URL accepts a connection and
+// answers a trivial query. An abstraction of postgresReachable and
+// mysqlReachable.
+func sqlReachable(dbURL string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if _, err := sqlQuery(dbURL, "SELECT 1"); err != nil {
+			return 1, "Database is not reachable: " + redactURL(dbURL) + "\n\t" + err.Error()
+		}
+		return 0, ""
+	}
+}
+
+// postgresReachable checks that PostgreSQL at this URL accepts a connection
+// and answers a query
+func postgresReachable(dbURL string) Thunk {
+	if scheme := parseDatabaseURL(dbURL).Scheme; scheme != "postgres" && scheme != "postgresql" {
+		log.Fatal("Not a PostgreSQL URL (use postgres://): " + dbURL)
+	}
+	return sqlReachable(dbURL)
+}
+
+// mysqlReachable checks that MySQL at this URL accepts a connection and
+// answers a query
+func mysqlReachable(dbURL string) Thunk {
+	if parseDatabaseURL(dbURL).Scheme != "mysql" {
+		log.Fatal("Not a MySQL URL (use mysql://): " + dbURL)
+	}
+	return sqlReachable(dbURL)
+}
+
+// redisCommand connects to the Redis server at this URL, authenticates with
+// the URL's credentials or $REDISCLI_AUTH, sends one command, and returns its
+// reply: a status line like "+PONG", or the contents of a bulk string. Error
+// replies are returned as errors.
+func redisCommand(dbURL string, command ...string) (string, error) {
+	parsed := parseDatabaseURL(dbURL)
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	// send writes a command as a RESP array of bulk strings, and reads the
+	// reply
+	send := func(args ...string) (string, error) {
+		request := fmt.Sprintf("*%d\r\n", len(args))
+		for _, arg := range args {
+			request += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		}
+		if _, err := conn.Write([]byte(request)); err != nil {
+			return "", err
+		}
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil {
+			return "", err
+		} else if strings.HasPrefix(line, "-") {
+			return "", fmt.Errorf("%s", strings.TrimPrefix(line, "-"))
+		} else if strings.HasPrefix(line, "$") {
+			var length int
+			fmt.Sscanf(line, "$%d", &length)
+			if length < 0 {
+				return "", nil
+			}
+			// the bulk string is followed by \r\n
+			bulk := make([]byte, length+2)
+			_, err = io.ReadFull(reader, bulk)
+			return string(bulk[:length]), err
+		}
+		return line, nil
+	}
+	password := os.Getenv("REDISCLI_AUTH")
+	if urlPassword, ok := parsed.User.Password(); ok {
+		password = urlPassword
+	}
+	if password != "" {
+		auth := []string{"AUTH", password}
+		if user := parsed.User.Username(); user != "" {
+			auth = []string{"AUTH", user, password}
+		}
+		if _, err := send(auth...); err != nil {
+			return "", err
+		}
+	}
+	return send(command...)
+}
+
+// redisReachable checks that the Redis server at this URL (e.g.
+// "redis://cache1:6379") accepts a connection, and answers a PING
+func redisReachable(dbURL string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		reply, err := redisCommand(dbURL, "PING")
+		if err != nil {
+			return 1, "Redis is not reachable: " + redactURL(dbURL) + "\n\t" + err.Error()
+		}
+		if reply == "+PONG" {
+			return 0, ""
+		}
+		return genericError("Redis did not answer PING", "+PONG", []string{reply})
+	}
+}
+
+// sqlQueryReturns checks that a read-only query against the PostgreSQL or
+// MySQL database at this URL returns this single value
+func sqlQueryReturns(dbURL string, query string, expected string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		result, err := sqlQuery(dbURL, query)
+		if err != nil {
+			return 1, "Query failed on " + redactURL(dbURL) + ":\n\t" + err.Error()
+		}
+		if result == expected {
+			return 0, ""
+		}
+		msg := "Query did not return the expected value: " + query
+		return genericError(msg, expected, []string{result})
+	}
+}
+
+// postgresReplicationLagBelow checks that the PostgreSQL replica at this URL
+// is in recovery, and has replayed the primary's transactions from less than
+// this long ago. On a primary with no writes, replay lag grows even when
+// replication is healthy.
+func postgresReplicationLagBelow(dbURL string, max time.Duration) Thunk {
+	query := "SELECT CASE WHEN pg_is_in_recovery() THEN " +
+		"COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) " +
+		"ELSE -1 END"
+	return func() (exitCode int, exitMessage string) {
+		result, err := psqlQuery(dbURL, query)
+		if err != nil {
+			return 1, "Query failed on " + redactURL(dbURL) + ":\n\t" + err.Error()
+		}
+		seconds, err := strconv.ParseFloat(result, 64)
+		if err != nil {
+			log.Fatal("Couldn't parse replication lag: " + result)
+		}
+		if seconds < 0 {
+			return 1, "PostgreSQL is not a replica: " + redactURL(dbURL)
+		}
+		lag := time.Duration(seconds * float64(time.Second))
+		if lag < max {
+			return 0, ""
+		}
+		msg := "PostgreSQL replication lag exceeds maximum: " + redactURL(dbURL)
+		return genericError(msg, max.String(), []string{lag.String()})
+	}
+}
+
+// getMysqlReplicaStatus returns the fields of SHOW REPLICA STATUS (or, before
+// MySQL 8.0.22, SHOW SLAVE STATUS), printed vertically like
+// "Replica_IO_Running: Yes". Field names are normalized to the newer
+// "Replica"/"Source" terms. It's empty if the server isn't a replica.
+func getMysqlReplicaStatus(dbURL string) (map[string]string, error) {
+	out, err := mysqlQuery(dbURL, "SHOW REPLICA STATUS\\G")
+	if err != nil {
+		out, err = mysqlQuery(dbURL, "SHOW SLAVE STATUS\\G")
+	}
+	status := make(map[string]string)
+	replacer := strings.NewReplacer("Slave", "Replica", "Master", "Source")
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 && !strings.HasPrefix(parts[0], "*") {
+			status[replacer.Replace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return status, err
+}
+
+// mysqlReplicaRunning checks that the MySQL server at this URL is a replica
+// with both its I/O and SQL threads running
+func mysqlReplicaRunning(dbURL string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		status, err := getMysqlReplicaStatus(dbURL)
+		if err != nil {
+			return 1, "Query failed on " + redactURL(dbURL) + ":\n\t" + err.Error()
+		} else if len(status) == 0 {
+			return 1, "MySQL is not a replica: " + redactURL(dbURL)
+		}
+		ioRunning, sqlRunning := status["Replica_IO_Running"], status["Replica_SQL_Running"]
+		if ioRunning == "Yes" && sqlRunning == "Yes" {
+			return 0, ""
+		}
+		actual := []string{"IO: " + ioRunning, "SQL: " + sqlRunning}
+		for _, key := range []string{"Last_IO_Error", "Last_SQL_Error"} {
+			if status[key] != "" {
+				actual = append(actual, key+": "+status[key])
+			}
+		}
+		msg := "MySQL replication is not running: " + redactURL(dbURL)
+		return genericError(msg, "IO: Yes, SQL: Yes", actual)
+	}
+}
+
+// redisReplicationConnected checks that the Redis server at this URL is
+// connected to the rest of its replication setup: a replica's link to its
+// master must be up, and a master must have at least one connected replica.
+// INFO replication prints fields like "master_link_status:up".
+func redisReplicationConnected(dbURL string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		reply, err := redisCommand(dbURL, "INFO", "replication")
+		if err != nil {
+			return 1, "Redis is not reachable: " + redactURL(dbURL) + "\n\t" + err.Error()
+		}
+		info := make(map[string]string)
+		for _, line := range strings.Split(reply, "\n") {
+			parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+			if len(parts) == 2 {
+				info[parts[0]] = parts[1]
+			}
+		}
+		switch info["role"] {
+		case "slave":
+			if info["master_link_status"] == "up" {
+				return 0, ""
+			}
+			msg := "Redis replica is not connected to its master: " + redactURL(dbURL)
+			return genericError(msg, "up", []string{info["master_link_status"]})
+		case "master":
+			if info["connected_slaves"] != "" && info["connected_slaves"] != "0" {
+				return 0, ""
+			}
+			return 1, "Redis master has no connected replicas: " + redactURL(dbURL)
+		}
+		return 1, "Couldn't find the replication role of Redis: " + redactURL(dbURL)
+	}
+}
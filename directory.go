@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ldapBindSucceeds checks that the LDAP server at this URL (e.g.
+// "ldaps://dc1.example.com") accepts a bind and can see this search base. The
+// bind is anonymous if bind is empty, a SASL bind if it's like "sasl:GSSAPI",
+// and otherwise a simple bind as this DN, with the password from
+// $LDAP_BIND_PASSWORD.
+func ldapBindSucceeds(ldapURL string, base string, bind string) Thunk {
+	if !strings.HasPrefix(ldapURL, "ldap://") && !strings.HasPrefix(ldapURL, "ldaps://") &&
+		!strings.HasPrefix(ldapURL, "ldapi://") {
+		log.Fatal("LDAP URL should start with ldap://, ldaps://, or ldapi://: " + ldapURL)
+	}
+	args := []string{"-H", ldapURL, "-o", "nettimeout=10", "-LLL"}
+	password := ""
+	if strings.HasPrefix(strings.ToLower(bind), "sasl:") {
+		args = append(args, "-Q", "-Y", bind[len("sasl:"):])
+	} else {
+		args = append(args, "-x")
+		if bind != "" {
+			password = os.Getenv("LDAP_BIND_PASSWORD")
+			// the password file is read as is, so it's passed on stdin
+			args = append(args, "-D", bind, "-y", "/dev/stdin")
+		}
+	}
+	// only read the base entry itself, which proves the bind can see it
+	args = append(args, "-b", base, "-s", "base", "1.1")
+	return func() (exitCode int, exitMessage string) {
+		cmd := exec.Command("ldapsearch", args...)
+		cmd.Stdin = strings.NewReader(password)
+		out, err := cmd.CombinedOutput()
+		if err != nil && strings.Contains(err.Error(), "executable file not found") {
+			log.Fatal("LDAP checks require ldapsearch (from the OpenLDAP clients)")
+		}
+		output := strings.TrimSpace(string(out))
+		if err == nil && strings.Contains(output, "dn:") {
+			return 0, ""
+		}
+		msg := "LDAP bind or search failed: " + ldapURL
+		msg += "\n\tBase: " + base
+		if output != "" {
+			msg += "\n\t" + strings.Replace(output, "\n", "\n\t", -1)
+		}
+		return 1, msg
+	}
+}
+
+// kerberosKinitSucceeds checks that this principal can get a ticket from the
+// KDC using the keys in this keytab (/etc/krb5.keytab by default). The ticket
+// is kept in memory, so the host's credential caches aren't touched.
+func kerberosKinitSucceeds(principal string, keytab string) Thunk {
+	if keytab == "" {
+		keytab = "/etc/krb5.keytab"
+	}
+	return func() (exitCode int, exitMessage string) {
+		cmd := exec.Command("kinit", "-k", "-t", keytab, principal)
+		cmd.Env = append(os.Environ(), "KRB5CCNAME=MEMORY:distributive")
+		out, err := cmd.CombinedOutput()
+		if err != nil && strings.Contains(err.Error(), "executable file not found") {
+			log.Fatal("Kerberos checks require kinit")
+		} else if err == nil {
+			return 0, ""
+		}
+		msg := "Couldn't get a Kerberos ticket for " + principal + ":\n\t"
+		return 1, msg + strings.TrimSpace(string(out))
+	}
+}
+
+// getKeytabPrincipals returns the principals that have keys in this keytab,
+// as listed by `klist -k`
+func getKeytabPrincipals(keytab string) ([]string, error) {
+	out, err := exec.Command("klist", "-k", keytab).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Kerberos checks require klist")
+	} else if err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	// entries are lines like "   3 host/web1.example.com@EXAMPLE.COM"
+	var principals []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.Contains(fields[1], "@") &&
+			!strIn(fields[1], principals) {
+			principals = append(principals, fields[1])
+		}
+	}
+	return principals, nil
+}
+
+// keytabValid checks that this keytab (/etc/krb5.keytab by default) can be
+// read and has keys, for this principal if one is given
+func keytabValid(keytab string, principal string) Thunk {
+	if keytab == "" {
+		keytab = "/etc/krb5.keytab"
+	}
+	return func() (exitCode int, exitMessage string) {
+		principals, err := getKeytabPrincipals(keytab)
+		if err != nil {
+			return 1, "Couldn't read keytab " + keytab + ":\n\t" + err.Error()
+		} else if len(principals) == 0 {
+			return 1, "Keytab has no keys: " + keytab
+		} else if principal == "" || strIn(principal, principals) {
+			return 0, ""
+		}
+		msg := "Keytab has no keys for principal: " + keytab
+		return genericError(msg, principal, principals)
+	}
+}
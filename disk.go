@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// diskHistoryPath is where diskFillForecast keeps its usage samples between
+// runs, unless a checklist gives another path
+const diskHistoryPath = "/var/lib/distributive/disk-history.json"
+
+// diskHistoryWindow is how far back samples are used to estimate growth
+const diskHistoryWindow = 7 * 24 * time.Hour
+
+// diskHistoryMaxSamples caps the samples kept per filesystem
+const diskHistoryMaxSamples = 2000
+
+// diskSample is a filesystem's usage at a point in time
+type diskSample struct {
+	Time  time.Time
+	Used  uint64
+	Total uint64
+}
+
+// getDiskUsage returns the used and total bytes of the filesystem holding path
+func getDiskUsage(path string) diskSample {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		log.Fatal("Couldn't get filesystem usage for: " + path + "\n\t" + err.Error())
+	}
+	total := stat.Blocks * uint64(stat.Bsize)
+	// count space reserved for root as used, like df does
+	used := total - stat.Bavail*uint64(stat.Bsize)
+	return diskSample{time.Now(), used, total}
+}
+
+// recordDiskSample appends a sample for path to the history file, drops
+// samples outside the window, and returns the remaining ones
+func recordDiskSample(historyPath string, path string, sample diskSample) []diskSample {
+	history := make(map[string][]diskSample)
+	if data, err := ioutil.ReadFile(historyPath); err == nil {
+		if err := json.Unmarshal(data, &history); err != nil {
+			log.Fatal("Couldn't parse disk usage history: " + historyPath + "\n\t" + err.Error())
+		}
+	}
+	var samples []diskSample
+	for _, old := range append(history[path], sample) {
+		if sample.Time.Sub(old.Time) <= diskHistoryWindow {
+			samples = append(samples, old)
+		}
+	}
+	if len(samples) > diskHistoryMaxSamples {
+		samples = samples[len(samples)-diskHistoryMaxSamples:]
+	}
+	history[path] = samples
+	data, err := json.Marshal(history)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(historyPath), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(historyPath, data, 0644)
+	}
+	if err != nil {
+		log.Fatal("Couldn't write disk usage history: " + historyPath + "\n\t" + err.Error())
+	}
+	return samples
+}
+
+// diskGrowthRate estimates how many bytes per second usage is growing by,
+// with a least squares fit over the samples. It returns false if the samples
+// don't span enough time to say.
+func diskGrowthRate(samples []diskSample) (float64, bool) {
+	if len(samples) < 2 || samples[len(samples)-1].Time.Sub(samples[0].Time) < time.Hour {
+		return 0, false
+	}
+	start := samples[0].Time
+	var n, sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(start).Seconds()
+		y := float64(sample.Used)
+		n++
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// diskFillForecast checks that, at the rate its usage has grown over the last
+// week, the filesystem holding path won't fill up within this many days. Each
+// run records a sample in the history file, so the check only fails once it
+// has seen at least an hour of history.
+func diskFillForecast(path string, days int, historyPath string) Thunk {
+	if historyPath == "" {
+		historyPath = diskHistoryPath
+	}
+	return func() (exitCode int, exitMessage string) {
+		sample := getDiskUsage(path)
+		samples := recordDiskSample(historyPath, path, sample)
+		rate, ok := diskGrowthRate(samples)
+		if !ok || rate <= 0 {
+			return 0, ""
+		}
+		// in float days, since slow growth overflows a time.Duration
+		free := float64(sample.Total - sample.Used)
+		daysUntilFull := free / rate / (24 * 60 * 60)
+		if daysUntilFull > float64(days) {
+			return 0, ""
+		}
+		msg := "Filesystem is projected to fill up too soon: " + path
+		actual := fmt.Sprintf("%.1f days", daysUntilFull)
+		return genericError(msg, fmt.Sprint(days)+" days", []string{actual})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// dnsTypes are the record types that DNS checks can query
+var dnsTypes = map[string]uint16{
+	"A": 1, "NS": 2, "CNAME": 5, "SOA": 6, "MX": 15, "TXT": 16, "AAAA": 28,
+	"DS": 43, "DNSKEY": 48, "TLSA": 52, "CAA": 257,
+}
+
+// DNS header flags and response codes, from RFC 1035 and RFC 4035
+const (
+	dnsFlagTruncated     = 0x0200
+	dnsFlagRecursion     = 0x0100
+	dnsFlagAuthenticated = 0x0020
+	dnsRcodeServFail     = 2
+	dnsRcodeNXDomain     = 3
+)
+
+// getResolver returns the first nameserver in /etc/resolv.conf, with port
+func getResolver() string {
+	for _, line := range strings.Split(fileToString("/etc/resolv.conf"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// buildDNSQuery encodes a recursive query with the AD bit set, and an EDNS0
+// OPT record with the DO bit set, so that a validating resolver reports
+// whether the answer was authenticated
+func buildDNSQuery(id uint16, domain string, qtype uint16) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion|dnsFlagAuthenticated)
+	binary.BigEndian.PutUint16(msg[4:], 1)  // questions
+	binary.BigEndian.PutUint16(msg[10:], 1) // additional records
+	for _, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			log.Fatal("Invalid domain name: " + domain)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = append(msg, byte(qtype>>8), byte(qtype), 0, 1) // class IN
+	// OPT: root name, type 41, 4096 byte payload, DO bit, no data
+	msg = append(msg, 0, 0, 41, 0x10, 0, 0, 0, 0x80, 0, 0, 0)
+	return msg
+}
+
+// dnsResponse is the part of a DNS reply that DNSSEC checks care about
+type dnsResponse struct {
+	Authenticated bool
+	Rcode         int
+	Answers       int
+}
+
+// queryDNSSEC sends a DNSSEC-aware query to resolver, over TCP if the UDP
+// reply is truncated
+func queryDNSSEC(resolver string, domain string, qtype uint16) (dnsResponse, error) {
+	id := uint16(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1 << 16))
+	query := buildDNSQuery(id, domain, qtype)
+	reply, err := exchangeDNS("udp", resolver, query)
+	if err == nil && len(reply) >= 12 && binary.BigEndian.Uint16(reply[2:])&dnsFlagTruncated != 0 {
+		reply, err = exchangeDNS("tcp", resolver, query)
+	}
+	if err != nil {
+		return dnsResponse{}, err
+	}
+	if len(reply) < 12 || binary.BigEndian.Uint16(reply[0:]) != id {
+		return dnsResponse{}, fmt.Errorf("malformed reply from %s", resolver)
+	}
+	flags := binary.BigEndian.Uint16(reply[2:])
+	return dnsResponse{
+		Authenticated: flags&dnsFlagAuthenticated != 0,
+		Rcode:         int(flags & 0xF),
+		Answers:       int(binary.BigEndian.Uint16(reply[6:])),
+	}, nil
+}
+
+// exchangeDNS sends a query and reads the reply. Over TCP, messages are
+// prefixed with their length.
+func exchangeDNS(network string, resolver string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, resolver, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if network == "tcp" {
+		query = append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	if network == "udp" {
+		reply := make([]byte, 4096)
+		n, err := conn.Read(reply)
+		return reply[:n], err
+	}
+	length := make([]byte, 2)
+	if _, err := conn.Read(length); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length))
+	read := 0
+	for read < len(reply) {
+		n, err := conn.Read(reply[read:])
+		if err != nil {
+			return nil, err
+		}
+		read += n
+	}
+	return reply, nil
+}
+
+// dnssecValidated checks that a domain's records of the given type (A by
+// default) exist and are validated under DNSSEC by the resolver (the first
+// nameserver in /etc/resolv.conf by default), as shown by the AD bit. A
+// validating resolver answers SERVFAIL when validation fails.
+func dnssecValidated(domain string, recordType string, resolver string) Thunk {
+	if recordType == "" {
+		recordType = "A"
+	}
+	qtype, ok := dnsTypes[strings.ToUpper(recordType)]
+	if !ok {
+		log.Fatal("Unsupported DNS record type: " + recordType)
+	}
+	return func() (exitCode int, exitMessage string) {
+		server := resolver
+		if server == "" {
+			server = getResolver()
+		} else if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resp, err := queryDNSSEC(server, domain, qtype)
+		switch {
+		case err != nil:
+			return 1, "DNS query failed: " + domain + " via " + server + "\n\t" + err.Error()
+		case resp.Rcode == dnsRcodeServFail:
+			return 1, "Resolver returned SERVFAIL, DNSSEC validation may have failed: " + domain
+		case resp.Rcode == dnsRcodeNXDomain || resp.Answers == 0:
+			return 1, "No " + recordType + " records found for: " + domain
+		case !resp.Authenticated:
+			return 1, "Answer was not validated under DNSSEC (no AD bit): " + domain + " via " + server
+		}
+		return 0, ""
+	}
+}
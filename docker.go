@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 // DockerImage checks to see that the specified Docker image (e.g. "user/image",
 // "ubuntu", etc.) is downloaded (pulled) on the host
 func DockerImage(name string) Thunk {
 	getDockerImages := func() (images []string) {
-		cmd := exec.Command("docker", "images")
+		runtime, _ := getContainerRuntime()
+		cmd := exec.Command(runtime, "images")
 		return commandColumnNoHeader(0, cmd)
 	}
 	return func() (exitCode int, exitMessage string) {
@@ -26,14 +40,15 @@ func DockerImage(name string) Thunk {
 // (e.g. "user/container")
 func DockerRunning(name string) Thunk {
 	getRunningContainers := func() (images []string) {
-		out, err := exec.Command("docker", "ps", "-a").CombinedOutput()
+		runtime, _ := getContainerRuntime()
+		out, err := exec.Command(runtime, "ps", "-a").CombinedOutput()
 		outstr := string(out)
 		// `docker images` requires root permissions
 		if err != nil && strings.Contains(outstr, "permission denied") {
-			log.Fatal("Permission denied when running: docker ps -a")
+			log.Fatal("Permission denied when running: " + runtime + " ps -a")
 		}
 		if err != nil {
-			log.Fatal("Error while running `docker ps -a`" + "\n\t" + err.Error())
+			log.Fatal("Error while running `" + runtime + " ps -a`" + "\n\t" + err.Error())
 		}
 		// the output of `docker ps -a` has spaces in columns, but each column
 		// is separated by 2 or more spaces
@@ -58,3 +73,396 @@ func DockerRunning(name string) Thunk {
 		return genericError("Docker container not runnning", name, running)
 	}
 }
+
+// containerDiskUsageTypes maps the names checklists use to the Type column of
+// `docker system df` and `podman system df`
+var containerDiskUsageTypes = map[string]string{
+	"images":     "Images",
+	"containers": "Containers",
+	"volumes":    "Local Volumes",
+	"buildcache": "Build Cache",
+}
+
+// getContainerDiskUsage returns the disk space used by each type of object
+// (Images, Containers, Local Volumes, Build Cache), according to the engine's
+// `system df`. engine is "docker" or "podman".
+func getContainerDiskUsage(engine string) map[string]uint64 {
+	out, err := exec.Command(engine, "system", "df", "--format", "{{json .}}").CombinedOutput()
+	if err != nil {
+		msg := "Error while running `" + engine + " system df`:"
+		msg += "\n\tOutput: " + strings.TrimSpace(string(out))
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	usage := make(map[string]uint64)
+	for _, line := range strings.Split(string(out), "\n") {
+		var row struct {
+			Type string
+			Size string
+		}
+		if json.Unmarshal([]byte(line), &row) != nil || row.Type == "" {
+			continue
+		}
+		usage[row.Type] = parseSize(row.Size)
+	}
+	return usage
+}
+
+// containerDiskUsageBelow checks that a container engine's images, containers,
+// volumes, build cache, or all of them together ("total") use less than max
+func containerDiskUsageBelow(kind string, max uint64, engine string) Thunk {
+	kind = strings.ToLower(kind)
+	if _, ok := containerDiskUsageTypes[kind]; !ok && kind != "total" {
+		msg := "Unsupported container disk usage type: " + kind
+		msg += "\n\tSupported: images, containers, volumes, buildcache, total"
+		log.Fatal(msg)
+	}
+	return func() (exitCode int, exitMessage string) {
+		if engine == "" {
+			engine, _ = getContainerRuntime()
+		}
+		usage := getContainerDiskUsage(engine)
+		var used uint64
+		for _, dfType := range containerDiskUsageTypes {
+			if kind == "total" || containerDiskUsageTypes[kind] == dfType {
+				used += usage[dfType]
+			}
+		}
+		if used < max {
+			return 0, ""
+		}
+		msg := "Container disk usage exceeds maximum: " + engine + " " + kind
+		return genericError(msg, formatSize(max), []string{formatSize(used)})
+	}
+}
+
+// containerRuntimes are the container runtimes the container checks support,
+// in the order they're looked for. Podman serves the Docker Engine API too.
+var containerRuntimes = []string{"docker", "podman"}
+
+// getRuntimeSockets returns where a container runtime's API socket may be,
+// for both rootful and rootless setups, most likely first
+func getRuntimeSockets(runtime string) (sockets []string) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = "/run/user/" + fmt.Sprint(os.Getuid())
+	}
+	hostVar := map[string]string{"docker": "DOCKER_HOST", "podman": "CONTAINER_HOST"}
+	if host := os.Getenv(hostVar[runtime]); strings.HasPrefix(host, "unix://") {
+		sockets = append(sockets, strings.TrimPrefix(host, "unix://"))
+	}
+	rootful := map[string]string{
+		"docker": "/var/run/docker.sock",
+		"podman": "/run/podman/podman.sock",
+	}
+	rootless := map[string]string{
+		"docker": filepath.Join(runtimeDir, "docker.sock"),
+		"podman": filepath.Join(runtimeDir, "podman", "podman.sock"),
+	}
+	if os.Getuid() == 0 {
+		return append(sockets, rootful[runtime], rootless[runtime])
+	}
+	return append(sockets, rootless[runtime], rootful[runtime])
+}
+
+// getContainerRuntime detects the container runtime in use, and the path of
+// its API socket. A runtime whose socket exists is preferred, and otherwise
+// the first one installed is used, with its usual socket.
+func getContainerRuntime() (runtime string, socket string) {
+	for _, runtime := range containerRuntimes {
+		for _, socket := range getRuntimeSockets(runtime) {
+			info, err := os.Stat(socket)
+			if err == nil && info.Mode()&os.ModeSocket != 0 {
+				return runtime, socket
+			}
+		}
+	}
+	for _, runtime := range containerRuntimes {
+		if _, err := exec.LookPath(runtime); err == nil {
+			return runtime, getRuntimeSockets(runtime)[0]
+		}
+	}
+	log.Fatal("No container runtime found. Attempted: " + fmt.Sprint(containerRuntimes))
+	return "", "" // never reaches this return
+}
+
+// errDockerNotFound is returned by dockerAPI when the object asked for (e.g. a
+// volume or network) doesn't exist
+var errDockerNotFound = errors.New("no such object")
+
+// dockerAPI makes a GET request to the Docker Engine API over the container
+// runtime's unix socket, decoding the JSON response into v (if it isn't nil),
+// and returns the response headers
+func dockerAPI(path string, v interface{}) (http.Header, error) {
+	_, socket := getContainerRuntime()
+	client := http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	// the host is ignored, since the connection is always to the socket
+	resp, err := client.Get("http://docker" + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.Header, errDockerNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		var apiErr struct{ Message string }
+		json.Unmarshal(body, &apiErr)
+		return resp.Header, fmt.Errorf("%s: %s %s", path, resp.Status, apiErr.Message)
+	}
+	if v != nil {
+		err = json.Unmarshal(body, v)
+	}
+	return resp.Header, err
+}
+
+// dockerDaemonHealthy checks that the container runtime answers a ping on its
+// API socket, and, if minVersion isn't empty, that it speaks at least that
+// Docker API version (e.g. "1.41")
+func dockerDaemonHealthy(minVersion string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		header, err := dockerAPI("/_ping", nil)
+		if err != nil {
+			runtime, socket := getContainerRuntime()
+			msg := "Container runtime is not responding: " + runtime + " (" + socket + ")"
+			return 1, msg + "\n\t" + err.Error()
+		}
+		version := header.Get("API-Version")
+		if minVersion == "" || compareVersions(version, minVersion) >= 0 {
+			return 0, ""
+		}
+		msg := "Docker API version is too old"
+		return genericError(msg, minVersion, []string{version})
+	}
+}
+
+// dockerStorageDriverIs checks that the Docker daemon uses this storage
+// driver (e.g. "overlay2")
+func dockerStorageDriverIs(driver string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var info struct{ Driver string }
+		if _, err := dockerAPI("/info", &info); err != nil {
+			return 1, "Couldn't get Docker daemon info:\n\t" + err.Error()
+		}
+		if info.Driver == driver {
+			return 0, ""
+		}
+		return genericError("Docker storage driver does not match", driver, []string{info.Driver})
+	}
+}
+
+// getRegistryAuth returns the base64 "user:password" credentials stored for a
+// registry by `docker login` (in config.json) or `podman login` (in
+// auth.json, which has the same format)
+func getRegistryAuth(registry string) string {
+	home, _ := os.UserHomeDir()
+	dockerDir := os.Getenv("DOCKER_CONFIG")
+	if dockerDir == "" {
+		dockerDir = filepath.Join(home, ".docker")
+	}
+	paths := []string{
+		filepath.Join(dockerDir, "config.json"),
+		os.Getenv("REGISTRY_AUTH_FILE"),
+		filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "containers", "auth.json"),
+		filepath.Join(home, ".config", "containers", "auth.json"),
+	}
+	for _, path := range paths {
+		var config struct {
+			Auths map[string]struct{ Auth string }
+		}
+		data, err := ioutil.ReadFile(path)
+		if path == "" || err != nil || json.Unmarshal(data, &config) != nil {
+			continue
+		}
+		for _, key := range []string{registry, "https://" + registry, "https://" + registry + "/v1/"} {
+			if auth, ok := config.Auths[key]; ok && auth.Auth != "" {
+				return auth.Auth
+			}
+		}
+	}
+	return ""
+}
+
+// bearerChallengeRe parses the parameters of a WWW-Authenticate challenge,
+// like: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+var bearerChallengeRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// probeRegistry requests the registry's /v2/ endpoint, authenticating with
+// the given base64 credentials (if not empty) by basic auth or, when the
+// registry asks for it, a bearer token. It returns the final status code.
+func probeRegistry(registry string, auth string) (int, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	url := "https://" + registry + "/v2/"
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || auth == "" {
+		return resp.StatusCode, nil
+	}
+	req, _ := http.NewRequest("GET", url, nil)
+	if strings.HasPrefix(strings.ToLower(challenge), "bearer") {
+		params := make(map[string]string)
+		for _, match := range bearerChallengeRe.FindAllStringSubmatch(challenge, -1) {
+			params[match[1]] = match[2]
+		}
+		tokenReq, err := http.NewRequest("GET", params["realm"], nil)
+		if err != nil {
+			return 0, err
+		}
+		query := tokenReq.URL.Query()
+		query.Set("service", params["service"])
+		tokenReq.URL.RawQuery = query.Encode()
+		tokenReq.Header.Set("Authorization", "Basic "+auth)
+		tokenResp, err := client.Do(tokenReq)
+		if err != nil {
+			return 0, err
+		}
+		defer tokenResp.Body.Close()
+		var token struct {
+			Token       string
+			AccessToken string `json:"access_token"`
+		}
+		json.NewDecoder(tokenResp.Body).Decode(&token)
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if tokenResp.StatusCode != http.StatusOK || token.Token == "" {
+			return tokenResp.StatusCode, nil
+		}
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	} else {
+		req.Header.Set("Authorization", "Basic "+auth)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// registryReachable checks that a container registry (e.g. "ghcr.io" or
+// "registry.example.com:5000") answers on its v2 API. If authenticated is
+// true, it must also accept the credentials `docker login` saved for it.
+func registryReachable(registry string, authenticated bool) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var auth string
+		if authenticated {
+			if auth = getRegistryAuth(registry); auth == "" {
+				return 1, "No saved credentials for registry: " + registry
+			}
+		}
+		status, err := probeRegistry(registry, auth)
+		if err != nil {
+			return 1, "Couldn't reach registry: " + registry + "\n\t" + err.Error()
+		}
+		// without credentials, a challenge still shows the registry is up
+		if status == http.StatusOK || (!authenticated && status == http.StatusUnauthorized) {
+			return 0, ""
+		}
+		msg := "Registry did not accept request: " + registry
+		return genericError(msg, fmt.Sprint(http.StatusOK), []string{fmt.Sprint(status)})
+	}
+}
+
+// dockerVolumeExists checks that a Docker volume with this name exists
+func dockerVolumeExists(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		_, err := dockerAPI("/volumes/"+url.PathEscape(name), nil)
+		if err == nil {
+			return 0, ""
+		} else if err == errDockerNotFound {
+			return 1, "Docker volume does not exist: " + name
+		}
+		return 1, "Couldn't inspect Docker volume: " + name + "\n\t" + err.Error()
+	}
+}
+
+// dockerNetworkExists checks that a Docker network with this name exists,
+// and, if they aren't empty, that it uses this driver (e.g. "bridge") and has
+// this subnet (e.g. "172.20.0.0/16")
+func dockerNetworkExists(name string, driver string, subnet string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var network struct {
+			Driver string
+			IPAM   struct {
+				Config []struct{ Subnet string }
+			}
+		}
+		_, err := dockerAPI("/networks/"+url.PathEscape(name), &network)
+		if err == errDockerNotFound {
+			return 1, "Docker network does not exist: " + name
+		} else if err != nil {
+			return 1, "Couldn't inspect Docker network: " + name + "\n\t" + err.Error()
+		}
+		if driver != "" && network.Driver != driver {
+			msg := "Docker network has the wrong driver: " + name
+			return genericError(msg, driver, []string{network.Driver})
+		}
+		var subnets []string
+		for _, config := range network.IPAM.Config {
+			subnets = append(subnets, config.Subnet)
+		}
+		if subnet != "" && !strIn(subnet, subnets) {
+			msg := "Docker network does not have subnet: " + name
+			return genericError(msg, subnet, subnets)
+		}
+		return 0, ""
+	}
+}
+
+// composeProjectUp checks that every service of a Docker Compose project has
+// a running container. Compose labels the containers it creates with their
+// project and service.
+func composeProjectUp(project string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var containers []struct {
+			Names  []string
+			State  string
+			Labels map[string]string
+		}
+		filters := `{"label":["com.docker.compose.project=` + project + `"]}`
+		path := "/containers/json?all=1&filters=" + url.QueryEscape(filters)
+		if _, err := dockerAPI(path, &containers); err != nil {
+			return 1, "Couldn't list Docker containers:\n\t" + err.Error()
+		}
+		running := make(map[string]bool)
+		for _, container := range containers {
+			// one-off containers are from `docker compose run`
+			if container.Labels["com.docker.compose.oneoff"] == "True" {
+				continue
+			}
+			service := container.Labels["com.docker.compose.service"]
+			running[service] = running[service] || container.State == "running"
+		}
+		if len(running) == 0 {
+			return 1, "Compose project has no containers: " + project
+		}
+		var down []string
+		for service, up := range running {
+			if !up {
+				down = append(down, service)
+			}
+		}
+		if len(down) == 0 {
+			return 0, ""
+		}
+		sort.Strings(down)
+		msg := "Compose project has services that aren't running: " + project
+		return genericError(msg, "all running", down)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"debug/elf"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultLibraryDirs are searched after the loader cache, like ld.so does
+var defaultLibraryDirs = []string{"/lib", "/usr/lib", "/lib64", "/usr/lib64"}
+
+// openELF opens an ELF file, failing if it isn't one
+func openELF(path string) *elf.File {
+	file, err := elf.Open(path)
+	if err != nil {
+		log.Fatal("Couldn't read ELF file: " + path + "\n\t" + err.Error())
+	}
+	return file
+}
+
+// getLoaderCache returns the paths of every library in the ld.so cache, by
+// soname, from `ldconfig -p`, whose lines look like:
+// libc.so.6 (libc6,x86-64) => /lib/x86_64-linux-gnu/libc.so.6
+func getLoaderCache() map[string][]string {
+	cache := make(map[string][]string)
+	out, err := exec.Command("ldconfig", "-p").Output()
+	if err != nil {
+		out, err = exec.Command("/sbin/ldconfig", "-p").Output()
+	}
+	if err != nil {
+		return cache
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, "=>", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) == 2 && len(fields) > 0 {
+			cache[fields[0]] = append(cache[fields[0]], strings.TrimSpace(parts[1]))
+		}
+	}
+	return cache
+}
+
+// elfSearchPaths returns the directories named by a binary's DT_RPATH and
+// DT_RUNPATH, with $ORIGIN expanded
+func elfSearchPaths(file *elf.File, path string) (dirs []string) {
+	origin := filepath.Dir(path)
+	for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+		values, _ := file.DynString(tag)
+		for _, value := range values {
+			for _, dir := range strings.Split(value, ":") {
+				dir = strings.Replace(dir, "${ORIGIN}", origin, -1)
+				dirs = append(dirs, strings.Replace(dir, "$ORIGIN", origin, -1))
+			}
+		}
+	}
+	return dirs
+}
+
+// compatibleELF reports whether the ELF file at path could be loaded alongside
+// a binary with the given class and machine
+func compatibleELF(path string, class elf.Class, machine elf.Machine) bool {
+	file, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return file.Class == class && file.Machine == machine
+}
+
+// resolveLibrary finds the file the dynamic loader would use for a DT_NEEDED
+// entry, searching the RPATH/RUNPATH, LD_LIBRARY_PATH, the loader cache, and
+// the default directories, in that order
+func resolveLibrary(name string, searchPaths []string, cache map[string][]string, class elf.Class, machine elf.Machine) (string, bool) {
+	if strings.Contains(name, "/") {
+		return name, compatibleELF(name, class, machine)
+	}
+	var candidates []string
+	dirs := append([]string{}, searchPaths...)
+	dirs = append(dirs, filepath.SplitList(os.Getenv("LD_LIBRARY_PATH"))...)
+	for _, dir := range dirs {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	candidates = append(candidates, cache[name]...)
+	for _, dir := range defaultLibraryDirs {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	for _, candidate := range candidates {
+		if compatibleELF(candidate, class, machine) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// getLinkedLibraries resolves a binary's dynamic dependencies, including
+// indirect ones, like ldd does, without running the binary. It returns the
+// resolved path of each soname, and the sonames that couldn't be found.
+func getLinkedLibraries(path string) (resolved map[string]string, missing []string) {
+	binary := openELF(path)
+	defer binary.Close()
+	cache := getLoaderCache()
+	resolved = make(map[string]string)
+	var visit func(file *elf.File, path string)
+	visit = func(file *elf.File, path string) {
+		needed, err := file.ImportedLibraries()
+		if err != nil {
+			log.Fatal("Couldn't read dynamic section of: " + path + "\n\t" + err.Error())
+		}
+		searchPaths := elfSearchPaths(file, path)
+		for _, name := range needed {
+			if _, ok := resolved[name]; ok || strIn(name, missing) {
+				continue
+			}
+			libPath, ok := resolveLibrary(name, searchPaths, cache, binary.Class, binary.Machine)
+			if !ok {
+				missing = append(missing, name)
+				continue
+			}
+			resolved[name] = libPath
+			if lib, err := elf.Open(libPath); err == nil {
+				visit(lib, libPath)
+				lib.Close()
+			}
+		}
+	}
+	visit(binary, path)
+	return resolved, missing
+}
+
+// librariesResolve checks that every shared library a binary needs, directly
+// or indirectly, can be found by the dynamic loader
+func librariesResolve(path string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		_, missing := getLinkedLibraries(path)
+		if len(missing) == 0 {
+			return 0, ""
+		}
+		return genericError("Shared libraries not found for binary", path, missing)
+	}
+}
+
+// linksAgainst checks that a binary links against a library with this soname
+// (e.g. libssl.so.3), or, if library has no version, any version of it (e.g.
+// libssl.so)
+func linksAgainst(path string, library string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		resolved, _ := getLinkedLibraries(path)
+		var sonames []string
+		for soname := range resolved {
+			if soname == library || strings.HasPrefix(soname, library+".") {
+				return 0, ""
+			}
+			sonames = append(sonames, soname)
+		}
+		sort.Strings(sonames)
+		msg := "Binary does not link against library: " + path
+		return genericError(msg, library, sonames)
+	}
+}
+
+// hasProgramHeader returns the first program header of this type, if any
+func hasProgramHeader(file *elf.File, progType elf.ProgType) (*elf.Prog, bool) {
+	for _, prog := range file.Progs {
+		if prog.Type == progType {
+			return prog, true
+		}
+	}
+	return nil, false
+}
+
+// dynFlagSet reports whether any DT_FLAGS or DT_FLAGS_1 entry has this bit
+func dynFlagSet(file *elf.File, tag elf.DynTag, flag uint64) bool {
+	values, _ := file.DynValue(tag)
+	for _, value := range values {
+		if value&flag != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// elfIsPIE reports whether a binary is a position independent executable,
+// rather than a fixed-address executable or a shared library
+func elfIsPIE(file *elf.File) bool {
+	if file.Type != elf.ET_DYN {
+		return false
+	}
+	_, interpreted := hasProgramHeader(file, elf.PT_INTERP)
+	return interpreted || dynFlagSet(file, elf.DT_FLAGS_1, uint64(elf.DF_1_PIE))
+}
+
+// elfRELRO returns "full" if the binary's relocations are made read-only and
+// bound at load time, "partial" if they're read-only after lazy binding, and
+// "none" otherwise
+func elfRELRO(file *elf.File) string {
+	if _, ok := hasProgramHeader(file, elf.PT_GNU_RELRO); !ok {
+		return "none"
+	}
+	bindNow, _ := file.DynValue(elf.DT_BIND_NOW)
+	if len(bindNow) > 0 || dynFlagSet(file, elf.DT_FLAGS, uint64(elf.DF_BIND_NOW)) ||
+		dynFlagSet(file, elf.DT_FLAGS_1, uint64(elf.DF_1_NOW)) {
+		return "full"
+	}
+	return "partial"
+}
+
+// elfHasStackCanary reports whether a binary references the stack protector
+func elfHasStackCanary(file *elf.File) bool {
+	symbols, _ := file.DynamicSymbols()
+	static, _ := file.Symbols()
+	for _, symbol := range append(symbols, static...) {
+		if strings.HasPrefix(symbol.Name, "__stack_chk_fail") ||
+			strings.HasPrefix(symbol.Name, "__stack_chk_guard") {
+			return true
+		}
+	}
+	return false
+}
+
+// elfHasExecStack reports whether a binary asks for an executable stack.
+// Without a PT_GNU_STACK header, the kernel assumes it does.
+func elfHasExecStack(file *elf.File) bool {
+	prog, ok := hasProgramHeader(file, elf.PT_GNU_STACK)
+	return !ok || prog.Flags&elf.PF_X != 0
+}
+
+// elfProperty is an abstraction of the ELF hardening checks. property returns
+// whether the binary passes, and its actual value for the error message.
+func elfProperty(path string, msg string, property func(*elf.File) (bool, string)) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		file := openELF(path)
+		defer file.Close()
+		ok, actual := property(file)
+		if ok {
+			return 0, ""
+		}
+		return genericError(msg, path, []string{actual})
+	}
+}
+
+// elfPIE checks that a binary is a position independent executable
+func elfPIE(path string) Thunk {
+	return elfProperty(path, "Binary is not PIE", func(file *elf.File) (bool, string) {
+		return elfIsPIE(file), file.Type.String()
+	})
+}
+
+// elfRELROThunk checks that a binary has at least partial RELRO, or full
+// RELRO if full is true
+func elfRELROThunk(path string, full bool) Thunk {
+	msg := "Binary does not have RELRO"
+	if full {
+		msg = "Binary does not have full RELRO"
+	}
+	return elfProperty(path, msg, func(file *elf.File) (bool, string) {
+		relro := elfRELRO(file)
+		return relro == "full" || (relro == "partial" && !full), relro
+	})
+}
+
+// elfStackCanary checks that a binary was built with a stack protector
+func elfStackCanary(path string) Thunk {
+	msg := "Binary was not built with a stack protector"
+	return elfProperty(path, msg, func(file *elf.File) (bool, string) {
+		return elfHasStackCanary(file), "no __stack_chk_fail symbol"
+	})
+}
+
+// elfNoExecStack checks that a binary doesn't need an executable stack
+func elfNoExecStack(path string) Thunk {
+	msg := "Binary has an executable stack"
+	return elfProperty(path, msg, func(file *elf.File) (bool, string) {
+		return !elfHasExecStack(file), "executable stack"
+	})
+}
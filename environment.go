@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// parseEnvironment turns a list of VAR=value strings into a map
+func parseEnvironment(entries []string) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
+
+// getProcessEnvironments returns the environment of every process whose
+// command name (/proc/<pid>/comm) is name, keyed by PID. Processes whose
+// environment can't be read (usually for lack of privileges) are skipped.
+func getProcessEnvironments(name string) map[string]map[string]string {
+	envs := make(map[string]map[string]string)
+	for _, pid := range getPIDs(name) {
+		environ, err := ioutil.ReadFile(filepath.Join("/proc", pid, "environ"))
+		if err != nil {
+			continue
+		}
+		entries := strings.Split(string(environ), "\x00")
+		envs[pid] = parseEnvironment(entries)
+	}
+	return envs
+}
+
+// splitQuoted splits a string on spaces, respecting double quotes, as in the
+// Environment property printed by `systemctl show`
+func splitQuoted(str string) (fields []string) {
+	var field []rune
+	quoted := false
+	inField := false
+	for _, r := range str {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case r == ' ' && !quoted:
+			if inField {
+				fields = append(fields, string(field))
+			}
+			field = field[:0]
+			inField = false
+		default:
+			field = append(field, r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, string(field))
+	}
+	return fields
+}
+
+// getUnitEnvironment returns the variables set by a unit's Environment=
+func getUnitEnvironment(unit string, user string) map[string]string {
+	return parseEnvironment(splitQuoted(getUnitProperty(unit, "Environment", user)))
+}
+
+// envSatisfies reports whether env sets the variable in spec, which is either
+// VAR (set to anything) or VAR=value
+func envSatisfies(env map[string]string, spec string) bool {
+	parts := strings.SplitN(spec, "=", 2)
+	value, ok := env[parts[0]]
+	if len(parts) == 1 {
+		return ok
+	}
+	return ok && value == parts[1]
+}
+
+// envNames returns the variable names in env, for error messages
+func envNames(env map[string]string) (names []string) {
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// processEnvironment checks that every process with this command name has
+// (or, if absent is true, lacks) the variable in spec
+func processEnvironment(name string, spec string, absent bool) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		envs := getProcessEnvironments(name)
+		if len(envs) == 0 {
+			return 1, "No readable process with name: " + name
+		}
+		for pid, env := range envs {
+			if envSatisfies(env, spec) == absent {
+				msg := "Process environment does not have variable: "
+				if absent {
+					msg = "Process environment has variable: "
+				}
+				msg += name + " (PID " + pid + ")"
+				return genericError(msg, spec, envNames(env))
+			}
+		}
+		return 0, ""
+	}
+}
+
+// unitEnvironment checks that a unit's Environment= has (or, if absent is
+// true, lacks) the variable in spec
+func unitEnvironment(unit string, spec string, absent bool, user string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		env := getUnitEnvironment(unit, user)
+		if envSatisfies(env, spec) != absent {
+			return 0, ""
+		}
+		msg := "Unit environment does not have variable: " + unit
+		if absent {
+			msg = "Unit environment has variable: " + unit
+		}
+		return genericError(msg, spec, envNames(env))
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// showFacts and factsFormat are set by the -facts and -output flags, to print
+// this host's facts instead of running checks
+var showFacts bool
+var factsFormat string
+
+// osFamilies map distribution IDs from os-release to the family they belong
+// to, for distributions whose ID_LIKE doesn't say
+var osFamilies = map[string]string{
+	"debian": "debian", "ubuntu": "debian", "raspbian": "debian",
+	"rhel": "redhat", "centos": "redhat", "fedora": "redhat", "rocky": "redhat",
+	"almalinux": "redhat", "amzn": "redhat", "ol": "redhat",
+	"arch": "arch", "manjaro": "arch", "alpine": "alpine",
+	"opensuse": "suse", "sles": "suse",
+}
+
+// getOSRelease returns the fields of /etc/os-release, with quotes removed
+func getOSRelease() map[string]string {
+	release := make(map[string]string)
+	data := fileToStringOrEmpty("/etc/os-release")
+	if data == "" {
+		data = fileToStringOrEmpty("/usr/lib/os-release")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := parts[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, "'")
+		}
+		release[parts[0]] = value
+	}
+	return release
+}
+
+// getOSFamily returns the family of the distribution with this os-release,
+// like "debian" or "redhat"
+func getOSFamily(release map[string]string) string {
+	for _, id := range append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...) {
+		if family, ok := osFamilies[id]; ok {
+			return family
+		}
+	}
+	return release["ID"]
+}
+
+// getArch returns the host's machine architecture as `uname -m` prints it
+// (e.g. "x86_64" or "aarch64"), which may differ from the one distributive was
+// built for, e.g. a 386 build on an x86_64 host
+func getArch() string {
+	out, err := exec.Command("uname", "-m").Output()
+	if err != nil {
+		return runtime.GOARCH
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// getCPUModel returns the CPU's model name from /proc/cpuinfo
+func getCPUModel() string {
+	for _, line := range strings.Split(fileToStringOrEmpty("/proc/cpuinfo"), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		key := strings.TrimSpace(parts[0])
+		// x86 says "model name", and ARM says "Model" or "Hardware"
+		if len(parts) == 2 && (key == "model name" || key == "Model" || key == "Hardware") {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// getDisks returns the names of this host's block devices, leaving out
+// virtual ones like loop devices and ramdisks
+func getDisks() (disks []string) {
+	entries, _ := ioutil.ReadDir("/sys/block")
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "loop") && !strings.HasPrefix(name, "ram") &&
+			!strings.HasPrefix(name, "zram") {
+			disks = append(disks, name)
+		}
+	}
+	return disks
+}
+
+// getPackageManagerFact returns the first package manager installed here, or
+// "none"
+func getPackageManagerFact() string {
+	for _, manager := range packageManagers {
+		if _, err := exec.LookPath(manager); err == nil {
+			return manager
+		}
+	}
+	return "none"
+}
+
+// facts is the cache for getFacts, since gathering them runs commands
+var facts map[string]string
+
+// getFacts returns what Distributive knows about this host, for use in
+// checks' When conditions and parameters. Lists, like interfaces, are
+// comma-separated, and sizes are in bytes.
+func getFacts() map[string]string {
+	if facts != nil {
+		return facts
+	}
+	release := getOSRelease()
+	facts = map[string]string{
+		"os":              release["ID"],
+		"os_family":       getOSFamily(release),
+		"os_name":         release["PRETTY_NAME"],
+		"release":         release["VERSION_ID"],
+		"kernel":          strings.TrimSpace(fileToStringOrEmpty("/proc/sys/kernel/osrelease")),
+		"arch":            getArch(),
+		"cpus":            fmt.Sprint(runtime.NumCPU()),
+		"cpu_model":       getCPUModel(),
+		"memory":          fmt.Sprint(getMeminfo()["MemTotal"]),
+		"disks":           strings.Join(getDisks(), ","),
+		"package_manager": getPackageManagerFact(),
+		"init_system":     detectInitSystem(),
+		"virt":            getVirtualization(),
+		"container":       getContainer(),
+	}
+	facts["hostname"], _ = os.Hostname()
+	var names []string
+	if interfaces, err := net.Interfaces(); err == nil {
+		for _, iface := range interfaces {
+			names = append(names, iface.Name)
+		}
+	}
+	sort.Strings(names)
+	facts["interfaces"] = strings.Join(names, ",")
+	return facts
+}
+
+// factReference matches a reference to a fact in a parameter, like
+// "{{hostname}}"
+var factReference = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// unknownFact exits with a message listing the facts that do exist, when a
+// When condition or parameter refers to one that doesn't
+func unknownFact(where string, name string) {
+	var known []string
+	for name := range getFacts() {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	msg := "Unknown fact in " + where + ": " + name
+	msg += "\n\tKnown facts: " + strings.Join(known, ", ")
+	log.Fatal(msg)
+}
+
+// expandFacts replaces references to facts in a check's parameters, like
+// "{{hostname}}", with their values on this host
+func expandFacts(parameters []string) []string {
+	var expanded []string
+	for _, parameter := range parameters {
+		if strings.Contains(parameter, "{{") {
+			parameter = factReference.ReplaceAllStringFunc(parameter, func(ref string) string {
+				name := strings.TrimSpace(ref[2 : len(ref)-2])
+				value, ok := getFacts()[name]
+				if !ok {
+					unknownFact("parameter", ref)
+				}
+				return value
+			})
+		}
+		expanded = append(expanded, parameter)
+	}
+	return expanded
+}
+
+// printFacts prints this host's facts in the format given with -output,
+// either "text" (one "name: value" per line) or "json"
+func printFacts() {
+	facts := getFacts()
+	switch factsFormat {
+	case "json":
+		out, err := json.MarshalIndent(facts, "", "    ")
+		if err != nil {
+			log.Fatal("Couldn't encode facts:\n\t" + err.Error())
+		}
+		fmt.Println(string(out))
+	case "text", "":
+		var names []string
+		for name := range facts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name + ": " + facts[name])
+		}
+	default:
+		log.Fatal("Unsupported output format: " + factsFormat + "\n\tSupported: text, json")
+	}
+}
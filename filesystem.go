@@ -6,16 +6,27 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"unsafe"
 )
 
 type fileTypeCheck func(path string) (bool, error)
 
 // isType checks if the resource at path is of the type specified by name by
 // passing path to checker. Mostly used to abstract Directory, File, Symlink.
-func isType(name string, checker fileTypeCheck, path string) (exitCode int, message string) {
-	boo, err := checker(path)
+// If follow is false, a symlink at path is checked rather than its target.
+func isType(name string, checker fileTypeCheck, path string, follow bool) (exitCode int, message string) {
+	resolved := hostPath(path)
+	if !follow {
+		resolved = hostLinkPath(path)
+	}
+	boo, err := checker(resolved)
 	if os.IsNotExist(err) {
 		return 1, "No such file or directory: " + path
 	}
@@ -33,6 +44,9 @@ func File(path string) Thunk {
 	// returns true if there is a regular ol' file at path
 	isFile := func(path string) (bool, error) {
 		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
 		if fileInfo.Mode().IsRegular() {
 			return true, err
 		}
@@ -40,7 +54,7 @@ func File(path string) Thunk {
 	}
 
 	return func() (exitCode int, exitMessage string) {
-		return isType("file", isFile, path)
+		return isType("file", isFile, path, true)
 	}
 }
 
@@ -48,13 +62,16 @@ func File(path string) Thunk {
 func Directory(path string) Thunk {
 	isDirectory := func(path string) (bool, error) {
 		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
 		if fileInfo.Mode().IsDir() {
 			return true, err
 		}
 		return false, err
 	}
 	return func() (exitCode int, exitMessage string) {
-		return isType("directory", isDirectory, path)
+		return isType("directory", isDirectory, path, true)
 	}
 }
 
@@ -69,7 +86,7 @@ func Symlink(path string) Thunk {
 		return false, err
 	}
 	return func() (exitCode int, exitMessage string) {
-		return isType("symlink", isSymlink, path)
+		return isType("symlink", isSymlink, path, false)
 	}
 }
 
@@ -97,7 +114,7 @@ func Checksum(algorithm string, checkAgainst string, path string) Thunk {
 
 	}
 	getFileChecksum := func(algorithm string, path string) (checksum string) {
-		return getChecksum(algorithm, fileToBytes(path))
+		return getChecksum(algorithm, fileToBytes(hostPath(path)))
 	}
 	return func() (exitCode int, exitMessage string) {
 		chksum := getFileChecksum(algorithm, path)
@@ -108,3 +125,258 @@ func Checksum(algorithm string, checkAgainst string, path string) Thunk {
 		return genericError(msg, checkAgainst, []string{chksum})
 	}
 }
+
+// readFileOrFail reads a file for the file content checks, returning an
+// exit message rather than aborting if it can't be read
+func readFileOrFail(path string) (string, string) {
+	data, err := ioutil.ReadFile(hostPath(path))
+	if err != nil {
+		return "", "Couldn't read file: " + path + "\n\t" + err.Error()
+	}
+	return string(data), ""
+}
+
+// fileContains checks that a file contains this string
+func fileContains(path string, str string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		data, errMsg := readFileOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		if strings.Contains(data, str) {
+			return 0, ""
+		}
+		return 1, "File does not contain string: " + path + "\n\tString: " + str
+	}
+}
+
+// fileNotContains checks that a file doesn't contain this string
+func fileNotContains(path string, str string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		data, errMsg := readFileOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		for i, line := range strings.Split(data, "\n") {
+			if strings.Contains(line, str) {
+				msg := "File contains string: " + path
+				msg += "\n\tString: " + str
+				msg += "\n\tLine " + fmt.Sprint(i+1) + ": " + line
+				return 1, msg
+			}
+		}
+		if strings.Contains(data, str) {
+			return 1, "File contains string: " + path + "\n\tString: " + str
+		}
+		return 0, ""
+	}
+}
+
+// fileMatchesRegex checks that some line of a file matches a regular
+// expression or, if multiline is true, that the whole file does (so the
+// expression can span lines)
+func fileMatchesRegex(path string, pattern string, multiline bool) Thunk {
+	re := compileRegex(pattern)
+	return func() (exitCode int, exitMessage string) {
+		data, errMsg := readFileOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		if multiline && re.MatchString(data) {
+			return 0, ""
+		}
+		for _, line := range strings.Split(data, "\n") {
+			if !multiline && re.MatchString(line) {
+				return 0, ""
+			}
+		}
+		return 1, "File does not match regexp: " + path + "\n\tRegexp: " + pattern
+	}
+}
+
+// getConfigDirective returns every value a config file gives a key, in order.
+// It understands both `key value` and `key = value` styles, and skips blank
+// lines and comments starting with # or ;.
+func getConfigDirective(data string, key string) (values []string) {
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		i := strings.IndexAny(line, "= \t")
+		if i < 0 || strings.TrimSpace(line[:i]) != key {
+			continue
+		}
+		value := strings.TrimSpace(line[i:])
+		value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+		values = append(values, strings.Trim(value, `"'`))
+	}
+	return values
+}
+
+// configDirective checks that a config file sets key to value. If the key is
+// set more than once, the last value is the one that counts, as in most
+// config formats.
+func configDirective(path string, key string, value string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		data, errMsg := readFileOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		values := getConfigDirective(data, key)
+		if len(values) > 0 && values[len(values)-1] == value {
+			return 0, ""
+		}
+		msg := "Config directive does not have value: " + path + ": " + key
+		return genericError(msg, value, values)
+	}
+}
+
+// readDirOrFail lists a directory for the directory content checks,
+// returning an exit message rather than aborting if it can't be read
+func readDirOrFail(path string) ([]os.FileInfo, string) {
+	entries, err := ioutil.ReadDir(hostPath(path))
+	if err != nil {
+		return nil, "Couldn't read directory: " + path + "\n\t" + err.Error()
+	}
+	return entries, ""
+}
+
+// entryNames returns the names of directory entries, for error messages
+func entryNames(entries []os.FileInfo) (names []string) {
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// dirEmpty checks that a directory has no entries
+func dirEmpty(path string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		entries, errMsg := readDirOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		if len(entries) == 0 {
+			return 0, ""
+		}
+		return genericError("Directory is not empty", path, entryNames(entries))
+	}
+}
+
+// dirFileCountBetween checks that a directory directly contains at least min
+// and at most max files, not counting subdirectories. Useful for spool
+// directories and inboxes, where a growing count means a consumer is behind.
+func dirFileCountBetween(path string, min int, max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		entries, errMsg := readDirOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		count := 0
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				count++
+			}
+		}
+		if count >= min && count <= max {
+			return 0, ""
+		}
+		msg := "Directory file count is out of range: " + path
+		specified := fmt.Sprint(min) + " to " + fmt.Sprint(max)
+		return genericError(msg, specified, []string{fmt.Sprint(count)})
+	}
+}
+
+// dirContainsMatching checks that a directory has an entry whose name matches
+// a glob (e.g. *.done) or, if regex is true, a regular expression
+func dirContainsMatching(path string, pattern string, regex bool) Thunk {
+	matches := func(name string) bool {
+		matched, err := filepath.Match(pattern, name)
+		if err != nil {
+			log.Fatal("Invalid glob: " + pattern + "\n\t" + err.Error())
+		}
+		return matched
+	}
+	if regex {
+		matches = compileRegex(pattern).MatchString
+	}
+	return func() (exitCode int, exitMessage string) {
+		entries, errMsg := readDirOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		for _, entry := range entries {
+			if matches(entry.Name()) {
+				return 0, ""
+			}
+		}
+		msg := "Directory has no entry matching: " + path
+		return genericError(msg, pattern, entryNames(entries))
+	}
+}
+
+// inode flags, from linux/fs.h, as set by chattr
+const (
+	fsIocGetFlags   = 0x80086601
+	fsImmutableFlag = 0x00000010
+	fsAppendFlag    = 0x00000020
+)
+
+// getInodeFlags returns a file's inode flags, like lsattr does
+func getInodeFlags(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var flags uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fsIocGetFlags, uintptr(unsafe.Pointer(&flags)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return flags, nil
+}
+
+// inodeFlagSet is an abstraction of fileImmutable and fileAppendOnly
+func inodeFlagSet(path string, flag uint32, name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		flags, err := getInodeFlags(path)
+		if err != nil {
+			return 1, "Couldn't read file attributes: " + path + "\n\t" + err.Error()
+		}
+		if flags&flag != 0 {
+			return 0, ""
+		}
+		return 1, "File is not " + name + ": " + path
+	}
+}
+
+// fileImmutable checks that a file has the immutable attribute (chattr +i)
+func fileImmutable(path string) Thunk {
+	return inodeFlagSet(path, fsImmutableFlag, "immutable")
+}
+
+// fileAppendOnly checks that a file has the append-only attribute (chattr +a)
+func fileAppendOnly(path string) Thunk {
+	return inodeFlagSet(path, fsAppendFlag, "append-only")
+}
+
+// fileHasXattr checks that a file has an extended attribute, e.g.
+// user.checksum, and if value isn't empty, that it has that value
+func fileHasXattr(path string, key string, value string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		buf, err := getXattr(path, key)
+		if err == errNoXattr {
+			return 1, "File does not have extended attribute: " + path + ": " + key
+		} else if err != nil {
+			return 1, "Couldn't read extended attribute: " + path + "\n\t" + err.Error()
+		}
+		actual := strings.TrimRight(string(buf), "\x00")
+		if value == "" || actual == value {
+			return 0, ""
+		}
+		msg := "Extended attribute does not have value: " + path + ": " + key
+		return genericError(msg, value, []string{actual})
+	}
+}
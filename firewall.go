@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// firewallRule is a simplified view of a single firewall rule. Fields that the
+// rule doesn't specify are left empty.
+type firewallRule struct {
+	Chain    string
+	Protocol string
+	Ports    []string
+	Target   string
+}
+
+// firewallRuleMatches reports whether a rule matches the given chain,
+// protocol, port, and target. Any of these may be "*" to match anything.
+func firewallRuleMatches(rule firewallRule, chain, protocol, port, target string) bool {
+	matches := func(specified, actual string) bool {
+		return specified == "*" || strings.EqualFold(specified, actual)
+	}
+	portMatches := port == "*"
+	for _, rulePort := range rule.Ports {
+		portMatches = portMatches || portInRange(port, rulePort)
+	}
+	return matches(chain, rule.Chain) && matches(protocol, rule.Protocol) &&
+		portMatches && matches(target, rule.Target)
+}
+
+// portInRange reports whether a port is this rule port, or falls within it if
+// it's a range like "8000-9000"
+func portInRange(port string, rulePort string) bool {
+	if port == rulePort {
+		return true
+	}
+	bounds := strings.SplitN(rulePort, "-", 2)
+	if len(bounds) != 2 {
+		return false
+	}
+	num, err := strconv.Atoi(port)
+	low, lowErr := strconv.Atoi(bounds[0])
+	high, highErr := strconv.Atoi(bounds[1])
+	return err == nil && lowErr == nil && highErr == nil && low <= num && num <= high
+}
+
+// firewallCommandOutput runs a firewall tool and handles errors, which are
+// usually caused by a missing binary or insufficient privileges
+func firewallCommandOutput(name string, args ...string) string {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		msg := "Error while executing `" + name + "`:"
+		msg += "\n\tArguments: " + strings.Join(args, " ")
+		msg += "\n\tOutput: " + strings.TrimSpace(string(out))
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	return string(out)
+}
+
+// splitPorts expands a comma-separated list of ports, as used by multiport.
+// Ranges are written like nftables's, e.g. iptables's "8000:9000" becomes
+// "8000-9000".
+func splitPorts(str string) []string {
+	return strings.Split(strings.Replace(str, ":", "-", -1), ",")
+}
+
+// getIptablesRules parses the active filter table from `iptables -S`, where
+// rules look like: -A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+func getIptablesRules() (rules []firewallRule) {
+	for _, line := range strings.Split(firewallCommandOutput("iptables", "-S"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		rule := firewallRule{Chain: fields[1]}
+		for i := 2; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "-p", "--protocol":
+				rule.Protocol = fields[i+1]
+			case "--dport", "--destination-port", "--dports", "--destination-ports":
+				rule.Ports = append(rule.Ports, splitPorts(fields[i+1])...)
+			case "-j", "--jump":
+				rule.Target = fields[i+1]
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// nftVerdicts are the statements that end an nftables rule
+var nftVerdicts = []string{"accept", "drop", "reject", "queue", "return", "jump", "goto"}
+
+// getNftablesRules parses `nft list ruleset`, where rules look like:
+// tcp dport { 80, 443 } counter packets 0 bytes 0 accept
+func getNftablesRules() (rules []firewallRule) {
+	chainRe := regexp.MustCompile(`^chain\s+(\S+)\s*\{`)
+	chain := ""
+	for _, line := range strings.Split(firewallCommandOutput("nft", "list", "ruleset"), "\n") {
+		line = strings.TrimSpace(line)
+		if match := chainRe.FindStringSubmatch(line); match != nil {
+			chain = match[1]
+			continue
+		}
+		fields := strings.Fields(strings.NewReplacer("{", " { ", "}", " } ", ",", " ").Replace(line))
+		if chain == "" || len(fields) == 0 || fields[0] == "type" {
+			continue
+		}
+		rule := firewallRule{Chain: chain}
+		for i := 0; i < len(fields); i++ {
+			switch {
+			case fields[i] == "dport" && i > 0 && i+1 < len(fields):
+				rule.Protocol = fields[i-1]
+				if fields[i+1] != "{" {
+					rule.Ports = append(rule.Ports, fields[i+1])
+					continue
+				}
+				for j := i + 2; j < len(fields) && fields[j] != "}"; j++ {
+					rule.Ports = append(rule.Ports, fields[j])
+				}
+			case fields[i] == "meta" && i+2 < len(fields) && fields[i+1] == "l4proto":
+				rule.Protocol = fields[i+2]
+			case strIn(fields[i], nftVerdicts):
+				rule.Target = fields[i]
+				if (fields[i] == "jump" || fields[i] == "goto") && i+1 < len(fields) {
+					rule.Target = fields[i+1]
+				}
+			}
+		}
+		if rule.Target != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// firewallRuleExists is an abstraction of iptablesRuleExists and
+// nftablesRuleExists
+func firewallRuleExists(getRules func() []firewallRule, chain, protocol, port, target string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		rules := getRules()
+		for _, rule := range rules {
+			if firewallRuleMatches(rule, chain, protocol, port, target) {
+				return 0, ""
+			}
+		}
+		var actual []string
+		for _, rule := range rules {
+			actual = append(actual, rule.Chain+" "+rule.Protocol+" "+
+				strings.Join(rule.Ports, ",")+" "+rule.Target)
+		}
+		specified := chain + " " + protocol + " " + port + " " + target
+		return genericError("No matching firewall rule", specified, actual)
+	}
+}
+
+// iptablesRuleExists checks the active iptables filter table for a rule in
+// this chain with this protocol, destination port, and target
+func iptablesRuleExists(chain, protocol, port, target string) Thunk {
+	return firewallRuleExists(getIptablesRules, chain, protocol, port, target)
+}
+
+// nftablesRuleExists checks the active nftables ruleset for a rule in this
+// chain with this protocol, destination port, and verdict
+func nftablesRuleExists(chain, protocol, port, target string) Thunk {
+	return firewallRuleExists(getNftablesRules, chain, protocol, port, target)
+}
+
+// firewalldServiceEnabled checks that firewalld allows this service in the
+// given zone, or in the default zone if none is given
+func firewalldServiceEnabled(service string, zone string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		args := []string{"--list-services"}
+		if zone != "" {
+			args = append(args, "--zone="+zone)
+		}
+		services := strings.Fields(firewallCommandOutput("firewall-cmd", args...))
+		if strIn(service, services) {
+			return 0, ""
+		}
+		return genericError("Service not enabled in firewalld", service, services)
+	}
+}
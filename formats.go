@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pythonValidators load a file with a Python parser, for formats the standard
+// library can't parse. They print the parser's message and exit 1 on errors.
+// TOML uses tomllib on Python 3.11 and later, or the toml package before that.
+var pythonValidators = map[string]string{
+	"YAML": pythonValidator("import yaml", "for _ in yaml.safe_load_all(f): pass", "r"),
+	"TOML": pythonValidator("try:\n    import tomllib\n    load = tomllib.load\n"+
+		"except ImportError:\n    import toml\n"+
+		"    load = lambda f: toml.loads(f.read().decode('utf-8'))",
+		"load(f)", "rb"),
+}
+
+// pythonDependencies name the Python parser each format needs, for when it
+// isn't installed
+var pythonDependencies = map[string]string{
+	"YAML": "PyYAML",
+	"TOML": "Python 3.11 or the toml package",
+}
+
+// pythonValidator builds a script that parses the file named by its argument
+func pythonValidator(imports string, load string, mode string) string {
+	script := "import sys\n" + imports + "\n"
+	script += "try:\n"
+	script += "    with open(sys.argv[1], '" + mode + "') as f:\n"
+	script += "        " + load + "\n"
+	script += "except Exception as e:\n"
+	script += "    print(e)\n"
+	script += "    sys.exit(1)\n"
+	return script
+}
+
+// validateFormat parses a file in the given format, returning a description of
+// the syntax error if there is one. If the file couldn't be parsed at all,
+// e.g. because a parser isn't installed, it returns that error instead.
+func validateFormat(format string, path string) (syntaxErr string, err error) {
+	data, errMsg := readFileOrFail(path)
+	if errMsg != "" {
+		return "", fmt.Errorf("%s", errMsg)
+	}
+	switch format {
+	case "JSON":
+		var value interface{}
+		if err := json.Unmarshal([]byte(data), &value); err != nil {
+			return err.Error(), nil
+		}
+	case "INI":
+		if _, _, err := parseINI(data); err != nil {
+			return err.Error(), nil
+		}
+	default:
+		out, err := exec.Command("python3", "-c", pythonValidators[format], hostPath(path)).CombinedOutput()
+		if err != nil && strings.Contains(err.Error(), "executable file not found") {
+			return "", fmt.Errorf("validating %s requires python3", format)
+		} else if strings.Contains(string(out), "ModuleNotFoundError") {
+			return "", fmt.Errorf("validating %s requires %s", format, pythonDependencies[format])
+		} else if err != nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	return "", nil
+}
+
+// validFormat checks that a file has valid syntax for the given format, which
+// is JSON, YAML, INI, or TOML
+func validFormat(format string, path string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		errMsg, err := validateFormat(format, path)
+		if err != nil {
+			return 1, "Couldn't validate " + format + " file: " + path + "\n\t" + err.Error()
+		} else if errMsg != "" {
+			errMsg = strings.Replace(errMsg, "\n", "\n\t", -1)
+			return 1, "File is not valid " + format + ": " + path + "\n\t" + errMsg
+		}
+		return 0, ""
+	}
+}
+
+// jsonPathRe matches one step of a key path, e.g. .log-driver or [0]
+var jsonPathRe = regexp.MustCompile(`^(?:\.?([^.\[\]]+)|\[(\d+)\])`)
+
+// lookupJSONPath follows a key path like .log-opts.max-size or .hosts[0]
+// through decoded JSON, and returns the value it points to
+func lookupJSONPath(value interface{}, path string) (interface{}, bool) {
+	rest := strings.TrimPrefix(path, ".")
+	if rest != "" {
+		rest = "." + rest
+	}
+	for rest != "" {
+		match := jsonPathRe.FindStringSubmatch(rest)
+		if match == nil {
+			log.Fatal("Invalid JSON key path: " + path)
+		}
+		rest = rest[len(match[0]):]
+		switch current := value.(type) {
+		case map[string]interface{}:
+			if match[1] == "" {
+				return nil, false
+			}
+			child, ok := current[match[1]]
+			if !ok {
+				return nil, false
+			}
+			value = child
+		case []interface{}:
+			index, err := strconv.Atoi(match[2])
+			if match[2] == "" || err != nil || index >= len(current) {
+				return nil, false
+			}
+			value = current[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// jsonKeyEquals checks that the value at a key path in a JSON file equals
+// this value. Strings are compared as-is, and anything else as JSON, e.g.
+// "true", "3", or "[\"a\",\"b\"]".
+func jsonKeyEquals(path string, keyPath string, expected string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		data, errMsg := readFileOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+			return 1, "File is not valid JSON: " + path + "\n\t" + err.Error()
+		}
+		value, ok := lookupJSONPath(decoded, keyPath)
+		if !ok {
+			return 1, "JSON key not found: " + path + ": " + keyPath
+		}
+		actual, isString := value.(string)
+		if !isString {
+			encoded, _ := json.Marshal(value)
+			actual = string(encoded)
+		}
+		if actual == expected {
+			return 0, ""
+		}
+		msg := "JSON key does not have value: " + path + ": " + keyPath
+		return genericError(msg, expected, []string{fmt.Sprint(actual)})
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// imagePath is the container image to check instead of the host, as
+// specified by the -i flag: a tarball, an OCI layout, or an image name
+var imagePath string
+
+// imageRoot is the directory a container image was unpacked into, when
+// checks are run against an image (-i) instead of the host
+var imageRoot string
+
+// imageTmp is the temporary directory loadImage unpacks into, so that it can
+// be removed however distributive exits
+var imageTmp string
+
+// imageChecks are the checks that can run against a container image, because
+// they only read files or package databases. Any other check in a checklist
+// run with -i is an error, rather than silently inspecting the host.
+var imageChecks = []string{
+	"file", "directory", "direxists", "symlink", "checksum",
+	"filecontains", "filenotcontains", "filematchesregex", "configdirective",
+	"dirempty", "dirfilecountbetween", "dircontainsmatching",
+	"validjson", "validyaml", "validini", "validtoml", "jsonkeyequals",
+	"groupexists", "useringroup", "groupid", "userexists", "userhasuid",
+	"userhasgid", "userhasusername", "userhasname", "userhashomedir",
+	"passwordexpireswithin", "passwordmaxagebelow", "accountlocked",
+	"accountnotexpired", "nounexpecteduid0users",
+	"installed", "ppa", "yumrepo", "yumrepourl", "repoenabled",
+	"repogpgcheckenabled", "aptpinned", "aptkey", "aptkeysnotexpiring",
+	"dnfautomaticenabled", "pacmanignore",
+	"sshdconfig", "authorizedkeypresent", "authorizedkeyabsent",
+}
+
+// maxSymlinkHops is how many symlinks resolveInImage follows before giving
+// up, as the kernel does with ELOOP
+const maxSymlinkHops = 40
+
+// imageCleanupLog is the log's output once an image is being unpacked.
+// Distributive only logs with log.Fatal, so each write is about to exit, and
+// removes the unpacked image first rather than leaving it in $TMPDIR.
+type imageCleanupLog struct{}
+
+func (imageCleanupLog) Write(p []byte) (int, error) {
+	if imageTmp != "" {
+		os.RemoveAll(imageTmp)
+	}
+	return os.Stderr.Write(p)
+}
+
+// hostPath returns where an absolute path from a checklist lives: unchanged
+// on the host, or inside the unpacked image, with symlinks resolved within it
+func hostPath(path string) string {
+	if imageRoot == "" {
+		return path
+	}
+	return resolveInImage(path, true)
+}
+
+// hostLinkPath is hostPath, but leaves a symlink in the last component of the
+// path unresolved, for checks that look at the link itself
+func hostLinkPath(path string) string {
+	if imageRoot == "" {
+		return path
+	}
+	return resolveInImage(path, false)
+}
+
+// hostGlob is filepath.Glob for a pattern from a checklist, like
+// "/etc/yum.repos.d/*.repo", matching inside the image when one is loaded.
+// Only the last component may have wildcards. Matches are returned as paths
+// in the image, for hostPath to resolve.
+func hostGlob(pattern string) ([]string, error) {
+	if imageRoot == "" {
+		return filepath.Glob(pattern)
+	}
+	dir, name := filepath.Split(pattern)
+	matches, err := filepath.Glob(filepath.Join(hostPath(dir), name))
+	var paths []string
+	for _, match := range matches {
+		paths = append(paths, filepath.Join(dir, filepath.Base(match)))
+	}
+	return paths, err
+}
+
+// resolveInImage returns where path lives in the unpacked image, following
+// symlinks as a container would see them: absolute targets are re-rooted
+// under imageRoot, and ".." can't climb above it, so links in the image never
+// lead to the host's files. If follow is false, a symlink in the last
+// component is left as it is.
+func resolveInImage(path string, follow bool) string {
+	splitPath := func(path string) []string {
+		return strings.Split(filepath.ToSlash(path), "/")
+	}
+	parts := splitPath(filepath.Clean("/" + path))
+	var resolved []string // components under imageRoot
+	hops := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+		candidate := append(append([]string{}, resolved...), part)
+		full := filepath.Join(append([]string{imageRoot}, candidate...)...)
+		info, err := os.Lstat(full)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 || (!follow && len(parts) == 0) {
+			resolved = candidate
+			continue
+		}
+		hops++
+		target, err := os.Readlink(full)
+		if err != nil || hops > maxSymlinkHops {
+			// a path that can't exist, rather than one that leaves the image
+			return filepath.Join(imageRoot, ".distributive-unresolvable")
+		}
+		if filepath.IsAbs(target) {
+			resolved = nil
+		}
+		parts = append(splitPath(target), parts...)
+	}
+	return filepath.Join(append([]string{imageRoot}, resolved...)...)
+}
+
+// secureJoin returns the path of name under dir, or an error if name would
+// escape it, either with ".." or through a symlink that an earlier archive
+// entry put in one of its parent directories
+func secureJoin(dir string, name string) (string, error) {
+	clean := filepath.Clean("/" + name)
+	parent := dir
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(clean)), "/") {
+		if part == "" {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			// the rest doesn't exist yet, and will be created as directories
+			break
+		} else if err != nil {
+			return "", err
+		} else if !info.IsDir() {
+			return "", fmt.Errorf("archive entry goes through a symlink or file: %s", name)
+		}
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// withinDir returns the path of name under dir for reading, failing if it
+// escapes dir (e.g. "../../etc/passwd" in a malicious archive) or is a symlink
+func withinDir(dir string, name string) string {
+	path, err := secureJoin(dir, name)
+	if err == nil {
+		if info, statErr := os.Lstat(path); statErr == nil && info.Mode()&os.ModeSymlink != 0 {
+			err = fmt.Errorf("archive entry is a symlink: %s", name)
+		}
+	}
+	if err != nil {
+		log.Fatal("Archive entry escapes its destination: " + name + "\n\t" + err.Error())
+	}
+	return path
+}
+
+// openMaybeGzip opens a file, transparently decompressing it if it's gzipped,
+// as image layers may or may not be
+func openMaybeGzip(path string) (io.Reader, func()) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal("Couldn't open image layer: " + path + "\n\t" + err.Error())
+	}
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			log.Fatal("Couldn't decompress image layer: " + path + "\n\t" + err.Error())
+		}
+		return gz, func() { gz.Close(); file.Close() }
+	}
+	return buffered, func() { file.Close() }
+}
+
+// extractTar unpacks a tar stream into dir. If layer is true, OCI whiteout
+// files are applied, deleting what earlier layers added. Device nodes and
+// other special files are skipped, since checks don't need them. Entries are
+// never written, removed, or linked through a symlink, and symlinks are
+// stored as they are, so a malicious archive can't reach outside dir.
+func extractTar(r io.Reader, dir string, layer bool) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Fatal("Couldn't read image archive:\n\t" + err.Error())
+		}
+		path, err := secureJoin(dir, header.Name)
+		if err != nil {
+			log.Fatal("Archive entry escapes its destination: " + header.Name + "\n\t" + err.Error())
+		}
+		base := filepath.Base(path)
+		if layer && base == ".wh..wh..opq" {
+			// opaque whiteout: hide everything earlier layers put here
+			entries, _ := ioutil.ReadDir(filepath.Dir(path))
+			for _, entry := range entries {
+				os.RemoveAll(filepath.Join(filepath.Dir(path), entry.Name()))
+			}
+			continue
+		} else if layer && strings.HasPrefix(base, ".wh.") {
+			os.RemoveAll(filepath.Join(filepath.Dir(path), strings.TrimPrefix(base, ".wh.")))
+			continue
+		}
+		os.MkdirAll(filepath.Dir(path), 0755)
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// replace anything that isn't a directory, symlinks included
+			if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+				os.RemoveAll(path)
+			}
+			os.MkdirAll(path, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			// RemoveAll deletes a symlink itself, not what it points to
+			os.RemoveAll(path)
+			flags := os.O_CREATE | os.O_EXCL | os.O_WRONLY | syscall.O_NOFOLLOW
+			file, err := os.OpenFile(path, flags, mode|0600)
+			if err == nil {
+				_, err = io.Copy(file, tr)
+				file.Close()
+			}
+			if err != nil {
+				log.Fatal("Couldn't extract file from image: " + header.Name + "\n\t" + err.Error())
+			}
+		case tar.TypeSymlink:
+			os.RemoveAll(path)
+			os.Symlink(header.Linkname, path)
+		case tar.TypeLink:
+			target, err := secureJoin(dir, header.Linkname)
+			if err != nil {
+				log.Fatal("Archive entry escapes its destination: " + header.Linkname + "\n\t" + err.Error())
+			}
+			os.RemoveAll(path)
+			// links the target itself, even if it's a symlink
+			os.Link(target, path)
+		}
+	}
+}
+
+// getImageLayers returns the layer files of an image that was saved with
+// `docker save` (manifest.json) or laid out as an OCI image (index.json),
+// bottom layer first
+func getImageLayers(dir string) (layers []string) {
+	if data, err := ioutil.ReadFile(withinDir(dir, "manifest.json")); err == nil {
+		var manifests []struct{ Layers []string }
+		if err := json.Unmarshal(data, &manifests); err != nil || len(manifests) == 0 {
+			log.Fatal("Couldn't parse image manifest.json")
+		}
+		for _, layer := range manifests[0].Layers {
+			layers = append(layers, withinDir(dir, layer))
+		}
+		return layers
+	}
+	// blobPath returns the path of an OCI blob, given its digest
+	blobPath := func(digest string) string {
+		return withinDir(dir, filepath.Join("blobs", strings.Replace(digest, ":", "/", 1)))
+	}
+	var index struct {
+		Manifests []struct{ Digest string }
+	}
+	data, err := ioutil.ReadFile(withinDir(dir, "index.json"))
+	if err != nil || json.Unmarshal(data, &index) != nil || len(index.Manifests) == 0 {
+		log.Fatal("Not a Docker image archive or OCI image layout: " + dir)
+	}
+	var manifest struct {
+		Manifests []struct{ Digest string }
+		Layers    []struct{ Digest string }
+	}
+	digest := index.Manifests[0].Digest
+	// follow nested indexes (multi-platform images) to the first manifest
+	for {
+		data, err = ioutil.ReadFile(blobPath(digest))
+		if err != nil || json.Unmarshal(data, &manifest) != nil {
+			log.Fatal("Couldn't read OCI manifest: " + digest)
+		}
+		if len(manifest.Manifests) == 0 {
+			break
+		}
+		digest = manifest.Manifests[0].Digest
+		manifest.Manifests = nil
+	}
+	for _, layer := range manifest.Layers {
+		layers = append(layers, blobPath(layer.Digest))
+	}
+	return layers
+}
+
+// loadImage unpacks a container image's filesystem without running it, and
+// sets imageRoot so that file, package, and user checks look inside it. The
+// image can be a tarball from `docker save`, an OCI image layout directory,
+// or the name of an image, which is pulled and saved with the container
+// runtime (Docker or Podman). The returned function removes the unpacked
+// files.
+func loadImage(image string) (cleanup func()) {
+	tmp, err := ioutil.TempDir("", "distributive-image")
+	if err != nil {
+		log.Fatal("Couldn't create temporary directory:\n\t" + err.Error())
+	}
+	imageTmp = tmp
+	log.SetOutput(imageCleanupLog{})
+	// CI runners stop cancelled jobs with SIGTERM
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupted
+		log.Fatal("Interrupted while checking image " + image + ": " + sig.String())
+	}()
+	cleanup = func() { os.RemoveAll(tmp) }
+	archive := filepath.Join(tmp, "archive")
+	os.Mkdir(archive, 0700)
+	info, err := os.Stat(image)
+	switch {
+	case err == nil && info.IsDir():
+		archive = image
+	case err == nil:
+		reader, closeFile := openMaybeGzip(image)
+		extractTar(reader, archive, false)
+		closeFile()
+	default:
+		saved := filepath.Join(tmp, "image.tar")
+		runtime, _ := getContainerRuntime()
+		if exec.Command(runtime, "image", "inspect", image).Run() != nil {
+			if out, err := exec.Command(runtime, "pull", image).CombinedOutput(); err != nil {
+				log.Fatal("Couldn't pull image: " + image + "\n\t" + strings.TrimSpace(string(out)))
+			}
+		}
+		if out, err := exec.Command(runtime, "save", "-o", saved, image).CombinedOutput(); err != nil {
+			log.Fatal("Couldn't save image: " + image + "\n\t" + strings.TrimSpace(string(out)))
+		}
+		reader, closeFile := openMaybeGzip(saved)
+		extractTar(reader, archive, false)
+		closeFile()
+	}
+	root := filepath.Join(tmp, "rootfs")
+	os.Mkdir(root, 0755)
+	for _, layer := range getImageLayers(archive) {
+		reader, closeFile := openMaybeGzip(layer)
+		extractTar(reader, root, true)
+		closeFile()
+	}
+	imageRoot = root
+	return cleanup
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// getJournalLines returns the messages logged to the systemd journal by a unit
+// within the given window before now, as reported by journalctl
+func getJournalLines(unit string, window time.Duration) []string {
+	since := time.Now().Add(-window).Format("2006-01-02 15:04:05")
+	cmd := exec.Command("journalctl", "--no-pager", "--quiet", "--output=cat",
+		"--unit="+unit, "--since="+since)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		msg := "Couldn't execute journalctl:"
+		msg += "\n\tUnit: " + unit
+		msg += "\n\tError: " + err.Error()
+		msg += "\n\tOutput: " + string(out)
+		log.Fatal(msg)
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+// journalMatches returns the journal lines from the given unit and window that
+// match the given regular expression
+func journalMatches(unit string, pattern string, window time.Duration) (matches []string) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatal("Invalid regular expression: " + pattern + "\n\t" + err.Error())
+	}
+	for _, line := range getJournalLines(unit, window) {
+		if re.MatchString(line) {
+			matches = append(matches, line)
+		}
+	}
+	return matches
+}
+
+// journalContains checks that the unit logged a message matching the regular
+// expression within the given window, e.g. an expected startup line
+func journalContains(unit string, pattern string, window time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if len(journalMatches(unit, pattern, window)) > 0 {
+			return 0, ""
+		}
+		msg := "No matching journal entries for unit " + unit
+		msg += " in the last " + window.String()
+		return genericError(msg, pattern, []string{})
+	}
+}
+
+// journalNoMatch checks that the unit did not log any messages matching the
+// regular expression within the given window, e.g. error patterns
+func journalNoMatch(unit string, pattern string, window time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		matches := journalMatches(unit, pattern, window)
+		if len(matches) == 0 {
+			return 0, ""
+		}
+		msg := "Found matching journal entries for unit " + unit
+		msg += " in the last " + window.String()
+		return genericError(msg, pattern, matches)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// kubectlJSON runs kubectl with the given arguments, asking for JSON output,
+// and decodes it into v. It uses kubectl's usual configuration ($KUBECONFIG,
+// ~/.kube/config, or the in-cluster service account).
+func kubectlJSON(v interface{}, args ...string) {
+	args = append(args, "-o", "json")
+	cmd := exec.Command("kubectl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Kubernetes checks require kubectl")
+	} else if err != nil {
+		msg := "Error while executing `kubectl " + strings.Join(args, " ") + "`:"
+		msg += "\n\tOutput: " + strings.TrimSpace(stderr.String())
+		log.Fatal(msg)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		log.Fatal("Couldn't parse kubectl output:\n\t" + err.Error())
+	}
+}
+
+// helmRelease is the part of a Helm 3 release record that checks look at
+type helmRelease struct {
+	Name     string
+	Revision int
+	Status   string
+	Chart    string
+	Version  string
+}
+
+// getHelmRelease returns the latest revision of a Helm release, as Helm 3
+// stores it: a Secret per revision, labelled with the release's name, status,
+// and revision, whose "release" field is gzipped JSON, base64 encoded (once
+// by Helm, and again by Kubernetes). The bool is false if there's no release.
+func getHelmRelease(name string, namespace string) (helmRelease, bool) {
+	var secrets struct {
+		Items []struct {
+			Metadata struct {
+				Labels map[string]string
+			}
+			Data map[string]string
+		}
+	}
+	selector := "owner=helm,name=" + name
+	kubectlJSON(&secrets, "get", "secrets", "-n", namespace, "-l", selector)
+	latest := helmRelease{Name: name, Revision: -1}
+	var data string
+	for _, secret := range secrets.Items {
+		labels := secret.Metadata.Labels
+		revision, err := strconv.Atoi(labels["version"])
+		if err != nil || revision <= latest.Revision {
+			continue
+		}
+		latest.Revision = revision
+		latest.Status = labels["status"]
+		data = secret.Data["release"]
+	}
+	if latest.Revision < 0 {
+		return latest, false
+	}
+	// decode the release record for its chart
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err == nil {
+		decoded, err = base64.StdEncoding.DecodeString(string(decoded))
+	}
+	if err == nil && len(decoded) > 2 && decoded[0] == 0x1f && decoded[1] == 0x8b {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(decoded)); err == nil {
+			decoded, err = ioutil.ReadAll(gz)
+		}
+	}
+	var record struct {
+		Chart struct {
+			Metadata struct {
+				Name    string
+				Version string
+			}
+		}
+	}
+	if err == nil {
+		err = json.Unmarshal(decoded, &record)
+	}
+	if err != nil {
+		log.Fatal("Couldn't decode Helm release: " + name + "\n\t" + err.Error())
+	}
+	latest.Chart = record.Chart.Metadata.Name
+	latest.Version = record.Chart.Metadata.Version
+	return latest, true
+}
+
+// helmReleaseDeployed checks that the latest revision of a Helm release in
+// this namespace was deployed successfully, rather than failed, pending, etc.
+func helmReleaseDeployed(name string, namespace string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		release, ok := getHelmRelease(name, namespace)
+		if !ok {
+			return 1, "Helm release does not exist: " + namespace + "/" + name
+		}
+		if release.Status == "deployed" {
+			return 0, ""
+		}
+		msg := "Helm release is not deployed: " + namespace + "/" + name
+		return genericError(msg, "deployed", []string{release.Status})
+	}
+}
+
+// helmChartVersion checks that the latest revision of a Helm release in this
+// namespace was installed from this version of its chart
+func helmChartVersion(name string, namespace string, version string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		release, ok := getHelmRelease(name, namespace)
+		if !ok {
+			return 1, "Helm release does not exist: " + namespace + "/" + name
+		}
+		if strings.TrimPrefix(release.Version, "v") == strings.TrimPrefix(version, "v") {
+			return 0, ""
+		}
+		msg := "Helm release has the wrong chart version: " + namespace + "/" + name
+		return genericError(msg, version, []string{release.Chart + "-" + release.Version})
+	}
+}
+
+// kubeletHealthz is the kubelet's local health endpoint
+var kubeletHealthz = "http://127.0.0.1:10248/healthz"
+
+// kubeletHealthy checks that the kubelet on this node reports itself healthy
+func kubeletHealthy() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		body, err := httpGetBody(kubeletHealthz)
+		if err != nil {
+			return 1, "Kubelet is not healthy:\n\t" + err.Error()
+		}
+		if strings.TrimSpace(body) == "ok" {
+			return 0, ""
+		}
+		return genericError("Kubelet is not healthy", "ok", []string{body})
+	}
+}
+
+// nodeReady checks that a Kubernetes node (this host, if node is empty) has
+// the Ready condition
+func nodeReady(node string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if node == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatal("Couldn't get hostname:\n\t" + err.Error())
+			}
+			node = hostname
+		}
+		var status struct {
+			Status struct {
+				Conditions []struct {
+					Type    string
+					Status  string
+					Message string
+				}
+			}
+		}
+		kubectlJSON(&status, "get", "node", node)
+		for _, condition := range status.Status.Conditions {
+			if condition.Type != "Ready" {
+				continue
+			}
+			if condition.Status == "True" {
+				return 0, ""
+			}
+			msg := "Kubernetes node is not ready: " + node
+			return genericError(msg, "True", []string{condition.Status + ": " + condition.Message})
+		}
+		return 1, "Kubernetes node has no Ready condition: " + node
+	}
+}
+
+// kubePod is the part of a Kubernetes pod that the pod checks read
+type kubePod struct {
+	Metadata struct {
+		Name string
+	}
+	Status struct {
+		Phase             string
+		ContainerStatuses []struct {
+			Name         string
+			Ready        bool
+			RestartCount int
+		}
+	}
+}
+
+// getPods returns the pods in a namespace with this name, or matching this
+// label selector (e.g. "app=web") if it contains an "="
+func getPods(namespace string, pod string) []kubePod {
+	var list struct{ Items []kubePod }
+	if strings.Contains(pod, "=") {
+		kubectlJSON(&list, "get", "pods", "-n", namespace, "-l", pod)
+	} else {
+		kubectlJSON(&list, "get", "pods", "-n", namespace, "--field-selector", "metadata.name="+pod)
+	}
+	return list.Items
+}
+
+// podRunning checks that every pod with this name or label selector in the
+// namespace is running, with all of its containers ready
+func podRunning(namespace string, pod string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		pods := getPods(namespace, pod)
+		if len(pods) == 0 {
+			return 1, "No pods found: " + namespace + "/" + pod
+		}
+		var notRunning []string
+		for _, p := range pods {
+			ready := p.Status.Phase == "Running"
+			for _, container := range p.Status.ContainerStatuses {
+				ready = ready && container.Ready
+			}
+			if !ready {
+				notRunning = append(notRunning, p.Metadata.Name+" ("+p.Status.Phase+")")
+			}
+		}
+		if len(notRunning) == 0 {
+			return 0, ""
+		}
+		msg := "Pods are not running and ready: " + namespace + "/" + pod
+		return genericError(msg, "Running", notRunning)
+	}
+}
+
+// podRestartsBelow checks that no container of a pod with this name or label
+// selector in the namespace has restarted this many times, which would
+// suggest a crash loop
+func podRestartsBelow(namespace string, pod string, max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		pods := getPods(namespace, pod)
+		if len(pods) == 0 {
+			return 1, "No pods found: " + namespace + "/" + pod
+		}
+		var restarting []string
+		for _, p := range pods {
+			for _, container := range p.Status.ContainerStatuses {
+				if container.RestartCount >= max {
+					restarts := fmt.Sprint(container.RestartCount)
+					restarting = append(restarting, p.Metadata.Name+"/"+container.Name+": "+restarts)
+				}
+			}
+		}
+		if len(restarting) == 0 {
+			return 0, ""
+		}
+		msg := "Pod containers have restarted too many times: " + namespace + "/" + pod
+		return genericError(msg, fmt.Sprint(max), restarting)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// licenseDateLayouts are the date formats that licenseExpiresAfter tries, in
+// order, when parsing an expiry date
+var licenseDateLayouts = []string{
+	time.RFC3339, "2006-01-02", "2006/01/02", "01/02/2006", "02-Jan-2006",
+	"Jan 2, 2006", "January 2, 2006", "2 Jan 2006",
+}
+
+// getJWTExpiry returns the time in the "exp" claim of the JSON Web Token
+// stored in the file at path
+func getJWTExpiry(path string) time.Time {
+	token := strings.TrimSpace(fileToString(path))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		log.Fatal("File does not contain a JSON Web Token: " + path)
+	}
+	// the payload is unpadded base64url, but be lenient about padding
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		log.Fatal("Couldn't decode JSON Web Token payload in " + path + ":\n\t" + err.Error())
+	}
+	var claims struct {
+		Exp *float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		log.Fatal("Couldn't parse JSON Web Token claims in " + path + ":\n\t" + err.Error())
+	}
+	if claims.Exp == nil {
+		log.Fatal("JSON Web Token has no exp claim: " + path)
+	}
+	return time.Unix(int64(*claims.Exp), 0)
+}
+
+// getLicenseExpiry finds the expiry date in the file at path, using the first
+// capture group of the given regular expression
+func getLicenseExpiry(path string, pattern string) time.Time {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatal("Invalid regular expression: " + pattern + "\n\t" + err.Error())
+	}
+	match := re.FindStringSubmatch(fileToString(path))
+	if len(match) < 2 {
+		msg := "Couldn't find expiry date in license file:"
+		msg += "\n\tPath: " + path
+		msg += "\n\tPattern: " + pattern
+		log.Fatal(msg)
+	}
+	for _, layout := range licenseDateLayouts {
+		if date, err := time.Parse(layout, strings.TrimSpace(match[1])); err == nil {
+			return date
+		}
+	}
+	log.Fatal("Couldn't parse expiry date in " + path + ": " + match[1])
+	return time.Time{} // never reaches this return
+}
+
+// expiresAfter is an abstraction of jwtExpiresAfter and licenseExpiresAfter.
+// It checks that the expiry date from getExpiry is more than days away.
+func expiresAfter(path string, days int, getExpiry func() time.Time) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		expiry := getExpiry()
+		if expiry.After(time.Now().AddDate(0, 0, days)) {
+			return 0, ""
+		}
+		msg := "Expires within " + fmt.Sprint(days) + " days: " + path
+		return genericError(msg, fmt.Sprint(days)+" days", []string{expiry.Format(time.RFC3339)})
+	}
+}
+
+// jwtExpiresAfter checks that the JSON Web Token in the file at path doesn't
+// expire within the given number of days
+func jwtExpiresAfter(path string, days int) Thunk {
+	return expiresAfter(path, days, func() time.Time { return getJWTExpiry(path) })
+}
+
+// licenseExpiresAfter checks that the expiry date in a license file, found by
+// the first capture group of a regular expression (e.g.
+// "Expires: (\d{4}-\d{2}-\d{2})"), isn't within the given number of days
+func licenseExpiresAfter(path string, pattern string, days int) Thunk {
+	return expiresAfter(path, days, func() time.Time {
+		return getLicenseExpiry(path, pattern)
+	})
+}
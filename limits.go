@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// getPIDs returns the IDs of every process whose command name
+// (/proc/<pid>/comm) is name
+func getPIDs(name string) (pids []string) {
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, comm := range comms {
+		data, err := ioutil.ReadFile(comm)
+		if err == nil && strings.TrimSpace(string(data)) == name {
+			pids = append(pids, filepath.Base(filepath.Dir(comm)))
+		}
+	}
+	return pids
+}
+
+// fdUsageBelow checks that fewer file descriptors are allocated system-wide
+// than this number, or this percentage of fs.file-max (e.g. "80%").
+// /proc/sys/fs/file-nr holds the allocated, unused, and maximum counts.
+func fdUsageBelow(max string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		path := "/proc/sys/fs/file-nr"
+		fields := strings.Fields(fileToString(path))
+		if len(fields) < 3 {
+			log.Fatal("Couldn't parse " + path)
+		}
+		allocated, err1 := strconv.ParseUint(fields[0], 10, 64)
+		fileMax, err2 := strconv.ParseUint(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			log.Fatal("Couldn't parse " + path + ": " + strings.Join(fields, " "))
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSuffix(max, "%"), 64)
+		if err != nil {
+			log.Fatal("Could not parse file descriptor limit: " + max)
+		}
+		if strings.HasSuffix(max, "%") {
+			limit = float64(fileMax) * limit / 100
+		}
+		if float64(allocated) < limit {
+			return 0, ""
+		}
+		msg := "File descriptor usage exceeds maximum"
+		actual := fmt.Sprint(allocated) + " of " + fmt.Sprint(fileMax)
+		return genericError(msg, max, []string{actual})
+	}
+}
+
+// processFDsBelow checks that every process with this command name has fewer
+// than this many open file descriptors
+func processFDsBelow(name string, max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		pids := getPIDs(name)
+		if len(pids) == 0 {
+			return 1, "No process with name: " + name
+		}
+		for _, pid := range pids {
+			fds, err := ioutil.ReadDir(filepath.Join("/proc", pid, "fd"))
+			if err != nil {
+				return 1, "Couldn't list file descriptors of " + name + " (PID " + pid + ")"
+			}
+			if len(fds) >= max {
+				msg := "Process has too many open files: " + name + " (PID " + pid + ")"
+				return genericError(msg, fmt.Sprint(max), []string{fmt.Sprint(len(fds))})
+			}
+		}
+		return 0, ""
+	}
+}
+
+// processLimits are the rows of /proc/<pid>/limits, by their ulimit names
+var processLimits = map[string]string{
+	"cpu":        "Max cpu time",
+	"fsize":      "Max file size",
+	"data":       "Max data size",
+	"stack":      "Max stack size",
+	"core":       "Max core file size",
+	"rss":        "Max resident set",
+	"nproc":      "Max processes",
+	"nofile":     "Max open files",
+	"memlock":    "Max locked memory",
+	"as":         "Max address space",
+	"locks":      "Max file locks",
+	"sigpending": "Max pending signals",
+	"msgqueue":   "Max msgqueue size",
+	"nice":       "Max nice priority",
+	"rtprio":     "Max realtime priority",
+}
+
+// getProcessLimit returns a process's soft (effective) limit of the given
+// kind, as written in /proc/<pid>/limits: a number, or "unlimited"
+func getProcessLimit(pid string, kind string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", pid, "limits"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, processLimits[kind]+" ") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, processLimits[kind]))
+		if len(fields) > 0 {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no such limit: %s", kind)
+}
+
+// processLimitAtLeast checks that every process with this command name has a
+// soft limit of this kind (a ulimit name, like "nofile") of at least value,
+// which may be "unlimited"
+func processLimitAtLeast(name string, kind string, value string) Thunk {
+	if _, ok := processLimits[kind]; !ok {
+		var kinds []string
+		for k := range processLimits {
+			kinds = append(kinds, k)
+		}
+		log.Fatal("Unknown limit: " + kind + ". Valid limits: " + fmt.Sprint(kinds))
+	}
+	min, err := strconv.ParseUint(value, 10, 64)
+	if err != nil && value != "unlimited" {
+		log.Fatal("Could not parse limit: " + value)
+	}
+	return func() (exitCode int, exitMessage string) {
+		pids := getPIDs(name)
+		if len(pids) == 0 {
+			return 1, "No process with name: " + name
+		}
+		for _, pid := range pids {
+			limit, err := getProcessLimit(pid, kind)
+			if err != nil {
+				return 1, "Couldn't read limits of " + name + " (PID " + pid + "):\n\t" + err.Error()
+			}
+			if limit == "unlimited" {
+				continue
+			}
+			actual, err := strconv.ParseUint(limit, 10, 64)
+			if err != nil {
+				log.Fatal("Couldn't parse limit of " + name + ": " + limit)
+			}
+			if value == "unlimited" || actual < min {
+				msg := "Process limit is too low: " + name + " (PID " + pid + ")"
+				return genericError(msg, kind+" "+value, []string{kind + " " + limit})
+			}
+		}
+		return 0, ""
+	}
+}
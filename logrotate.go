@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logrotateBlock is a block from a logrotate config, with the log paths (or
+// globs) it applies to and the directives inside it
+type logrotateBlock struct {
+	Paths      []string
+	Directives []string
+}
+
+// parseLogrotateConfig reads the blocks in a logrotate config file, along
+// with the global directives outside of any block
+func parseLogrotateConfig(path string) (blocks []logrotateBlock, global []string) {
+	var current *logrotateBlock
+	for _, line := range strings.Split(fileToString(path), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(line, "{"):
+			paths := strings.Fields(strings.TrimSuffix(line, "{"))
+			current = &logrotateBlock{Paths: paths}
+		case line == "}" && current != nil:
+			blocks = append(blocks, *current)
+			current = nil
+		case current != nil:
+			current.Directives = append(current.Directives, strings.Join(strings.Fields(line), " "))
+		default:
+			global = append(global, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	return blocks, global
+}
+
+// logrotateFrequencies are the directives that set how often logs rotate, of
+// which a block sets at most one
+var logrotateFrequencies = []string{"hourly", "daily", "weekly", "monthly", "yearly"}
+
+// logrotateSetting returns the setting a directive changes, so that a directive
+// and its opposite share one, e.g. "compress" for both "compress" and
+// "nocompress", "rotate" for "rotate 7", and "frequency" for "weekly"
+func logrotateSetting(directive string) string {
+	keyword := strings.Fields(directive)[0]
+	switch {
+	case strIn(keyword, logrotateFrequencies):
+		return "frequency"
+	case keyword == "notifempty":
+		return "ifempty"
+	}
+	return strings.TrimPrefix(keyword, "no")
+}
+
+// getLogrotateDirectives returns the directives that apply to the log at path,
+// and whether any logrotate config mentions it at all
+func getLogrotateDirectives(logPath string) (directives []string, found bool) {
+	configs := []string{"/etc/logrotate.conf"}
+	if matches, err := filepath.Glob("/etc/logrotate.d/*"); err == nil {
+		configs = append(configs, matches...)
+	}
+	var global []string
+	for _, config := range configs {
+		if info, err := os.Stat(config); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		blocks, configGlobal := parseLogrotateConfig(config)
+		global = append(global, configGlobal...)
+		for _, block := range blocks {
+			for _, pattern := range block.Paths {
+				pattern = strings.Trim(pattern, "\"")
+				if matched, _ := filepath.Match(pattern, logPath); matched {
+					directives = append(directives, block.Directives...)
+					found = true
+				}
+			}
+		}
+	}
+	// global directives are defaults that blocks can override
+	overridden := make(map[string]bool)
+	for _, directive := range directives {
+		overridden[logrotateSetting(directive)] = true
+	}
+	for _, directive := range global {
+		if !overridden[logrotateSetting(directive)] {
+			directives = append(directives, directive)
+		}
+	}
+	return directives, found
+}
+
+// logrotateConfigured checks that a logrotate config applies to the log at
+// path and, if directive isn't empty, that it includes that directive (e.g.
+// "daily", "rotate 7", or "compress")
+func logrotateConfigured(logPath string, directive string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		directives, found := getLogrotateDirectives(logPath)
+		if !found {
+			return 1, "No logrotate config for log: " + logPath
+		}
+		wanted := strings.Join(strings.Fields(directive), " ")
+		if directive == "" || strIn(wanted, directives) {
+			return 0, ""
+		}
+		msg := "Logrotate config lacks directive for log " + logPath
+		return genericError(msg, wanted, directives)
+	}
+}
+
+// logRotated checks that the log at path is actually being rotated: it must
+// be smaller than maxSize, and a rotated copy (e.g. path.1, path.2.gz, or
+// path-20150704) must have been written within maxAge
+func logRotated(logPath string, maxSize uint64, maxAge time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		info, err := os.Stat(logPath)
+		if err != nil {
+			return 1, "Couldn't read log: " + logPath + "\n\tError: " + err.Error()
+		}
+		if uint64(info.Size()) > maxSize {
+			msg := "Log is larger than expected, it may not be rotating: " + logPath
+			return genericError(msg, formatSize(maxSize), []string{formatSize(uint64(info.Size()))})
+		}
+		var newest time.Time
+		for _, pattern := range []string{logPath + ".*", logPath + "-*"} {
+			matches, _ := filepath.Glob(pattern)
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.ModTime().After(newest) {
+					newest = info.ModTime()
+				}
+			}
+		}
+		if newest.IsZero() {
+			return 1, "No rotated copies of log found: " + logPath
+		}
+		age := time.Since(newest)
+		if age <= maxAge {
+			return 0, ""
+		}
+		msg := "Log has not been rotated recently: " + logPath
+		return genericError(msg, maxAge.String(), []string{age.String()})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// smtpAddress adds the default SMTP port to an address without one
+func smtpAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, "25")
+	}
+	return address
+}
+
+// getSMTPBanner returns the greeting of the SMTP server at this address,
+// joining the lines of a multi-line greeting with spaces, and fails unless
+// it's a 220
+func getSMTPBanner(address string) (string, error) {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil {
+			return "", fmt.Errorf("no greeting received: %s", err.Error())
+		}
+		lines = append(lines, line)
+		// "220-" continues a greeting, and "220 " ends it
+		if len(line) < 4 || line[3] != '-' {
+			break
+		}
+	}
+	greeting := strings.Join(lines, " ")
+	if !strings.HasPrefix(greeting, "220") {
+		return greeting, fmt.Errorf("server isn't ready: %s", greeting)
+	}
+	return greeting, nil
+}
+
+// smtpBanner checks that the SMTP server at this address (port 25 by default)
+// is ready to accept mail, and, if a pattern is given, that its greeting
+// matches it
+func smtpBanner(address string, pattern string) Thunk {
+	address = smtpAddress(address)
+	var re *regexp.Regexp
+	if pattern != "" {
+		re = compileRegex(pattern)
+	}
+	return func() (exitCode int, exitMessage string) {
+		greeting, err := getSMTPBanner(address)
+		if err != nil {
+			return 1, "SMTP server is not ready: " + address + "\n\t" + err.Error()
+		} else if re == nil || re.MatchString(greeting) {
+			return 0, ""
+		}
+		msg := "SMTP greeting didn't match regexp: " + address
+		return genericError(msg, re.String(), []string{greeting})
+	}
+}
+
+// smtpCanSend checks that the SMTP server at this address accepts EHLO and,
+// if startTLS is true, that it offers STARTTLS and completes a TLS handshake
+// with a certificate valid for its hostname
+func smtpCanSend(address string, startTLS bool) Thunk {
+	address = smtpAddress(address)
+	host, _, _ := net.SplitHostPort(address)
+	return func() (exitCode int, exitMessage string) {
+		fail := func(err error) (int, string) {
+			return 1, "SMTP server can't accept mail: " + address + "\n\t" + err.Error()
+		}
+		conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+		if err != nil {
+			return fail(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+		client, err := smtp.NewClient(conn, host)
+		if err != nil {
+			return fail(err)
+		}
+		defer client.Quit()
+		localName, err := os.Hostname()
+		if err != nil {
+			localName = "localhost"
+		}
+		if err := client.Hello(localName); err != nil {
+			return fail(err)
+		}
+		if !startTLS {
+			return 0, ""
+		}
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fail(fmt.Errorf("STARTTLS is not offered"))
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fail(err)
+		}
+		return 0, ""
+	}
+}
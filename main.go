@@ -13,6 +13,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var maxVerbosity int = 2
@@ -22,10 +23,14 @@ var verbosity int // global program verbosity
 // Check is a struct for a unified interface for health checks
 // It passes its check-specific fields to that check's Thunk constructor
 type Check struct {
-	Name, Notes string
-	Check       string // type of check to run
-	Parameters  []string
-	Fun         Thunk
+	Name, Notes   string
+	Check         string // type of check to run
+	Parameters    []string
+	RawParameters []string `json:"-"`           // Parameters before facts were expanded
+	Runbook       string   `json:"runbook-url"` // remediation docs, shown on failure
+	Weight        *float64 // how much it counts toward the health score
+	When          string   // condition on facts for running it, if any
+	Fun           Thunk
 }
 
 // Checklist is a struct that provides a concise way of thinking about doing
@@ -33,8 +38,12 @@ type Check struct {
 type Checklist struct {
 	Name, Notes string
 	Checklist   []Check // list of Checks to run
+	Maintenance []MaintenanceWindow
 	Codes       []int
 	Messages    []string
+	Skipped     int     // checks whose When condition didn't hold
+	Score       float64 // weighted percentage of checks passing
+	Grade       string  // letter grade for Score
 	Report      string
 }
 
@@ -62,6 +71,10 @@ func makeReport(chklst Checklist) (report string) {
 	failed := countInt(1, chklst.Codes)
 	report += "Passed: " + fmt.Sprint(passed) + "\n"
 	report += "Failed: " + fmt.Sprint(failed) + "\n"
+	if chklst.Skipped > 0 {
+		report += "Skipped: " + fmt.Sprint(chklst.Skipped) + "\n"
+	}
+	report += fmt.Sprintf("Score: %.1f (%s)\n", chklst.Score, chklst.Grade)
 	for _, msg := range failMessages {
 		report += msg
 	}
@@ -73,18 +86,21 @@ func makeReport(chklst Checklist) (report string) {
 func validateParameters(chk Check) {
 	// checkParameterLength ensures that the Check has the proper number of
 	// parameters, and exits otherwise. Can't do much with a broken check!
-	checkParameterLength := func(chk Check, expected int) {
+	checkParameterLength := func(chk Check, expected int, optional int) {
 		given := len(chk.Parameters)
-		if given == 0 {
+		if given == 0 && expected > 0 {
 			msg := "Invalid check:"
 			msg += "\n\tCheck type: " + chk.Check
 			log.Fatal(msg)
 		}
-		if given != expected {
+		if given < expected || given > expected+optional {
 			msg := "Invalid check parameters: "
 			msg += "\n\tName: " + chk.Name
 			msg += "\n\tCheck type: " + chk.Check
 			msg += "\n\tExpected: " + fmt.Sprint(expected)
+			if optional > 0 {
+				msg += " (up to " + fmt.Sprint(expected+optional) + ")"
+			}
 			msg += "\n\tGiven: " + fmt.Sprint(given)
 			msg += "\n\tParameters: " + fmt.Sprint(chk.Parameters)
 			log.Fatal(msg)
@@ -96,7 +112,8 @@ func validateParameters(chk Check) {
 		"installed": 1, "ppa": 1, "checksum": 3, "temp": 1, "port": 1,
 		"interface": 1, "up": 1, "ip4": 2, "ip6": 2, "gateway": 1,
 		"gatewayinterface": 1, "host": 1, "tcp": 1, "udp": 1, "module": 1,
-		"kernelparameter": 1, "dockerimage": 1, "dockerrunning": 1,
+		"kernelparameter": 1, "alternative": 2, "runtimeversion": 2,
+		"cronentry": 2, "crondirhasscript": 2, "dockerimage": 1, "dockerrunning": 1,
 		"groupexists": 1, "useringroup": 2, "groupid": 2, "userexists": 1,
 		"userhasuid": 2, "userhasgid": 2, "userhasusername": 2, "userhasname": 2,
 		"userhashomedir": 2, "yumrepo": 1, "yumrepourl": 1,
@@ -104,9 +121,164 @@ func validateParameters(chk Check) {
 		"routingtabledestination": 1, "systemctlloaded": 1, "systemctlactive": 1,
 		"systemctlsockpath": 1, "systemctlsockunit": 1, "systemctltimer": 1,
 		"systemctltimerloaded": 1, "systemctlunitfilestatus": 2,
-		"pacmanignore": 1,
+		"nofailedunits": 0, "failedunitsbelow": 1, "unitproperty": 3,
+		"unitenabled": 1, "unitdisabled": 1, "unitmasked": 1,
+		"serviceactive": 1, "serviceenabled": 1,
+		"timerwillrunwithin": 2, "journalcontains": 3, "journalnomatch": 3,
+		"systemstaterunning": 0, "bootedwithin": 1, "boottimebelow": 1,
+		"pacmanignore": 1, "repoenabled": 1, "repogpgcheckenabled": 1,
+		"dpkgheld": 1, "aptpinned": 1, "rpmverify": 1,
+		"unattendedupgradesenabled": 0, "unattendedupgradesorigin": 1,
+		"unattendedupgradesblacklist": 1, "dnfautomaticenabled": 0,
+		"aptkey": 1, "rpmkey": 1, "aptkeysnotexpiring": 1, "rpmkeysnotexpiring": 1,
+		"jwtexpiresafter": 2, "licenseexpiresafter": 3, "configvalid": 1,
+		"memoryfreeabove": 1, "memoryusedbelow": 1, "loadaveragebelow": 2,
+		"cpucountatleast": 1, "prometheusexporter": 1,
+		"prometheusmetric": 4, "uptimeatleast": 1, "uptimebelow": 1,
+		"rebootnotrequired": 0, "logrotateconfigured": 1,
+		"logrotated": 3, "backupfresh": 3, "interfaceup": 1,
+		"interfacehasip": 2, "interfacemtu": 2, "interfacemac": 2,
+		"hasdefaultgateway": 0, "gatewayis": 1, "routeexists": 1,
+		"tmpclean": 2, "clocksourceis": 1, "tscreliable": 0,
+		"iptablesruleexists": 4, "nftablesruleexists": 4,
+		"firewalldserviceenabled": 1, "timesynchronized": 1,
+		"sysfsvalue": 2, "sysfsmatches": 2, "processenvhas": 2,
+		"processenvlacks": 2, "unitenvhas": 2, "unitenvlacks": 2,
+		"ipv6enabled": 0, "ipv6disabled": 0, "hasglobalipv6": 0,
+		"ipv6defaultroute": 0, "sshdconfig": 2, "librariesresolve": 1,
+		"linksagainst": 2, "authorizedkeypresent": 2,
+		"authorizedkeyabsent": 2, "elfpie": 1, "elfrelro": 1,
+		"elfstackcanary": 1, "elfnoexecstack": 1, "diskfillforecast": 2,
+		"passwordexpireswithin": 2, "passwordmaxagebelow": 2,
+		"accountlocked": 1, "accountnotexpired": 1,
+		"containerdiskusagebelow": 2, "orphanedpackagesbelow": 1,
+		"rpmnoduplicates": 0, "verifyfailuresbelow": 1,
+		"selinuxenforcing": 0, "selinuxbooleanis": 2,
+		"filehasselinuxcontext": 2, "apparmorenabled": 0,
+		"apparmorprofileenforced": 1, "filecontains": 2,
+		"filenotcontains": 2, "filematchesregex": 2,
+		"configdirective": 3, "egressip": 1, "validjson": 1,
+		"validyaml": 1, "validini": 1, "validtoml": 1,
+		"jsonkeyequals": 3, "wireguardhaspeer": 2,
+		"wireguardhandshakewithin": 2, "wireguardtunnelpasses": 2,
+		"dnssecvalidated": 1, "direxists": 1, "dirempty": 1,
+		"dirfilecountbetween": 3, "dircontainsmatching": 2,
+		"rtcinsync": 1, "rtcmode": 1, "fileimmutable": 1,
+		"fileappendonly": 1, "filehasxattr": 2, "fipsenabled": 0,
+		"cryptopolicyis": 1, "fileaclgrants": 3, "fileacldenies": 3,
+		"smarthealthy": 1, "smartattributebelow": 3,
+		"securebootenabled": 0, "tpm2present": 0, "tpmpcrbank": 1,
+		"imapolicyloaded": 0, "imameasurementsabove": 1,
+		"imatemplateis": 1, "mdraidhealthy": 0, "lvexists": 1,
+		"vgfreespaceabove": 2, "pvpresent": 1, "zpoolhealthy": 0,
+		"zpoolcapacitybelow": 2, "zfsdatasetexists": 1,
+		"btrfsnodeviceerrors": 1, "helmreleasedeployed": 2,
+		"helmchartversion": 3, "cputempbelow": 1, "sensorbelow": 2,
+		"entropyabove": 1, "cloudinitdone": 0, "cloudinitmoduleran": 1,
+		"imdsv2required": 0, "imdshoplimitatmost": 1, "fdusagebelow": 1,
+		"processfdsbelow": 2, "processlimitatleast": 3,
+		"bannermatches": 2, "bannerchecksum": 3,
+		"nounexpectedlisteningports":  1,
+		"nounexpectedenabledservices": 1, "nounexpecteduid0users": 0,
+		"norecentcoredumps": 1, "norecentoomkills": 1,
+		"dockerdaemonhealthy": 0, "dockerstoragedriveris": 1,
+		"registryreachable": 1, "wificonnected": 2,
+		"wifisignalabove": 2, "wifiregdomain": 1,
+		"dockervolumeexists": 1, "dockernetworkexists": 1,
+		"composeprojectup": 1, "modemregistered": 0,
+		"modemsignalabove": 1, "modembearerconnected": 1,
+		"bluetoothpowered": 0, "bluetoothdevicepaired": 1,
+		"bluetoothdeviceconnected": 1, "kubelethealthy": 0,
+		"nodeready": 0, "podrunning": 2, "podrestartsbelow": 3,
+		"pinotthrottled": 0, "sdcardhealthy": 0, "pigpumemory": 1,
+		"postgresreachable": 1, "mysqlreachable": 1,
+		"redisreachable": 1, "sqlqueryreturns": 3,
+		"postgresreplicationlagbelow": 2, "mysqlreplicarunning": 1,
+		"redisreplicationconnected": 1, "rabbitmqqueuedepthbelow": 3,
+		"kafkabrokerreachable": 1, "kafkaconsumerlagbelow": 3,
+		"nginxconfigvalid": 0, "apacheconfigvalid": 0,
+		"haproxyconfigvalid": 0, "nginxvhostserves": 1, "smtpbanner": 1,
+		"tcpbannermatches": 2, "smtpcansend": 1, "ldapbindsucceeds": 2,
+		"kerberoskinitsucceeds": 1, "keytabvalid": 0,
+		"consulmemberalive": 0, "consulservicehealthy": 1,
+		"etcdendpointhealthy": 1, "zookeeperruok": 1,
+		"instancetypeis": 1, "inregion": 1, "hasiamrole": 0,
+		"hasserviceaccount": 0, "instancetagequals": 2,
+		"runningonvirtualization": 0, "runningincontainer": 0,
+	}
+	// a dictionary with the number of optional parameters that each method
+	// can take after its required ones
+	optionalParameters := map[string]int{
+		"installed": 1, "runtimeversion": 1, "systemctlloaded": 1, "systemctlactive": 1,
+		"systemctlsockpath": 1, "systemctlsockunit": 1, "systemctltimer": 1,
+		"systemctltimerloaded": 1, "systemctlunitfilestatus": 1,
+		"unitenabled": 1, "unitdisabled": 1, "unitmasked": 1,
+		"nofailedunits": 1, "failedunitsbelow": 1, "unitproperty": 1,
+		"systemstaterunning": 1, "timerwillrunwithin": 1,
+		"configvalid": 1, "logrotateconfigured": 1, "routeexists": 1,
+		"firewalldserviceenabled": 1, "unitenvhas": 1,
+		"unitenvlacks": 1, "hasglobalipv6": 1, "sshdconfig": 1,
+		"elfrelro": 1, "diskfillforecast": 1,
+		"containerdiskusagebelow": 1, "filematchesregex": 1,
+		"egressip": 1, "wireguardhandshakewithin": 1,
+		"dnssecvalidated": 2, "dircontainsmatching": 1,
+		"filehasxattr": 1, "tpmpcrbank": 1, "mdraidhealthy": 1,
+		"zpoolhealthy": 1, "nounexpecteduid0users": 1,
+		"dockerdaemonhealthy": 1, "registryreachable": 1,
+		"dockernetworkexists": 2, "modemregistered": 2,
+		"modemsignalabove": 1, "modembearerconnected": 1,
+		"bluetoothpowered": 1, "bluetoothdevicepaired": 1,
+		"bluetoothdeviceconnected": 1, "nodeready": 1,
+		"pinotthrottled": 1, "sdcardhealthy": 1,
+		"rabbitmqqueuedepthbelow": 1, "nginxconfigvalid": 1,
+		"apacheconfigvalid": 1, "haproxyconfigvalid": 1,
+		"nginxvhostserves": 1, "smtpbanner": 1, "smtpcansend": 1,
+		"ldapbindsucceeds": 1, "kerberoskinitsucceeds": 1,
+		"keytabvalid": 2, "consulmemberalive": 1, "hasiamrole": 1,
+		"hasserviceaccount": 1, "runningonvirtualization": 1,
+		"runningincontainer": 1,
+	}
+	check := strings.ToLower(chk.Check)
+	checkParameterLength(chk, numParameters[check], optionalParameters[check])
+}
+
+// parseDuration parses a duration given as a check parameter, like "36h" or
+// "90m", and exits with a helpful message if it can't
+func parseDuration(str string) time.Duration {
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		log.Fatal("Could not parse duration: " + str + "\n\tExamples: 1h30m, 90s")
 	}
-	checkParameterLength(chk, numParameters[strings.ToLower(chk.Check)])
+	return duration
+}
+
+// parseDays parses a number of days given as a check parameter
+func parseDays(str string) int {
+	days, err := strconv.ParseInt(str, 10, 32)
+	if err != nil {
+		log.Fatal("Could not parse number of days: " + str)
+	}
+	return int(days)
+}
+
+// parseList parses a comma-separated list given as a check parameter, like
+// the allowlists of the "no unexpected" checks
+func parseList(str string) (items []string) {
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// optionalParameter returns the check's parameter at index i, or "" if that
+// optional parameter wasn't given
+func optionalParameter(chk Check, i int) string {
+	if len(chk.Parameters) > i {
+		return chk.Parameters[i]
+	}
+	return ""
 }
 
 // getThunk passes a Check's parameters to the correct Thunk constructor based
@@ -114,6 +286,11 @@ func validateParameters(chk Check) {
 // were specified.
 func getThunk(chk Check) Thunk {
 	validateParameters(chk)
+	if imageRoot != "" && !strIn(strings.ToLower(chk.Check), imageChecks) {
+		msg := "Check can't run against a container image: " + chk.Check
+		msg += "\n\tOnly file, package, user, and SSH config checks look inside images"
+		log.Fatal(msg)
+	}
 	switch strings.ToLower(chk.Check) {
 	case "command":
 		return Command(chk.Parameters[0])
@@ -121,7 +298,7 @@ func getThunk(chk Check) Thunk {
 		return Running(chk.Parameters[0])
 	case "file":
 		return File(chk.Parameters[0])
-	case "directory":
+	case "directory", "direxists":
 		return Directory(chk.Parameters[0])
 	case "symlink":
 		return Symlink(chk.Parameters[0])
@@ -141,7 +318,7 @@ func getThunk(chk Check) Thunk {
 		return Port(int(portInt))
 	case "interface":
 		return Interface(chk.Parameters[0])
-	case "up":
+	case "up", "interfaceup":
 		return Up(chk.Parameters[0])
 	case "ip4":
 		return Ip4(chk.Parameters[0], chk.Parameters[1])
@@ -167,6 +344,15 @@ func getThunk(chk Check) Thunk {
 		return Module(chk.Parameters[0])
 	case "kernelparameter":
 		return KernelParameter(chk.Parameters[0])
+	case "runtimeversion":
+		executable := optionalParameter(chk, 2)
+		return runtimeVersion(chk.Parameters[0], chk.Parameters[1], executable)
+	case "cronentry":
+		return cronEntry(chk.Parameters[0], chk.Parameters[1])
+	case "crondirhasscript":
+		return cronDirHasScript(chk.Parameters[0], chk.Parameters[1])
+	case "alternative":
+		return Alternative(chk.Parameters[0], chk.Parameters[1])
 	case "dockerimage":
 		return DockerImage(chk.Parameters[0])
 	case "dockerrunning":
@@ -194,29 +380,568 @@ func getThunk(chk Check) Thunk {
 	case "userhashomedir":
 		return UserHasHomeDir(chk.Parameters[0], chk.Parameters[1])
 	case "installed":
-		return Installed(chk.Parameters[0])
+		return Installed(chk.Parameters[0], optionalParameter(chk, 1))
 	case "ppa":
 		return PPA(chk.Parameters[0])
 	case "yumrepo":
 		return YumRepoExists(chk.Parameters[0])
 	case "yumrepourl":
 		return YumRepoURL(chk.Parameters[0])
+	case "repoenabled":
+		return repoEnabled(chk.Parameters[0])
+	case "repogpgcheckenabled":
+		return repoGPGCheckEnabled(chk.Parameters[0])
+	case "dpkgheld":
+		return dpkgHeld(chk.Parameters[0])
+	case "aptpinned":
+		return aptPinned(chk.Parameters[0])
+	case "rpmverify":
+		return rpmVerify(chk.Parameters[0])
+	case "aptkey":
+		return aptKey(chk.Parameters[0])
+	case "rpmkey":
+		return rpmKey(chk.Parameters[0])
+	case "aptkeysnotexpiring":
+		return aptKeysNotExpiring(parseDays(chk.Parameters[0]))
+	case "rpmkeysnotexpiring":
+		return rpmKeysNotExpiring(parseDays(chk.Parameters[0]))
+	case "unattendedupgradesenabled":
+		return unattendedUpgradesEnabled()
+	case "unattendedupgradesorigin":
+		return unattendedUpgradesOrigin(chk.Parameters[0])
+	case "unattendedupgradesblacklist":
+		return unattendedUpgradesBlacklist(chk.Parameters[0])
+	case "dnfautomaticenabled":
+		return dnfAutomaticEnabled()
 	case "pacmanignore":
 		return pacmanIgnore(chk.Parameters[0])
 	case "systemctlloaded":
-		return systemctlLoaded(chk.Parameters[0])
+		return systemctlLoaded(chk.Parameters[0], optionalParameter(chk, 1))
 	case "systemctlactive":
-		return systemctlActive(chk.Parameters[0])
+		return systemctlActive(chk.Parameters[0], optionalParameter(chk, 1))
 	case "systemctlsockpath":
-		return systemctlSockPath(chk.Parameters[0])
+		return systemctlSockPath(chk.Parameters[0], optionalParameter(chk, 1))
 	case "systemctlsockunit":
-		return systemctlSockUnit(chk.Parameters[0])
+		return systemctlSockUnit(chk.Parameters[0], optionalParameter(chk, 1))
 	case "systemctltimer":
-		return systemctlTimer(chk.Parameters[0])
+		return systemctlTimer(chk.Parameters[0], optionalParameter(chk, 1))
 	case "systemctltimerloaded":
-		return systemctlTimerLoaded(chk.Parameters[0])
+		return systemctlTimerLoaded(chk.Parameters[0], optionalParameter(chk, 1))
 	case "systemctlunitfilestatus":
-		return systemctlUnitFileStatus(chk.Parameters[0], chk.Parameters[1])
+		return systemctlUnitFileStatus(chk.Parameters[0], chk.Parameters[1], optionalParameter(chk, 2))
+	case "unitenabled":
+		return unitEnabled(chk.Parameters[0], optionalParameter(chk, 1))
+	case "unitdisabled":
+		return unitDisabled(chk.Parameters[0], optionalParameter(chk, 1))
+	case "unitmasked":
+		return unitMasked(chk.Parameters[0], optionalParameter(chk, 1))
+	case "nofailedunits":
+		return noFailedUnits(optionalParameter(chk, 0))
+	case "failedunitsbelow":
+		maxInt, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of units: " + chk.Parameters[0])
+		}
+		return failedUnitsBelow(int(maxInt), optionalParameter(chk, 1))
+	case "unitproperty":
+		return unitProperty(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2], optionalParameter(chk, 3))
+	case "serviceactive":
+		return serviceActive(chk.Parameters[0])
+	case "serviceenabled":
+		return serviceEnabled(chk.Parameters[0])
+	case "timerwillrunwithin":
+		hours, err := strconv.ParseFloat(chk.Parameters[1], 64)
+		if err != nil {
+			log.Fatal("Could not parse number of hours: " + chk.Parameters[1])
+		}
+		within := time.Duration(hours * float64(time.Hour))
+		return timerWillRunWithin(chk.Parameters[0], within, optionalParameter(chk, 2))
+	case "journalcontains":
+		window := parseDuration(chk.Parameters[2])
+		return journalContains(chk.Parameters[0], chk.Parameters[1], window)
+	case "journalnomatch":
+		window := parseDuration(chk.Parameters[2])
+		return journalNoMatch(chk.Parameters[0], chk.Parameters[1], window)
+	case "systemstaterunning":
+		return systemStateRunning(optionalParameter(chk, 0))
+	case "bootedwithin", "uptimebelow":
+		return bootedWithin(parseDuration(chk.Parameters[0]))
+	case "boottimebelow":
+		seconds, err := strconv.ParseFloat(chk.Parameters[0], 64)
+		if err != nil {
+			log.Fatal("Could not parse number of seconds: " + chk.Parameters[0])
+		}
+		return bootTimeBelow(time.Duration(seconds * float64(time.Second)))
+	case "jwtexpiresafter":
+		return jwtExpiresAfter(chk.Parameters[0], parseDays(chk.Parameters[1]))
+	case "licenseexpiresafter":
+		days := parseDays(chk.Parameters[2])
+		return licenseExpiresAfter(chk.Parameters[0], chk.Parameters[1], days)
+	case "configvalid":
+		return configValid(chk.Parameters[0], optionalParameter(chk, 1))
+	case "memoryfreeabove":
+		return memoryFreeAbove(chk.Parameters[0])
+	case "memoryusedbelow":
+		return memoryUsedBelow(chk.Parameters[0])
+	case "loadaveragebelow":
+		max, err := strconv.ParseFloat(chk.Parameters[1], 64)
+		if err != nil {
+			log.Fatal("Could not parse load average: " + chk.Parameters[1])
+		}
+		return loadAverageBelow(chk.Parameters[0], max)
+	case "cpucountatleast":
+		count, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of CPUs: " + chk.Parameters[0])
+		}
+		return cpuCountAtLeast(int(count))
+	case "prometheusexporter":
+		return prometheusExporter(chk.Parameters[0])
+	case "prometheusmetric":
+		min, err := strconv.ParseFloat(chk.Parameters[2], 64)
+		if err != nil {
+			log.Fatal("Could not parse minimum: " + chk.Parameters[2])
+		}
+		max, err := strconv.ParseFloat(chk.Parameters[3], 64)
+		if err != nil {
+			log.Fatal("Could not parse maximum: " + chk.Parameters[3])
+		}
+		return prometheusMetric(chk.Parameters[0], chk.Parameters[1], min, max)
+	case "uptimeatleast":
+		return uptimeAtLeast(parseDuration(chk.Parameters[0]))
+	case "rebootnotrequired":
+		return rebootNotRequired()
+	case "logrotateconfigured":
+		return logrotateConfigured(chk.Parameters[0], optionalParameter(chk, 1))
+	case "logrotated":
+		maxSize := parseSize(chk.Parameters[1])
+		return logRotated(chk.Parameters[0], maxSize, parseDuration(chk.Parameters[2]))
+	case "backupfresh":
+		maxAge := parseDuration(chk.Parameters[1])
+		return backupFresh(chk.Parameters[0], maxAge, parseSize(chk.Parameters[2]))
+	case "interfacehasip":
+		return InterfaceHasIP(chk.Parameters[0], chk.Parameters[1])
+	case "interfacemtu":
+		mtu, err := strconv.ParseInt(chk.Parameters[1], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse MTU: " + chk.Parameters[1])
+		}
+		return InterfaceMTU(chk.Parameters[0], int(mtu))
+	case "interfacemac":
+		return InterfaceMAC(chk.Parameters[0], chk.Parameters[1])
+	case "hasdefaultgateway":
+		return HasDefaultGateway()
+	case "gatewayis":
+		return GatewayIs(chk.Parameters[0])
+	case "routeexists":
+		return RouteExists(chk.Parameters[0], optionalParameter(chk, 1))
+	case "tmpclean":
+		return tmpClean(parseDays(chk.Parameters[0]), parseSize(chk.Parameters[1]))
+	case "clocksourceis":
+		return clocksourceIs(chk.Parameters[0])
+	case "tscreliable":
+		return tscReliable()
+	case "iptablesruleexists":
+		return iptablesRuleExists(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2], chk.Parameters[3])
+	case "nftablesruleexists":
+		return nftablesRuleExists(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2], chk.Parameters[3])
+	case "firewalldserviceenabled":
+		return firewalldServiceEnabled(chk.Parameters[0], optionalParameter(chk, 1))
+	case "timesynchronized":
+		maxOffset, err := strconv.ParseFloat(chk.Parameters[0], 64)
+		if err != nil {
+			log.Fatal("Could not parse offset in milliseconds: " + chk.Parameters[0])
+		}
+		return timeSynchronized(maxOffset)
+	case "sysfsvalue":
+		return sysfsValue(chk.Parameters[0], chk.Parameters[1])
+	case "sysfsmatches":
+		return sysfsMatches(chk.Parameters[0], chk.Parameters[1])
+	case "processenvhas":
+		return processEnvironment(chk.Parameters[0], chk.Parameters[1], false)
+	case "processenvlacks":
+		return processEnvironment(chk.Parameters[0], chk.Parameters[1], true)
+	case "unitenvhas":
+		return unitEnvironment(chk.Parameters[0], chk.Parameters[1], false, optionalParameter(chk, 2))
+	case "unitenvlacks":
+		return unitEnvironment(chk.Parameters[0], chk.Parameters[1], true, optionalParameter(chk, 2))
+	case "ipv6enabled":
+		return IPv6Enabled()
+	case "ipv6disabled":
+		return IPv6Disabled()
+	case "hasglobalipv6":
+		return HasGlobalIPv6(optionalParameter(chk, 0))
+	case "ipv6defaultroute":
+		return IPv6DefaultRoute()
+	case "sshdconfig":
+		return sshdConfig(chk.Parameters[0], chk.Parameters[1], optionalParameter(chk, 2))
+	case "librariesresolve":
+		return librariesResolve(chk.Parameters[0])
+	case "linksagainst":
+		return linksAgainst(chk.Parameters[0], chk.Parameters[1])
+	case "authorizedkeypresent":
+		return authorizedKeyPresent(chk.Parameters[0], chk.Parameters[1])
+	case "authorizedkeyabsent":
+		return authorizedKeyAbsent(chk.Parameters[0], chk.Parameters[1])
+	case "elfpie":
+		return elfPIE(chk.Parameters[0])
+	case "elfrelro":
+		full := strings.ToLower(optionalParameter(chk, 1)) == "full"
+		return elfRELROThunk(chk.Parameters[0], full)
+	case "elfstackcanary":
+		return elfStackCanary(chk.Parameters[0])
+	case "elfnoexecstack":
+		return elfNoExecStack(chk.Parameters[0])
+	case "diskfillforecast":
+		days := parseDays(chk.Parameters[1])
+		return diskFillForecast(chk.Parameters[0], days, optionalParameter(chk, 2))
+	case "passwordexpireswithin":
+		return PasswordExpiresWithin(chk.Parameters[0], parseDays(chk.Parameters[1]))
+	case "passwordmaxagebelow":
+		return PasswordMaxAgeBelow(chk.Parameters[0], parseDays(chk.Parameters[1]))
+	case "accountlocked":
+		return AccountLocked(chk.Parameters[0])
+	case "accountnotexpired":
+		return AccountNotExpired(chk.Parameters[0])
+	case "containerdiskusagebelow":
+		maxSize := parseSize(chk.Parameters[1])
+		return containerDiskUsageBelow(chk.Parameters[0], maxSize, optionalParameter(chk, 2))
+	case "orphanedpackagesbelow":
+		maxInt, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of packages: " + chk.Parameters[0])
+		}
+		return orphanedPackagesBelow(int(maxInt))
+	case "rpmnoduplicates":
+		return rpmNoDuplicates()
+	case "verifyfailuresbelow":
+		maxInt, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of packages: " + chk.Parameters[0])
+		}
+		return verifyFailuresBelow(int(maxInt))
+	case "selinuxenforcing":
+		return selinuxEnforcing()
+	case "selinuxbooleanis":
+		return selinuxBooleanIs(chk.Parameters[0], chk.Parameters[1])
+	case "filehasselinuxcontext":
+		return fileHasSELinuxContext(chk.Parameters[0], chk.Parameters[1])
+	case "apparmorenabled":
+		return apparmorEnabled()
+	case "apparmorprofileenforced":
+		return apparmorProfileEnforced(chk.Parameters[0])
+	case "filecontains":
+		return fileContains(chk.Parameters[0], chk.Parameters[1])
+	case "filenotcontains":
+		return fileNotContains(chk.Parameters[0], chk.Parameters[1])
+	case "filematchesregex":
+		multiline := strings.ToLower(optionalParameter(chk, 2)) == "multiline"
+		return fileMatchesRegex(chk.Parameters[0], chk.Parameters[1], multiline)
+	case "configdirective":
+		return configDirective(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "egressip":
+		return EgressIP(chk.Parameters[0], optionalParameter(chk, 1))
+	case "validjson", "validyaml", "validini", "validtoml":
+		format := strings.ToUpper(strings.TrimPrefix(strings.ToLower(chk.Check), "valid"))
+		return validFormat(format, chk.Parameters[0])
+	case "jsonkeyequals":
+		return jsonKeyEquals(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "wireguardhaspeer":
+		return wireguardHasPeer(chk.Parameters[0], chk.Parameters[1])
+	case "wireguardhandshakewithin":
+		within := parseDuration(chk.Parameters[1])
+		return wireguardHandshakeWithin(chk.Parameters[0], within, optionalParameter(chk, 2))
+	case "wireguardtunnelpasses":
+		return wireguardTunnelPasses(chk.Parameters[0], chk.Parameters[1])
+	case "dnssecvalidated":
+		return dnssecValidated(chk.Parameters[0], optionalParameter(chk, 1), optionalParameter(chk, 2))
+	case "dirempty":
+		return dirEmpty(chk.Parameters[0])
+	case "dirfilecountbetween":
+		min, err := strconv.ParseInt(chk.Parameters[1], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse minimum file count: " + chk.Parameters[1])
+		}
+		max, err := strconv.ParseInt(chk.Parameters[2], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse maximum file count: " + chk.Parameters[2])
+		}
+		return dirFileCountBetween(chk.Parameters[0], int(min), int(max))
+	case "dircontainsmatching":
+		regex := strings.ToLower(optionalParameter(chk, 2)) == "regex"
+		return dirContainsMatching(chk.Parameters[0], chk.Parameters[1], regex)
+	case "rtcinsync":
+		return rtcInSync(parseDuration(chk.Parameters[0]))
+	case "rtcmode":
+		return rtcMode(chk.Parameters[0])
+	case "fileimmutable":
+		return fileImmutable(chk.Parameters[0])
+	case "fileappendonly":
+		return fileAppendOnly(chk.Parameters[0])
+	case "filehasxattr":
+		return fileHasXattr(chk.Parameters[0], chk.Parameters[1], optionalParameter(chk, 2))
+	case "fipsenabled":
+		return fipsEnabled()
+	case "cryptopolicyis":
+		return cryptoPolicyIs(chk.Parameters[0])
+	case "fileaclgrants":
+		return fileACLGrants(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "fileacldenies":
+		return fileACLDenies(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "smarthealthy":
+		return smartHealthy(chk.Parameters[0])
+	case "smartattributebelow":
+		max, err := strconv.ParseInt(chk.Parameters[2], 10, 64)
+		if err != nil {
+			log.Fatal("Could not parse SMART attribute value: " + chk.Parameters[2])
+		}
+		return smartAttributeBelow(chk.Parameters[0], chk.Parameters[1], max)
+	case "securebootenabled":
+		return secureBootEnabled()
+	case "tpm2present":
+		return tpm2Present()
+	case "tpmpcrbank":
+		var pcrs []string
+		if list := optionalParameter(chk, 1); list != "" {
+			pcrs = strings.Split(list, ",")
+		}
+		return tpmPCRBank(chk.Parameters[0], pcrs)
+	case "imapolicyloaded":
+		return imaPolicyLoaded()
+	case "imameasurementsabove":
+		min, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of measurements: " + chk.Parameters[0])
+		}
+		return imaMeasurementsAbove(int(min))
+	case "imatemplateis":
+		return imaTemplateIs(chk.Parameters[0])
+	case "mdraidhealthy":
+		return mdraidHealthy(optionalParameter(chk, 0))
+	case "lvexists":
+		return lvExists(chk.Parameters[0])
+	case "vgfreespaceabove":
+		return vgFreeSpaceAbove(chk.Parameters[0], parseSize(chk.Parameters[1]))
+	case "pvpresent":
+		return pvPresent(chk.Parameters[0])
+	case "zpoolhealthy":
+		return zpoolHealthy(optionalParameter(chk, 0))
+	case "zpoolcapacitybelow":
+		maxInt, err := strconv.ParseInt(strings.TrimSuffix(chk.Parameters[1], "%"), 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse percentage: " + chk.Parameters[1])
+		}
+		return zpoolCapacityBelow(chk.Parameters[0], int(maxInt))
+	case "zfsdatasetexists":
+		return zfsDatasetExists(chk.Parameters[0])
+	case "btrfsnodeviceerrors":
+		return btrfsNoDeviceErrors(chk.Parameters[0])
+	case "helmreleasedeployed":
+		return helmReleaseDeployed(chk.Parameters[0], chk.Parameters[1])
+	case "helmchartversion":
+		return helmChartVersion(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "cputempbelow":
+		max, err := strconv.ParseFloat(chk.Parameters[0], 64)
+		if err != nil {
+			log.Fatal("Could not parse temperature: " + chk.Parameters[0])
+		}
+		return cpuTempBelow(max)
+	case "sensorbelow":
+		max, err := strconv.ParseFloat(chk.Parameters[1], 64)
+		if err != nil {
+			log.Fatal("Could not parse sensor reading: " + chk.Parameters[1])
+		}
+		return sensorBelow(chk.Parameters[0], max)
+	case "entropyabove":
+		min, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse entropy: " + chk.Parameters[0])
+		}
+		return entropyAbove(int(min))
+	case "cloudinitdone":
+		return cloudInitDone()
+	case "cloudinitmoduleran":
+		return cloudInitModuleRan(chk.Parameters[0])
+	case "imdsv2required":
+		return imdsV2Required()
+	case "imdshoplimitatmost":
+		max, err := strconv.ParseInt(chk.Parameters[0], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse hop limit: " + chk.Parameters[0])
+		}
+		return imdsHopLimitAtMost(int(max))
+	case "fdusagebelow":
+		return fdUsageBelow(chk.Parameters[0])
+	case "processfdsbelow":
+		max, err := strconv.ParseInt(chk.Parameters[1], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of file descriptors: " + chk.Parameters[1])
+		}
+		return processFDsBelow(chk.Parameters[0], int(max))
+	case "processlimitatleast":
+		return processLimitAtLeast(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "bannermatches":
+		return bannerMatches(chk.Parameters[0], chk.Parameters[1])
+	case "bannerchecksum":
+		return bannerChecksum(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "nounexpectedlisteningports":
+		return noUnexpectedListeningPorts(parseList(chk.Parameters[0]))
+	case "nounexpectedenabledservices":
+		return noUnexpectedEnabledServices(parseList(chk.Parameters[0]))
+	case "nounexpecteduid0users":
+		return noUnexpectedUID0Users(parseList(optionalParameter(chk, 0)))
+	case "norecentcoredumps":
+		return noRecentCoredumps(parseDuration(chk.Parameters[0]))
+	case "norecentoomkills":
+		return noRecentOOMKills(parseDuration(chk.Parameters[0]))
+	case "dockerdaemonhealthy":
+		return dockerDaemonHealthy(optionalParameter(chk, 0))
+	case "dockerstoragedriveris":
+		return dockerStorageDriverIs(chk.Parameters[0])
+	case "registryreachable":
+		authenticated := false
+		switch optionalParameter(chk, 1) {
+		case "auth":
+			authenticated = true
+		case "":
+		default:
+			log.Fatal("Invalid option for registryReachable: " + chk.Parameters[1])
+		}
+		return registryReachable(chk.Parameters[0], authenticated)
+	case "wificonnected":
+		return wifiConnected(chk.Parameters[0], chk.Parameters[1])
+	case "wifisignalabove":
+		min, err := strconv.ParseInt(chk.Parameters[1], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse signal strength: " + chk.Parameters[1])
+		}
+		return wifiSignalAbove(chk.Parameters[0], int(min))
+	case "wifiregdomain":
+		return wifiRegDomain(chk.Parameters[0])
+	case "dockervolumeexists":
+		return dockerVolumeExists(chk.Parameters[0])
+	case "dockernetworkexists":
+		return dockerNetworkExists(chk.Parameters[0], optionalParameter(chk, 1), optionalParameter(chk, 2))
+	case "composeprojectup":
+		return composeProjectUp(chk.Parameters[0])
+	case "modemregistered":
+		roaming := false
+		switch optionalParameter(chk, 1) {
+		case "roaming":
+			roaming = true
+		case "":
+		default:
+			log.Fatal("Invalid option for modemRegistered: " + chk.Parameters[1])
+		}
+		return modemRegistered(optionalParameter(chk, 0), roaming)
+	case "modemsignalabove":
+		min, err := strconv.ParseInt(strings.TrimSuffix(chk.Parameters[0], "%"), 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse signal quality: " + chk.Parameters[0])
+		}
+		return modemSignalAbove(int(min), optionalParameter(chk, 1))
+	case "modembearerconnected":
+		return modemBearerConnected(chk.Parameters[0], optionalParameter(chk, 1))
+	case "bluetoothpowered":
+		return bluetoothPowered(optionalParameter(chk, 0))
+	case "bluetoothdevicepaired":
+		return bluetoothDevicePaired(chk.Parameters[0], optionalParameter(chk, 1))
+	case "bluetoothdeviceconnected":
+		return bluetoothDeviceConnected(chk.Parameters[0], optionalParameter(chk, 1))
+	case "kubelethealthy":
+		return kubeletHealthy()
+	case "nodeready":
+		return nodeReady(optionalParameter(chk, 0))
+	case "podrunning":
+		return podRunning(chk.Parameters[0], chk.Parameters[1])
+	case "podrestartsbelow":
+		max, err := strconv.ParseInt(chk.Parameters[2], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse number of restarts: " + chk.Parameters[2])
+		}
+		return podRestartsBelow(chk.Parameters[0], chk.Parameters[1], int(max))
+	case "pinotthrottled":
+		sinceBoot := false
+		switch optionalParameter(chk, 0) {
+		case "since-boot":
+			sinceBoot = true
+		case "":
+		default:
+			log.Fatal("Invalid option for piNotThrottled: " + chk.Parameters[0])
+		}
+		return piNotThrottled(sinceBoot)
+	case "sdcardhealthy":
+		return sdCardHealthy(optionalParameter(chk, 0))
+	case "pigpumemory":
+		return piGPUMemory(chk.Parameters[0])
+	case "postgresreachable":
+		return postgresReachable(chk.Parameters[0])
+	case "mysqlreachable":
+		return mysqlReachable(chk.Parameters[0])
+	case "redisreachable":
+		return redisReachable(chk.Parameters[0])
+	case "sqlqueryreturns":
+		return sqlQueryReturns(chk.Parameters[0], chk.Parameters[1], chk.Parameters[2])
+	case "postgresreplicationlagbelow":
+		return postgresReplicationLagBelow(chk.Parameters[0], parseDuration(chk.Parameters[1]))
+	case "mysqlreplicarunning":
+		return mysqlReplicaRunning(chk.Parameters[0])
+	case "redisreplicationconnected":
+		return redisReplicationConnected(chk.Parameters[0])
+	case "rabbitmqqueuedepthbelow":
+		max, err := strconv.ParseInt(chk.Parameters[2], 10, 32)
+		if err != nil {
+			log.Fatal("Could not parse queue depth: " + chk.Parameters[2])
+		}
+		return rabbitmqQueueDepthBelow(chk.Parameters[0], chk.Parameters[1], int(max), optionalParameter(chk, 3))
+	case "kafkabrokerreachable":
+		return kafkaBrokerReachable(chk.Parameters[0])
+	case "kafkaconsumerlagbelow":
+		max, err := strconv.ParseInt(chk.Parameters[2], 10, 64)
+		if err != nil {
+			log.Fatal("Could not parse consumer lag: " + chk.Parameters[2])
+		}
+		return kafkaConsumerLagBelow(chk.Parameters[0], chk.Parameters[1], max)
+	case "nginxconfigvalid":
+		return configValid("nginx", optionalParameter(chk, 0))
+	case "apacheconfigvalid":
+		return configValid("apache", optionalParameter(chk, 0))
+	case "haproxyconfigvalid":
+		return configValid("haproxy", optionalParameter(chk, 0))
+	case "nginxvhostserves":
+		return nginxVhostServes(chk.Parameters[0], optionalParameter(chk, 1))
+	case "smtpbanner":
+		return smtpBanner(chk.Parameters[0], optionalParameter(chk, 1))
+	case "tcpbannermatches":
+		return tcpBannerMatches(chk.Parameters[0], compileRegex(chk.Parameters[1]))
+	case "smtpcansend":
+		return smtpCanSend(chk.Parameters[0], strings.ToLower(optionalParameter(chk, 1)) == "starttls")
+	case "ldapbindsucceeds":
+		return ldapBindSucceeds(chk.Parameters[0], chk.Parameters[1], optionalParameter(chk, 2))
+	case "kerberoskinitsucceeds":
+		return kerberosKinitSucceeds(chk.Parameters[0], optionalParameter(chk, 1))
+	case "keytabvalid":
+		return keytabValid(optionalParameter(chk, 0), optionalParameter(chk, 1))
+	case "consulmemberalive":
+		return consulMemberAlive(optionalParameter(chk, 0))
+	case "consulservicehealthy":
+		return consulServiceHealthy(chk.Parameters[0])
+	case "etcdendpointhealthy":
+		return etcdEndpointHealthy(chk.Parameters[0])
+	case "zookeeperruok":
+		return zookeeperRuok(chk.Parameters[0])
+	case "instancetypeis":
+		return instanceTypeIs(chk.Parameters[0])
+	case "inregion":
+		return inRegion(chk.Parameters[0])
+	case "hasiamrole", "hasserviceaccount":
+		return hasCloudIdentity(optionalParameter(chk, 0))
+	case "instancetagequals":
+		return instanceTagEquals(chk.Parameters[0], chk.Parameters[1])
+	case "runningonvirtualization":
+		return runningOnVirtualization(parseList(optionalParameter(chk, 0)))
+	case "runningincontainer":
+		return runningInContainer(parseList(optionalParameter(chk, 0)))
 	default:
 		msg := "JSON file included one or more unsupported health checks: "
 		msg += "\n\tName: " + chk.Name
@@ -235,6 +960,19 @@ func getChecklist(path string) (chklst Checklist) {
 	if err != nil {
 		log.Fatal("Could not parse JSON at " + path + ":\n\t" + err.Error())
 	}
+	// leave out checks that don't apply to this host
+	var applicable []Check
+	for _, chk := range chklst.Checklist {
+		if checkApplies(chk) {
+			chk.RawParameters = chk.Parameters
+			chk.Parameters = expandFacts(chk.Parameters)
+			applicable = append(applicable, chk)
+		} else {
+			chklst.Skipped++
+			verbosityPrint("Skipping check, When condition is false: "+chk.When, maxVerbosity)
+		}
+	}
+	chklst.Checklist = applicable
 	// Go concurrent pipe - one stage to the next
 	// send all checks in checklist to the channel
 	out := make(chan Check)
@@ -248,6 +986,7 @@ func getChecklist(path string) (chklst Checklist) {
 	out2 := make(chan Check)
 	go func() {
 		for chk := range out {
+			checkWeight(chk) // fail early on invalid weights
 			chk.Fun = getThunk(chk)
 			out2 <- chk
 		}
@@ -262,8 +1001,9 @@ func getChecklist(path string) (chklst Checklist) {
 	return
 }
 
-// getVerbosity returns the verbosity specifed by the -v flag, and checks to
-// see that it is in a valid range
+// getFlags parses the command line flags, checks that the verbosity specified
+// by the -v flag is in a valid range, and returns the checklist path given
+// with -f
 func getFlags() string {
 	verbosityMsg := "Output verbosity level (valid values are "
 	verbosityMsg += "[" + fmt.Sprint(minVerbosity) + "-" + fmt.Sprint(maxVerbosity) + "])"
@@ -271,15 +1011,33 @@ func getFlags() string {
 	verbosityMsg += "\n\t 1: Display errors and some information."
 	verbosityMsg += "\n\t 2: Display everything that's happening."
 	pathMsg := "Use the health check JSON located at this path"
+	maintenanceMsg := "Use the maintenance windows in the JSON located at this path"
+	rolesMsg := "Detect this host's roles with the JSON located at this path, "
+	rolesMsg += "and run their checklists"
+	imageMsg := "Run file, package, and user checks against this container "
+	imageMsg += "image (a docker save tarball, OCI layout, or image name) "
+	imageMsg += "instead of the host"
+	runLogMsg := "Append every check result to the JSON Lines file at this path"
+	runLogSizeMsg := "Rotate the -l log to <path>.1 when it reaches this size "
+	runLogSizeMsg += "(e.g. 10MB, or 0 to never rotate)"
+	factsMsg := "Print this host's facts, as used in When conditions, and exit"
+	outputMsg := "Output format for -facts (text or json)"
 
 	verbosityFlag := flag.Int("v", 1, verbosityMsg)
 	path := flag.String("f", "", pathMsg)
+	flag.StringVar(&maintenancePath, "m", "", maintenanceMsg)
+	flag.StringVar(&rolesPath, "r", "", rolesMsg)
+	flag.StringVar(&imagePath, "i", "", imageMsg)
+	flag.StringVar(&runLogPath, "l", "", runLogMsg)
+	flag.StringVar(&runLogMaxSize, "log-max-size", "10MB", runLogSizeMsg)
+	flag.BoolVar(&showFacts, "facts", false, factsMsg)
+	flag.StringVar(&factsFormat, "output", "text", outputMsg)
 	flag.Parse()
 
 	verbosity = *verbosityFlag
 	// check for invalid options
-	if *path == "" {
-		log.Fatal("No path specified. Use -f option.")
+	if *path == "" && rolesPath == "" && !showFacts {
+		log.Fatal("No path specified. Use -f or -r option.")
 	}
 	// check for invalid options
 	if verbosity > maxVerbosity || verbosity < minVerbosity {
@@ -300,6 +1058,9 @@ func verbosityPrint(str string, minVerb int) {
 func runChecks(chklst Checklist) Checklist {
 	for _, chk := range chklst.Checklist {
 		code, msg := chk.Fun()
+		if code != 0 && chk.Runbook != "" {
+			msg += "\n\tRunbook: " + chk.Runbook
+		}
 		chklst.Codes = append(chklst.Codes, code)
 		chklst.Messages = append(chklst.Messages, msg)
 		if verbosity >= maxVerbosity && code == 0 {
@@ -312,17 +1073,16 @@ func runChecks(chklst Checklist) Checklist {
 	return chklst
 }
 
-// main reads the command line flag -f, runs the Check specified in the JSON,
-// and exits with the appropriate message and exit code.
-func main() {
-	// Set up and parse flags
-	path := getFlags()
-
+// runChecklist runs the checklist at path, prints its report, and returns
+// whether any of its checks failed outside of a maintenance window
+func runChecklist(path string) (failed bool) {
 	verbosityPrint("Creating checklist...", minVerbosity+1)
 	chklst := getChecklist(path)
 	// run checks, populate error codes and messages
 	verbosityPrint("Running checks...", minVerbosity+1)
 	chklst = runChecks(chklst)
+	chklst.Score = healthScore(chklst)
+	chklst.Grade = healthGrade(chklst.Score)
 	// make a printable report
 	chklst.Report = makeReport(chklst)
 	// see if any checks failed
@@ -332,10 +1092,58 @@ func main() {
 			anyFailed = true
 		}
 	}
-	if anyFailed {
+	maintenance := anyFailed && inMaintenance(chklst)
+	appendRunLog(path, chklst, maintenance)
+	if maintenance {
+		msg := "Maintenance window active, ignoring failures:\n"
+		verbosityPrint(msg+chklst.Report, minVerbosity)
+		return false
+	} else if anyFailed {
 		verbosityPrint(chklst.Report, minVerbosity)
-		os.Exit(1)
+		return true
 	}
 	verbosityPrint(chklst.Report, maxVerbosity)
+	return false
+}
+
+// main reads the command line flags -f and -r, runs the Checks specified in
+// the JSON, and exits with the appropriate message and exit code.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		runCompare(os.Args[2:])
+	}
+	// Set up and parse flags
+	path := getFlags()
+	if showFacts {
+		printFacts()
+		os.Exit(0)
+	}
+	var paths []string
+	if path != "" {
+		paths = append(paths, path)
+	}
+	if rolesPath != "" {
+		verbosityPrint("Detecting roles...", minVerbosity+1)
+		for _, rolePath := range detectChecklists(rolesPath) {
+			if !strIn(rolePath, paths) {
+				paths = append(paths, rolePath)
+			}
+		}
+	}
+	cleanup := func() {}
+	if imagePath != "" {
+		verbosityPrint("Unpacking image "+imagePath+"...", minVerbosity+1)
+		cleanup = loadImage(imagePath)
+	}
+	anyFailed := false
+	for _, path := range paths {
+		if runChecklist(path) {
+			anyFailed = true
+		}
+	}
+	cleanup()
+	if anyFailed {
+		os.Exit(1)
+	}
 	os.Exit(0)
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a period of planned work during which failing checks
+// are still reported, but don't cause a non-zero exit code. A window is either
+// one-off, with RFC3339 Start and End timestamps, or recurring, with a list of
+// weekdays (e.g. "Sat") and From/To times of day (e.g. "02:00") in local time.
+type MaintenanceWindow struct {
+	Start, End string
+	Days       []string
+	From, To   string
+}
+
+// maintenancePath is the path to a JSON file listing extra maintenance
+// windows, as specified by the -m flag
+var maintenancePath string
+
+// parseWindowTime parses a time with the given layout, and exits with a
+// helpful message if it can't
+func parseWindowTime(layout string, value string) time.Time {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		msg := "Could not parse maintenance window time:"
+		msg += "\n\tGiven: " + value
+		msg += "\n\tExpected format: " + layout
+		log.Fatal(msg)
+	}
+	return t
+}
+
+// active reports whether the given time falls within this window
+func (window MaintenanceWindow) active(now time.Time) bool {
+	if window.Start != "" || window.End != "" {
+		start := parseWindowTime(time.RFC3339, window.Start)
+		end := parseWindowTime(time.RFC3339, window.End)
+		return !now.Before(start) && now.Before(end)
+	}
+	if window.From == "" || window.To == "" {
+		log.Fatal("Maintenance window needs either Start and End, or From and To")
+	}
+	// minutes since midnight, so windows can wrap around it
+	minutes := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	from := minutes(parseWindowTime("15:04", window.From))
+	to := minutes(parseWindowTime("15:04", window.To))
+	current := minutes(now)
+	day := now
+	var inTime bool
+	if from <= to {
+		inTime = current >= from && current < to
+	} else {
+		inTime = current >= from || current < to
+		// after midnight, the window belongs to the day it started on
+		if current < to {
+			day = now.AddDate(0, 0, -1)
+		}
+	}
+	if !inTime {
+		return false
+	}
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, d := range window.Days {
+		if strings.HasPrefix(strings.ToLower(day.Weekday().String()), strings.ToLower(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// getMaintenanceWindows returns the windows from the checklist, along with
+// any from the file given with -m
+func getMaintenanceWindows(chklst Checklist) []MaintenanceWindow {
+	windows := chklst.Maintenance
+	if maintenancePath != "" {
+		var fromFile []MaintenanceWindow
+		err := json.Unmarshal(fileToBytes(maintenancePath), &fromFile)
+		if err != nil {
+			log.Fatal("Could not parse JSON at " + maintenancePath + ":\n\t" + err.Error())
+		}
+		windows = append(windows, fromFile...)
+	}
+	return windows
+}
+
+// inMaintenance reports whether any of this checklist's maintenance windows
+// are currently active
+func inMaintenance(chklst Checklist) bool {
+	now := time.Now()
+	for _, window := range getMaintenanceWindows(chklst) {
+		if window.active(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // php -r 'echo get_cfg_var("default_mimetype");
@@ -127,3 +130,147 @@ func KernelParameter(name string) Thunk {
 		return 1, "Kernel parameter not set: " + name
 	}
 }
+
+// getSysfsValue reads a sysfs attribute. For attributes that list every option
+// with the selected one in brackets, like "[always] madvise never", it returns
+// the selected option.
+func getSysfsValue(path string) string {
+	if !strings.HasPrefix(filepath.Clean(path), "/sys/") {
+		log.Fatal("Not a sysfs path: " + path)
+	}
+	value := strings.TrimSpace(fileToString(path))
+	selected := regexp.MustCompile(`\[([^\]]+)\]`).FindStringSubmatch(value)
+	if selected != nil {
+		return selected[1]
+	}
+	return value
+}
+
+// sysfsValue checks that a sysfs attribute has this value, e.g.
+// /sys/block/sda/queue/scheduler is "mq-deadline"
+func sysfsValue(path string, expected string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		value := getSysfsValue(path)
+		if value == expected {
+			return 0, ""
+		}
+		msg := "Sysfs attribute does not have value: " + path
+		return genericError(msg, expected, []string{value})
+	}
+}
+
+// sysfsMatches checks that a sysfs attribute matches this regular expression
+func sysfsMatches(path string, pattern string) Thunk {
+	re := compileRegex(pattern)
+	return func() (exitCode int, exitMessage string) {
+		value := getSysfsValue(path)
+		if re.MatchString(value) {
+			return 0, ""
+		}
+		msg := "Sysfs attribute does not match regexp: " + path
+		return genericError(msg, pattern, []string{value})
+	}
+}
+
+// getUptime returns how long the system has been running, from /proc/uptime
+func getUptime() time.Duration {
+	fields := strings.Fields(fileToString("/proc/uptime"))
+	if len(fields) < 1 {
+		log.Fatal("Couldn't parse /proc/uptime")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		log.Fatal("Couldn't parse /proc/uptime:\n\t" + err.Error())
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// bootedWithin checks that the system was booted less than the given duration
+// ago, e.g. for verifying that a reboot actually happened
+func bootedWithin(within time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		uptime := getUptime()
+		if uptime < within {
+			return 0, ""
+		}
+		msg := "System was not booted within the given time"
+		return genericError(msg, within.String(), []string{uptime.String()})
+	}
+}
+
+// getAlternative returns the path that the given alternatives group (e.g.
+// "editor", "java") currently points at, using Debian's update-alternatives or
+// Red Hat's alternatives
+func getAlternative(group string) string {
+	out, err := exec.Command("update-alternatives", "--query", group).Output()
+	if err == nil {
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "Value:") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "Value:"))
+			}
+		}
+		return ""
+	}
+	// Red Hat's update-alternatives doesn't support --query
+	out, err = exec.Command("alternatives", "--display", group).Output()
+	if err != nil {
+		log.Fatal("Couldn't query alternatives group: " + group + "\n\t" + err.Error())
+	}
+	re := regexp.MustCompile("link currently points to (.*)")
+	if match := re.FindStringSubmatch(string(out)); match != nil {
+		return strings.TrimSpace(match[1])
+	}
+	return ""
+}
+
+// Alternative checks that the given alternatives group points at the expected
+// target, e.g. that "java" points at a specific JDK
+func Alternative(group string, target string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		actual := getAlternative(group)
+		if actual == target {
+			return 0, ""
+		}
+		msg := "Alternative does not point at target: " + group
+		return genericError(msg, target, []string{actual})
+	}
+}
+
+// uptimeAtLeast checks that the system has been running for at least the given
+// duration, e.g. to catch hosts that keep rebooting
+func uptimeAtLeast(min time.Duration) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		uptime := getUptime()
+		if uptime >= min {
+			return 0, ""
+		}
+		msg := "System uptime is below minimum"
+		return genericError(msg, min.String(), []string{uptime.String()})
+	}
+}
+
+// rebootNotRequired checks that the system doesn't need a reboot to finish
+// applying updates, using /var/run/reboot-required on Debian-based systems
+// and `needs-restarting -r` on Red Hat-based ones
+func rebootNotRequired() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		flagFile := "/var/run/reboot-required"
+		if _, err := os.Stat(flagFile); err == nil {
+			msg := "Reboot required: " + flagFile + " exists"
+			pkgsFile := flagFile + ".pkgs"
+			if _, err := os.Stat(pkgsFile); err == nil {
+				pkgs := strings.Fields(fileToString(pkgsFile))
+				return genericError(msg, "no reboot required", pkgs)
+			}
+			return 1, msg
+		}
+		if _, err := exec.LookPath("needs-restarting"); err == nil {
+			// exits 1 when a reboot is required
+			out, err := exec.Command("needs-restarting", "-r").CombinedOutput()
+			if err != nil {
+				return 1, "Reboot required:\n\t" + strings.TrimSpace(string(out))
+			}
+		}
+		return 0, ""
+	}
+}
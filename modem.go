@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// mmcliJSON runs mmcli, ModemManager's command line client for its D-Bus API,
+// with JSON output, and decodes the result into v
+func mmcliJSON(v interface{}, args ...string) error {
+	out, err := exec.Command("mmcli", append([]string{"-J"}, args...)...).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Modem checks require ModemManager's mmcli")
+	} else if err != nil {
+		return fmt.Errorf("mmcli %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return json.Unmarshal(out, v)
+}
+
+// modemInfo is the part of `mmcli -m <modem>` output the modem checks read
+type modemInfo struct {
+	Modem struct {
+		DBusPath string `json:"dbus-path"`
+		Generic  struct {
+			State         string   `json:"state"`
+			Bearers       []string `json:"bearers"`
+			SignalQuality struct {
+				Value string `json:"value"`
+			} `json:"signal-quality"`
+		} `json:"generic"`
+		ThreeGPP struct {
+			RegistrationState string `json:"registration-state"`
+			OperatorName      string `json:"operator-name"`
+		} `json:"3gpp"`
+	} `json:"modem"`
+}
+
+// getModem returns the state of a modem, given by index or D-Bus path, or of
+// the first modem ModemManager knows about if modem is empty
+func getModem(modem string) (info modemInfo, err error) {
+	if modem == "" {
+		var list struct {
+			Modems []string `json:"modem-list"`
+		}
+		if err = mmcliJSON(&list, "-L"); err != nil {
+			return info, err
+		} else if len(list.Modems) == 0 {
+			return info, fmt.Errorf("ModemManager found no modems")
+		}
+		modem = list.Modems[0]
+	}
+	err = mmcliJSON(&info, "-m", modem)
+	return info, err
+}
+
+// modemRegistered checks that a modem is registered with a cellular network,
+// either its home network or, if roaming is true, a roaming partner's
+func modemRegistered(modem string, roaming bool) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		info, err := getModem(modem)
+		if err != nil {
+			return 1, "Couldn't get modem state:\n\t" + err.Error()
+		}
+		registration := info.Modem.ThreeGPP.RegistrationState
+		if registration == "home" || (roaming && registration == "roaming") {
+			return 0, ""
+		}
+		msg := "Modem is not registered: " + info.Modem.DBusPath
+		return genericError(msg, "home", []string{registration + " (" + info.Modem.Generic.State + ")"})
+	}
+}
+
+// modemSignalAbove checks that a modem's signal quality is above this
+// percentage
+func modemSignalAbove(min int, modem string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		info, err := getModem(modem)
+		if err != nil {
+			return 1, "Couldn't get modem state:\n\t" + err.Error()
+		}
+		quality := info.Modem.Generic.SignalQuality.Value
+		value, err := strconv.ParseInt(quality, 10, 32)
+		if err != nil {
+			log.Fatal("Couldn't parse modem signal quality: " + quality)
+		}
+		if int(value) > min {
+			return 0, ""
+		}
+		msg := "Modem signal is too weak: " + info.Modem.DBusPath
+		return genericError(msg, fmt.Sprint(min)+"%", []string{quality + "%"})
+	}
+}
+
+// modemBearerConnected checks that a modem has a connected data bearer for
+// this APN (e.g. "internet")
+func modemBearerConnected(apn string, modem string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		info, err := getModem(modem)
+		if err != nil {
+			return 1, "Couldn't get modem state:\n\t" + err.Error()
+		}
+		var bearers []string
+		for _, path := range info.Modem.Generic.Bearers {
+			var bearer struct {
+				Bearer struct {
+					Status struct {
+						Connected string `json:"connected"`
+					} `json:"status"`
+					Properties struct {
+						APN string `json:"apn"`
+					} `json:"properties"`
+				} `json:"bearer"`
+			}
+			if err := mmcliJSON(&bearer, "-b", path); err != nil {
+				return 1, "Couldn't get bearer state:\n\t" + err.Error()
+			}
+			props, status := bearer.Bearer.Properties, bearer.Bearer.Status
+			if props.APN == apn && status.Connected == "yes" {
+				return 0, ""
+			}
+			bearers = append(bearers, props.APN+" (connected: "+status.Connected+")")
+		}
+		msg := "Modem has no connected bearer for APN: " + info.Modem.DBusPath
+		return genericError(msg, apn, bearers)
+	}
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // getHexPorts gets all open ports as hex strings from /proc/net/tcp
@@ -171,6 +182,95 @@ func Ip6(name string, address string) Thunk {
 	return getIPThunk(name, address, 6)
 }
 
+// getInterfaceByName returns the network interface with the given name, and
+// whether or not it exists
+func getInterfaceByName(name string) (net.Interface, bool) {
+	for _, iface := range getInterfaces() {
+		if iface.Name == name {
+			return iface, true
+		}
+	}
+	return net.Interface{}, false
+}
+
+// InterfaceHasIP checks that an interface has an address that is either exactly
+// the given IP (v4 or v6), or contained in the given CIDR range
+func InterfaceHasIP(name string, address string) Thunk {
+	var network *net.IPNet
+	ip := net.ParseIP(address)
+	if ip == nil {
+		var err error
+		_, network, err = net.ParseCIDR(address)
+		if err != nil {
+			log.Fatal("Could not parse IP address or CIDR range: " + address)
+		}
+	}
+	return func() (exitCode int, exitMessage string) {
+		iface, ok := getInterfaceByName(name)
+		if !ok {
+			return 1, "Interface does not exist: " + name
+		}
+		addresses, err := iface.Addrs()
+		if err != nil {
+			msg := "Could not get network addresses from interface: "
+			msg += "\n\tInterface name: " + iface.Name
+			msg += "\n\tError: " + err.Error()
+			log.Fatal(msg)
+		}
+		var ips []string
+		for _, addr := range addresses {
+			ifaceIP, _, err := net.ParseCIDR(addr.String())
+			if err != nil {
+				ifaceIP = net.ParseIP(addr.String())
+			}
+			if ifaceIP == nil {
+				continue
+			}
+			if network != nil && network.Contains(ifaceIP) {
+				return 0, ""
+			} else if ip != nil && ip.Equal(ifaceIP) {
+				return 0, ""
+			}
+			ips = append(ips, ifaceIP.String())
+		}
+		return genericError("Interface does not have IP", address, ips)
+	}
+}
+
+// InterfaceMTU checks that an interface has the given MTU
+func InterfaceMTU(name string, mtu int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		iface, ok := getInterfaceByName(name)
+		if !ok {
+			return 1, "Interface does not exist: " + name
+		}
+		if iface.MTU == mtu {
+			return 0, ""
+		}
+		msg := "Interface does not have MTU: " + name
+		return genericError(msg, fmt.Sprint(mtu), []string{fmt.Sprint(iface.MTU)})
+	}
+}
+
+// InterfaceMAC checks that an interface has the given hardware address
+func InterfaceMAC(name string, mac string) Thunk {
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil {
+		log.Fatal("Could not parse MAC address: " + mac)
+	}
+	return func() (exitCode int, exitMessage string) {
+		iface, ok := getInterfaceByName(name)
+		if !ok {
+			return 1, "Interface does not exist: " + name
+		}
+		if iface.HardwareAddr.String() == hwaddr.String() {
+			return 0, ""
+		}
+		msg := "Interface does not have MAC address: " + name
+		return genericError(msg, mac, []string{iface.HardwareAddr.String()})
+	}
+}
+
 // Gateway checks to see that the default gateway has a certain IP
 func Gateway(address string) Thunk {
 	// getGatewayAddress filters all gateway IPs for a non-zero value
@@ -335,3 +435,432 @@ func RoutingTableInterface(name string) Thunk {
 func RoutingTableGateway(ipstr string) Thunk {
 	return routingTableMatch(1, ipstr)
 }
+
+// kernelRoute is a single route from the kernel's IPv4 or IPv6 routing table
+type kernelRoute struct {
+	Destination *net.IPNet
+	Gateway     net.IP
+	Interface   string
+}
+
+// String formats a route like `ip route` does, for error messages
+func (route kernelRoute) String() string {
+	str := route.Destination.String()
+	if route.Gateway != nil && !route.Gateway.IsUnspecified() {
+		str += " via " + route.Gateway.String()
+	}
+	return str + " dev " + route.Interface
+}
+
+// routing table flags, from linux/route.h
+const (
+	routeFlagUp     = 0x0001
+	routeFlagReject = 0x0200
+)
+
+// parseHexIP parses an address from /proc/net/route (little endian, 8 hex
+// digits) or /proc/net/ipv6_route (big endian, 32 hex digits)
+func parseHexIP(hexstr string) net.IP {
+	ip := make(net.IP, len(hexstr)/2)
+	for i := range ip {
+		ip[i] = byte(strHexToDecimal(hexstr[2*i : 2*i+2]))
+	}
+	if len(ip) == net.IPv4len {
+		ip[0], ip[1], ip[2], ip[3] = ip[3], ip[2], ip[1], ip[0]
+	}
+	return ip
+}
+
+// getKernelRoutes reads every usable route from /proc/net/route and
+// /proc/net/ipv6_route
+func getKernelRoutes() (routes []kernelRoute) {
+	// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+	for _, row := range stringToSlice(fileToString("/proc/net/route"))[1:] {
+		if len(row) < 8 || strHexToDecimal(row[3])&routeFlagUp == 0 {
+			continue
+		}
+		mask := net.IPMask(parseHexIP(row[7]))
+		destination := &net.IPNet{IP: parseHexIP(row[1]), Mask: mask}
+		routes = append(routes, kernelRoute{destination, parseHexIP(row[2]), row[0]})
+	}
+	// the IPv6 table may not exist if IPv6 is disabled
+	if _, err := os.Stat("/proc/net/ipv6_route"); err != nil {
+		return routes
+	}
+	// destination prefixlen source prefixlen nexthop metric refcnt use flags iface
+	for _, row := range stringToSlice(fileToString("/proc/net/ipv6_route")) {
+		if len(row) < 10 {
+			continue
+		}
+		flags := strHexToDecimal(row[8])
+		if flags&routeFlagUp == 0 || flags&routeFlagReject != 0 {
+			continue
+		}
+		mask := net.CIDRMask(strHexToDecimal(row[1]), 8*net.IPv6len)
+		destination := &net.IPNet{IP: parseHexIP(row[0]), Mask: mask}
+		routes = append(routes, kernelRoute{destination, parseHexIP(row[4]), row[9]})
+	}
+	return routes
+}
+
+// getDefaultRoutes returns all routes with a zero-length prefix
+func getDefaultRoutes() (routes []kernelRoute) {
+	for _, route := range getKernelRoutes() {
+		if ones, _ := route.Destination.Mask.Size(); ones == 0 {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// routesToStrings formats routes for genericError
+func routesToStrings(routes []kernelRoute) (strs []string) {
+	for _, route := range routes {
+		strs = append(strs, route.String())
+	}
+	return strs
+}
+
+// HasDefaultGateway checks that the kernel has an IPv4 or IPv6 default route
+func HasDefaultGateway() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if len(getDefaultRoutes()) > 0 {
+			return 0, ""
+		}
+		return 1, "No default route in kernel routing table"
+	}
+}
+
+// GatewayIs checks that a default route goes via the given next hop
+func GatewayIs(address string) Thunk {
+	gateway := net.ParseIP(address)
+	if gateway == nil {
+		log.Fatal("Could not parse IP address: " + address)
+	}
+	return func() (exitCode int, exitMessage string) {
+		routes := getDefaultRoutes()
+		for _, route := range routes {
+			if gateway.Equal(route.Gateway) {
+				return 0, ""
+			}
+		}
+		msg := "No default route via gateway"
+		return genericError(msg, address, routesToStrings(routes))
+	}
+}
+
+// RouteExists checks that the kernel has a route to exactly the given
+// destination network. If via is given, the route must also use it as either
+// its next hop or its interface.
+func RouteExists(cidr string, via string) Thunk {
+	_, destination, err := net.ParseCIDR(cidr)
+	if err != nil {
+		log.Fatal("Could not parse CIDR range: " + cidr)
+	}
+	nextHop := net.ParseIP(via)
+	return func() (exitCode int, exitMessage string) {
+		routes := getKernelRoutes()
+		for _, route := range routes {
+			if route.Destination.String() != destination.String() {
+				continue
+			}
+			if via == "" || route.Interface == via || nextHop.Equal(route.Gateway) {
+				return 0, ""
+			}
+		}
+		specified := cidr
+		if via != "" {
+			specified += " via " + via
+		}
+		msg := "Route not found in kernel routing table"
+		return genericError(msg, specified, routesToStrings(routes))
+	}
+}
+
+// ipv6DisabledPath is the sysctl that turns IPv6 off on every interface
+const ipv6DisabledPath = "/proc/sys/net/ipv6/conf/all/disable_ipv6"
+
+// ipv6Active reports whether the kernel supports IPv6, the sysctl doesn't
+// disable it, and some interface has an IPv6 address
+func ipv6Active() (active bool, reason string) {
+	if _, err := os.Stat("/proc/net/if_inet6"); err != nil {
+		return false, "kernel has no IPv6 support"
+	}
+	if strings.TrimSpace(fileToString(ipv6DisabledPath)) == "1" {
+		return false, "net.ipv6.conf.all.disable_ipv6 = 1"
+	}
+	if strings.TrimSpace(fileToString("/proc/net/if_inet6")) == "" {
+		return false, "no interface has an IPv6 address"
+	}
+	return true, "IPv6 addresses are configured"
+}
+
+// IPv6Enabled checks that IPv6 is enabled and in use on this host
+func IPv6Enabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if active, reason := ipv6Active(); !active {
+			return 1, "IPv6 is not enabled: " + reason
+		}
+		return 0, ""
+	}
+}
+
+// IPv6Disabled checks that IPv6 is disabled on this host
+func IPv6Disabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if active, reason := ipv6Active(); active {
+			return 1, "IPv6 is not disabled: " + reason
+		}
+		return 0, ""
+	}
+}
+
+// HasGlobalIPv6 checks that an interface (or any interface, if name is empty)
+// has a global unicast IPv6 address, i.e. not link-local or loopback
+func HasGlobalIPv6(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var addresses []string
+		for _, iface := range getInterfaces() {
+			if name != "" && iface.Name != name {
+				continue
+			}
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				ip, _, err := net.ParseCIDR(addr.String())
+				if err != nil || ip.To4() != nil {
+					continue
+				}
+				if ip.IsGlobalUnicast() {
+					return 0, ""
+				}
+				addresses = append(addresses, ip.String())
+			}
+		}
+		if name == "" {
+			name = "any interface"
+		}
+		return genericError("No global IPv6 address", name, addresses)
+	}
+}
+
+// IPv6DefaultRoute checks that the kernel has an IPv6 default route
+func IPv6DefaultRoute() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		routes := getDefaultRoutes()
+		for _, route := range routes {
+			if route.Destination.IP.To4() == nil {
+				return 0, ""
+			}
+		}
+		return genericError("No IPv6 default route", "::/0", routesToStrings(routes))
+	}
+}
+
+// defaultEgressEndpoint replies with the public IP address requests come from
+const defaultEgressEndpoint = "https://api.ipify.org"
+
+// httpGetBody fetches a URL and returns its body, failing on non-200 statuses
+func httpGetBody(url string) (string, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// getEgressIP asks a "what is my IP" endpoint for this host's public address.
+// The endpoint can reply with the bare address, or JSON with an "ip" field.
+func getEgressIP(endpoint string) (net.IP, error) {
+	body, err := httpGetBody(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	body = strings.TrimSpace(body)
+	var reply struct {
+		IP string `json:"ip"`
+	}
+	if json.Unmarshal([]byte(body), &reply) == nil && reply.IP != "" {
+		body = reply.IP
+	}
+	ip := net.ParseIP(body)
+	if ip == nil {
+		return nil, fmt.Errorf("not an IP address: %q", body)
+	}
+	return ip, nil
+}
+
+// EgressIP checks that this host's traffic reaches the internet from this
+// public IP, or from an address in this CIDR range, e.g. to verify that it
+// goes through the expected NAT gateway or VPN. The endpoint that reports the
+// public IP can be overridden.
+func EgressIP(address string, endpoint string) Thunk {
+	var network *net.IPNet
+	ip := net.ParseIP(address)
+	if ip == nil {
+		var err error
+		_, network, err = net.ParseCIDR(address)
+		if err != nil {
+			log.Fatal("Could not parse IP address or CIDR range: " + address)
+		}
+	}
+	if endpoint == "" {
+		endpoint = defaultEgressEndpoint
+	}
+	return func() (exitCode int, exitMessage string) {
+		egress, err := getEgressIP(endpoint)
+		if err != nil {
+			msg := "Couldn't get egress IP:"
+			msg += "\n\tEndpoint: " + endpoint
+			msg += "\n\tError: " + err.Error()
+			return 1, msg
+		}
+		if (ip != nil && ip.Equal(egress)) || (network != nil && network.Contains(egress)) {
+			return 0, ""
+		}
+		return genericError("Egress IP does not match", address, []string{egress.String()})
+	}
+}
+
+// getListeningPorts returns every port a socket is listening on, as
+// "port/protocol" (e.g. "22/tcp"), from /proc/net. TCP sockets are listening
+// in state 0A, and bound UDP sockets are in state 07.
+func getListeningPorts() (ports []string) {
+	tables := map[string]string{
+		"/proc/net/tcp": "0A", "/proc/net/tcp6": "0A",
+		"/proc/net/udp": "07", "/proc/net/udp6": "07",
+	}
+	for path, state := range tables {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		protocol := strings.TrimSuffix(filepath.Base(path), "6")
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[3] != state {
+				continue
+			}
+			address := fields[1]
+			hexPort := address[strings.LastIndex(address, ":")+1:]
+			port := fmt.Sprint(strHexToDecimal(hexPort)) + "/" + protocol
+			if !strIn(port, ports) {
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// noUnexpectedListeningPorts checks that nothing is listening on a port
+// outside the allowlist, whose entries are a port (e.g. "22"), or a port and
+// protocol (e.g. "53/udp")
+func noUnexpectedListeningPorts(allowed []string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var unexpected []string
+		for _, port := range getListeningPorts() {
+			bare := strings.SplitN(port, "/", 2)[0]
+			if !strIn(port, allowed) && !strIn(bare, allowed) {
+				unexpected = append(unexpected, port)
+			}
+		}
+		if len(unexpected) == 0 {
+			return 0, ""
+		}
+		msg := "Unexpected listening ports found"
+		return genericError(msg, strings.Join(allowed, ","), unexpected)
+	}
+}
+
+// nginxVhostServes checks that the web server on this host answers requests
+// for this virtual host, given as a name (e.g. "shop.example.com") or a URL
+// (e.g. "https://shop.example.com/health"). The request is sent to 127.0.0.1,
+// with the vhost's name in the Host header and, for HTTPS, in SNI, so it
+// tests this server rather than wherever DNS points. Any 2xx or 3xx status
+// passes, unless a status is given.
+func nginxVhostServes(vhost string, status string) Thunk {
+	if !strings.Contains(vhost, "://") {
+		vhost = "http://" + vhost + "/"
+	}
+	parsed, err := url.Parse(vhost)
+	if err != nil || parsed.Host == "" {
+		log.Fatal("Could not parse virtual host: " + vhost)
+	}
+	port := parsed.Port()
+	if port == "" && parsed.Scheme == "https" {
+		port = "443"
+	} else if port == "" {
+		port = "80"
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	client := http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", port))
+			},
+		},
+		// redirects are answers too, and may point off this host
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return func() (exitCode int, exitMessage string) {
+		resp, err := client.Get(vhost)
+		if err != nil {
+			return 1, "Virtual host is not served locally: " + vhost + "\n\t" + err.Error()
+		}
+		resp.Body.Close()
+		code := fmt.Sprint(resp.StatusCode)
+		if status == code || (status == "" && resp.StatusCode < 400) {
+			return 0, ""
+		}
+		expected := status
+		if expected == "" {
+			expected = "2xx or 3xx"
+		}
+		msg := "Virtual host returned unexpected status: " + vhost
+		return genericError(msg, expected, []string{code})
+	}
+}
+
+// readBanner connects to this host:port and returns the first line the
+// service sends, for protocols where the server speaks first (e.g. SSH, FTP,
+// IMAP)
+func readBanner(address string) (string, error) {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if line == "" && err != nil {
+		return "", fmt.Errorf("no banner received: %s", err.Error())
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// tcpBannerMatches checks that the service at this host:port greets new
+// connections with a banner matching this regex, e.g. "^\\* OK" for IMAP
+func tcpBannerMatches(address string, re *regexp.Regexp) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		banner, err := readBanner(address)
+		if err != nil {
+			return 1, "Couldn't read banner from " + address + ":\n\t" + err.Error()
+		} else if re.MatchString(banner) {
+			return 0, ""
+		}
+		msg := "Banner didn't match regexp: " + address
+		return genericError(msg, re.String(), []string{banner})
+	}
+}
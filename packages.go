@@ -4,55 +4,144 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Installed detects whether the OS is using dpkg, rpm, or pacman, queries
-// a package accoringly, and returns an error if it is not installed.
-func Installed(pkg string) Thunk {
-	// getManager returns the program to use for the query
-	getManager := func(managers []string) string {
-		for _, program := range managers {
-			cmd := exec.Command(program, "--version")
-			err := cmd.Start()
-			// as long as the command was found, return that manager
-			message := ""
-			if err != nil {
-				message = err.Error()
-			}
-			if strings.Contains(message, "not found") == false {
-				return program
+// packageManagers are the package managers that distributive knows how to
+// query, in the order they are looked for
+var packageManagers = []string{"dpkg", "rpm", "pacman"}
+
+// getManager returns the first of the given package managers that is
+// available on this system
+func getManager(managers []string) string {
+	for _, program := range managers {
+		cmd := exec.Command(program, "--version")
+		err := cmd.Start()
+		// as long as the command was found, return that manager
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		if strings.Contains(message, "not found") == false {
+			cmd.Wait()
+			return program
+		}
+	}
+	log.Fatal("No package manager found. Attempted: " + fmt.Sprint(managers))
+	return "" // never reaches this return
+}
+
+// getImageManager returns the package manager whose database is present in
+// the container image being checked, since the host's may differ
+func getImageManager() string {
+	databases := map[string]string{
+		"dpkg":   "/var/lib/dpkg/status",
+		"rpm":    "/var/lib/rpm",
+		"pacman": "/var/lib/pacman/local",
+	}
+	for _, manager := range packageManagers {
+		if _, err := os.Stat(hostPath(databases[manager])); err == nil {
+			return manager
+		}
+	}
+	log.Fatal("No package database found in image: " + imagePath)
+	return "" // never reaches this return
+}
+
+// packageRootArgs returns the options that point a package manager at the
+// container image being checked, if any
+func packageRootArgs(manager string) []string {
+	if imageRoot == "" {
+		return nil
+	}
+	switch manager {
+	case "dpkg":
+		return []string{"--admindir=" + hostPath("/var/lib/dpkg")}
+	case "rpm":
+		return []string{"--root", imageRoot}
+	case "pacman":
+		return []string{"--root", imageRoot, "--dbpath", hostPath("/var/lib/pacman")}
+	}
+	return nil
+}
+
+// installedArchitectures returns the architectures of all installed instances
+// of pkg, as reported by the given package manager
+func installedArchitectures(manager string, pkg string) (archs []string) {
+	var cmd *exec.Cmd
+	root := packageRootArgs(manager)
+	switch manager {
+	case "dpkg":
+		cmd = exec.Command("dpkg-query", append(root, "-W", "-f", "${Architecture}\n", pkg)...)
+	case "rpm":
+		cmd = exec.Command("rpm", append(root, "-q", "--qf", "%{ARCH}\n", pkg)...)
+	case "pacman":
+		cmd = exec.Command("pacman", append(root, "-Qi", pkg)...)
+	}
+	// all of these exit non-zero if the package isn't installed
+	out, err := cmd.Output()
+	if err != nil {
+		return archs
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if manager == "pacman" {
+			if !strings.HasPrefix(line, "Architecture") {
+				continue
 			}
+			line = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+		}
+		if line != "" && !strIn(line, archs) {
+			archs = append(archs, line)
 		}
-		log.Fatal("No package manager found. Attempted: " + fmt.Sprint(managers))
-		return "" // never reaches this return
 	}
+	return archs
+}
 
+// Installed detects whether the OS is using dpkg, rpm, or pacman, queries
+// a package accoringly, and returns an error if it is not installed. If arch
+// is not empty, the package must be installed for that architecture (e.g.
+// libc6 for i386 on multiarch Debian).
+func Installed(pkg string, arch string) Thunk {
 	// package managers and their options
 	managers := map[string]string{
 		"dpkg":   "-s",
 		"rpm":    "-q",
 		"pacman": "-Qs",
 	}
-	keys := make([]string, len(managers))
-	i := 0
-	for key := range managers {
-		keys[i] = key
-		i++
-	}
-
 	return func() (exitCode int, exitMessage string) {
-		name := getManager(keys)
-		options := managers[name]
-		out, _ := exec.Command(name, options, pkg).Output()
-		if strings.Contains(string(out), pkg) {
+		var name string
+		if imageRoot != "" {
+			name = getImageManager()
+		} else {
+			name = getManager(packageManagers)
+		}
+		args := append(packageRootArgs(name), managers[name], pkg)
+		out, _ := exec.Command(name, args...).Output()
+		found := strings.Contains(string(out), pkg)
+		var archs []string
+		if arch != "" || !found {
+			archs = installedArchitectures(name, pkg)
+		}
+		if found && (arch == "" || strIn(arch, archs)) {
 			return 0, ""
 		}
 		msg := "Package was not found:"
+		if found {
+			msg = "Package was not installed for architecture:"
+			msg += "\n\tArchitecture: " + arch
+		}
 		msg += "\n\tPackage name: " + pkg
 		msg += "\n\tPackage manager: " + name
+		if len(archs) > 0 {
+			msg += "\n\tInstalled architectures: " + strings.Join(archs, ", ")
+		}
 		return 1, msg
 	}
 }
@@ -91,7 +180,7 @@ func PPA(name string) Thunk {
 		return false
 	}
 	return func() (exitCode int, exitMessage string) {
-		ppas := getPPAs("/etc/apt/sources.list")
+		ppas := getPPAs(hostPath("/etc/apt/sources.list"))
 		for _, ppa := range ppas {
 			if !validURL(ppa) {
 				return 1, "PPA URL invalid: " + ppa
@@ -103,41 +192,64 @@ func PPA(name string) Thunk {
 	}
 }
 
+// YumRepo is a struct that contains the relevant fields of a single repository
+// section, as parsed from yum's configuration files
 type YumRepo struct {
 	Name, Fullname, Url string
+	Enabled, GPGCheck   bool
 }
 
-// getYumRepos returns a list of Yum Repos taken from /etc/yum.conf
-func getYumRepos(path string) (repos []YumRepo) {
-	var fullNames []string
-	var urls []string
-	commentRegex := regexp.MustCompile("^\\s*#.*")
-	for _, line := range fileToLines(path) {
-		// filter comments and convert to string
-		strLine := string(line)
-		if !(commentRegex.Match(line)) {
-			// first, attempt to replace the prefix
-			replaceName := strings.TrimPrefix(strLine, "name=")
-			replaceURL := strings.TrimPrefix(strLine, "baseurl=")
-			// if they are different, we know a prefix was replaced
-			if replaceName != strLine {
-				fullNames = append(fullNames, replaceName)
-			} else if replaceURL != strLine {
-				urls = append(urls, replaceURL)
+// yumBool interprets the boolean values that yum accepts in its configuration
+// files, returning def if the value is empty or unrecognized
+func yumBool(value string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "yes", "true", "on":
+		return true
+	case "0", "no", "false", "off":
+		return false
+	}
+	return def
+}
+
+// getYumRepos returns a list of Yum Repos taken from /etc/yum.conf and every
+// .repo file in /etc/yum.repos.d
+func getYumRepos() (repos []YumRepo) {
+	paths := []string{"/etc/yum.conf"}
+	repoFiles, err := hostGlob("/etc/yum.repos.d/*.repo")
+	if err != nil {
+		log.Fatal("Couldn't read /etc/yum.repos.d:\n\t" + err.Error())
+	}
+	paths = append(paths, repoFiles...)
+	// gpgcheck can be set globally in the [main] section of yum.conf
+	globalGPGCheck := false
+	var sections []map[string]string
+	var names []string
+	for _, path := range paths {
+		if _, err := os.Stat(hostPath(path)); os.IsNotExist(err) {
+			continue
+		}
+		ini, order, err := parseINI(fileToString(hostPath(path)))
+		if err != nil {
+			log.Fatal("Couldn't parse yum config at " + path + ":\n\t" + err.Error())
+		}
+		for _, name := range order {
+			if name == "main" {
+				globalGPGCheck = yumBool(ini[name]["gpgcheck"], globalGPGCheck)
+				continue
 			}
+			sections = append(sections, ini[name])
+			names = append(names, name)
 		}
 	}
-	// Get shortest list to zip with, so we don't get an index error
-	shortList := fullNames
-	if len(fullNames) > len(urls) {
-		shortList = urls
-	}
 	// Construct YumRepos
-	whitespaceRegex := regexp.MustCompile("\\s+")
-	for i, _ := range shortList {
-		nameSplit := whitespaceRegex.Split(fullNames[i], -1)
-		shortName := nameSplit[len(nameSplit)-1]
-		repo := YumRepo{Name: shortName, Fullname: fullNames[i], Url: urls[i]}
+	for i, section := range sections {
+		repo := YumRepo{
+			Name:     names[i],
+			Fullname: section["name"],
+			Url:      section["baseurl"],
+			Enabled:  yumBool(section["enabled"], true),
+			GPGCheck: yumBool(section["gpgcheck"], globalGPGCheck),
+		}
 		repos = append(repos, repo)
 	}
 	return repos
@@ -145,11 +257,15 @@ func getYumRepos(path string) (repos []YumRepo) {
 
 // existsRepoWithProperty is an abstraction of YumRepoExists and YumRepoURL.
 // It takes a struct field name to check, and an expected value. If the expected
-// value is found in the field of a repo, it returns 0, "" else an error message.
+// value is found in the field of an enabled repo, it returns 0, "" else an
+// error message.
 // Valid choices for prop: "Url" | "Name" | "Fullname"
 func existsRepoWithProperty(prop string, val string) (int, string) {
 	var properties []string
-	for _, repo := range getYumRepos("/etc/yum.conf") {
+	for _, repo := range getYumRepos() {
+		if !repo.Enabled {
+			continue
+		}
 		switch prop {
 		case "Url":
 			properties = append(properties, repo.Url)
@@ -182,11 +298,471 @@ func YumRepoURL(urlstr string) Thunk {
 	}
 }
 
+// repoHasFlag is an abstraction of repoEnabled and repoGPGCheckEnabled. It
+// finds the yum repo with the given (short) name and passes it to flag.
+func repoHasFlag(name string, flagName string, flag func(YumRepo) bool) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var names []string
+		for _, repo := range getYumRepos() {
+			if repo.Name == name {
+				if flag(repo) {
+					return 0, ""
+				}
+				return 1, "Yum repo does not have " + flagName + " set: " + name
+			}
+			names = append(names, repo.Name)
+		}
+		return genericError("Yum repo not found", name, names)
+	}
+}
+
+// repoEnabled checks to see that the yum repo with the given name is
+// configured and not disabled with enabled=0
+func repoEnabled(name string) Thunk {
+	return repoHasFlag(name, "enabled", func(repo YumRepo) bool {
+		return repo.Enabled
+	})
+}
+
+// repoGPGCheckEnabled checks to see that the yum repo with the given name has
+// GPG signature checking turned on, either directly or through yum.conf
+func repoGPGCheckEnabled(name string) Thunk {
+	return repoHasFlag(name, "gpgcheck", func(repo YumRepo) bool {
+		return repo.GPGCheck
+	})
+}
+
+// dpkgHeld checks to see whether a given package is on hold, as set by
+// `apt-mark hold` or `dpkg --set-selections`
+func dpkgHeld(pkg string) Thunk {
+	// getHeldPackages returns the names of all packages marked "hold" in
+	// dpkg's selections
+	getHeldPackages := func() (held []string) {
+		out, err := exec.Command("dpkg", "--get-selections").Output()
+		if err != nil {
+			log.Fatal("Error while executing `dpkg --get-selections`:\n\t" + err.Error())
+		}
+		for _, line := range stringToSlice(string(out)) {
+			if len(line) > 1 && line[1] == "hold" {
+				// multiarch packages are listed as name:arch
+				held = append(held, strings.Split(line[0], ":")[0])
+			}
+		}
+		return held
+	}
+	return func() (exitCode int, exitMessage string) {
+		held := getHeldPackages()
+		if strIn(pkg, held) {
+			return 0, ""
+		}
+		return genericError("Package is not held", pkg, held)
+	}
+}
+
+// aptPreference is a single stanza from apt's preferences files
+type aptPreference struct {
+	Packages []string
+	Pin      string
+	Priority string
+}
+
+// getAptPreferences parses /etc/apt/preferences and the files in
+// /etc/apt/preferences.d into their stanzas
+func getAptPreferences() (prefs []aptPreference) {
+	paths := []string{"/etc/apt/preferences"}
+	files, err := hostGlob("/etc/apt/preferences.d/*")
+	if err != nil {
+		log.Fatal("Couldn't read /etc/apt/preferences.d:\n\t" + err.Error())
+	}
+	// apt only reads files without an extension, or with .pref
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		if ext == "" || ext == ".pref" {
+			paths = append(paths, file)
+		}
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(hostPath(path)); os.IsNotExist(err) {
+			continue
+		}
+		stanzas := regexp.MustCompile("\n\\s*\n").Split(fileToString(hostPath(path)), -1)
+		for _, stanza := range stanzas {
+			var pref aptPreference
+			for _, line := range strings.Split(stanza, "\n") {
+				split := strings.SplitN(line, ":", 2)
+				if strings.HasPrefix(strings.TrimSpace(line), "#") || len(split) < 2 {
+					continue
+				}
+				value := strings.TrimSpace(split[1])
+				switch strings.ToLower(strings.TrimSpace(split[0])) {
+				case "package":
+					pref.Packages = strings.Fields(value)
+				case "pin":
+					pref.Pin = value
+				case "pin-priority":
+					pref.Priority = value
+				}
+			}
+			if pref.Priority != "" {
+				prefs = append(prefs, pref)
+			}
+		}
+	}
+	return prefs
+}
+
+// aptPinTargets returns the origin, archive, and codename a Pin: line selects,
+// e.g. "Debian", "stable", and "bookworm" from "release o=Debian,a=stable,
+// n=bookworm", or the host from `origin "deb.example.com"`
+func aptPinTargets(pin string) (targets []string) {
+	fields := strings.SplitN(strings.TrimSpace(pin), " ", 2)
+	if len(fields) < 2 {
+		return targets
+	}
+	value := strings.TrimSpace(fields[1])
+	switch fields[0] {
+	case "origin":
+		targets = append(targets, strings.Trim(value, "\""))
+	case "release":
+		for _, option := range strings.Split(value, ",") {
+			split := strings.SplitN(strings.TrimSpace(option), "=", 2)
+			if len(split) == 2 && strIn(split[0], []string{"o", "a", "n"}) {
+				targets = append(targets, strings.Trim(split[1], "\""))
+			}
+		}
+	}
+	return targets
+}
+
+// aptPinned checks to see whether a pin priority is set in apt's preferences
+// for a given package name, or for an origin, archive, or codename selected by
+// a Pin: line
+func aptPinned(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var pinned []string
+		for _, pref := range getAptPreferences() {
+			if strIn(name, pref.Packages) || strIn(name, aptPinTargets(pref.Pin)) {
+				return 0, ""
+			}
+			pinned = append(pinned, pref.Packages...)
+		}
+		return genericError("No apt pin found", name, pinned)
+	}
+}
+
+// rpmVerify checks that none of the files owned by the given package have
+// been modified, by checking for size, mode, and checksum changes or missing
+// files in the output of `rpm -V`
+func rpmVerify(pkg string) Thunk {
+	// getModifiedFiles returns the files that `rpm -V` reports as changed, with
+	// their verification flags
+	getModifiedFiles := func() (modified []string) {
+		out, err := exec.Command("rpm", "-V", pkg).CombinedOutput()
+		outstr := string(out)
+		// rpm -V exits non-zero whenever verification finds differences
+		if err != nil && strings.Contains(outstr, "is not installed") {
+			log.Fatal("Package is not installed, can't verify it: " + pkg)
+		} else if err != nil && outstr == "" {
+			log.Fatal("Error while executing `rpm -V " + pkg + "`:\n\t" + err.Error())
+		}
+		for _, line := range strings.Split(outstr, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			flags := fields[0]
+			path := fields[len(fields)-1]
+			// S: size, M: mode, 5: digest
+			if flags == "missing" || strings.ContainsAny(flags, "SM5") {
+				modified = append(modified, flags+" "+path)
+			}
+		}
+		return modified
+	}
+	return func() (exitCode int, exitMessage string) {
+		modified := getModifiedFiles()
+		if len(modified) == 0 {
+			return 0, ""
+		}
+		return genericError("Package has modified files", pkg, modified)
+	}
+}
+
+// gpgKey is a primary key from a keyring, as listed by gpg
+type gpgKey struct {
+	Fingerprint string
+	Expires     time.Time // zero if the key never expires
+}
+
+// parseGPGColons reads the primary keys out of gpg's --with-colons output
+func parseGPGColons(out string) (keys []gpgKey) {
+	inPrimary := false
+	var expires time.Time
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "pub":
+			inPrimary = true
+			expires = time.Time{}
+			if len(fields) > 6 && fields[6] != "" {
+				epoch, err := strconv.ParseInt(fields[6], 10, 64)
+				if err != nil {
+					log.Fatal("Couldn't parse GPG key expiry: " + fields[6])
+				}
+				expires = time.Unix(epoch, 0)
+			}
+		case "sub":
+			inPrimary = false
+		case "fpr":
+			if inPrimary && len(fields) > 9 {
+				keys = append(keys, gpgKey{Fingerprint: fields[9], Expires: expires})
+				inPrimary = false
+			}
+		}
+	}
+	return keys
+}
+
+// showGPGKeys lists the keys in the keyring at path, or in the keys given on
+// stdin if path is "-"
+func showGPGKeys(path string, stdin string) []gpgKey {
+	cmd := exec.Command("gpg", "--batch", "--with-colons", "--show-keys", path)
+	if path == "-" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		msg := "Couldn't list GPG keys with gpg:"
+		msg += "\n\tKeyring: " + path
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	return parseGPGColons(string(out))
+}
+
+// getAptKeys returns all keys trusted by apt, from trusted.gpg,
+// trusted.gpg.d, and /etc/apt/keyrings
+func getAptKeys() (keys []gpgKey) {
+	paths := []string{"/etc/apt/trusted.gpg"}
+	for _, pattern := range []string{"/etc/apt/trusted.gpg.d/*", "/etc/apt/keyrings/*"} {
+		matches, err := hostGlob(pattern)
+		if err != nil {
+			log.Fatal("Couldn't read apt keyrings:\n\t" + err.Error())
+		}
+		paths = append(paths, matches...)
+	}
+	for _, path := range paths {
+		ext := filepath.Ext(path)
+		if ext != ".gpg" && ext != ".asc" {
+			continue
+		}
+		if _, err := os.Stat(hostPath(path)); os.IsNotExist(err) {
+			continue
+		}
+		keys = append(keys, showGPGKeys(hostPath(path), "")...)
+	}
+	return keys
+}
+
+// getRPMKeys returns all keys imported into the rpm database
+func getRPMKeys() (keys []gpgKey) {
+	out, _ := exec.Command("rpm", "-q", "gpg-pubkey").Output()
+	for _, name := range strings.Fields(string(out)) {
+		if !strings.HasPrefix(name, "gpg-pubkey-") {
+			continue
+		}
+		armored, err := exec.Command("rpm", "-q", "--qf", "%{DESCRIPTION}", name).Output()
+		if err != nil {
+			log.Fatal("Couldn't read GPG key from rpm database: " + name)
+		}
+		keys = append(keys, showGPGKeys("-", string(armored))...)
+	}
+	return keys
+}
+
+// keyIDRe matches a fingerprint, or a long or short key ID, which is at least
+// eight hex digits
+var keyIDRe = regexp.MustCompile(`^[0-9A-F]{8,40}$`)
+
+// keyWithFingerprint checks whether one of the given keys has the given
+// fingerprint. Spaces and case are ignored, and long or short key IDs match
+// the end of the fingerprint.
+func keyWithFingerprint(fingerprint string, getKeys func() []gpgKey) Thunk {
+	wanted := strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+	wanted = strings.TrimPrefix(wanted, "0X")
+	if !keyIDRe.MatchString(wanted) {
+		msg := "Could not parse GPG key fingerprint or ID: " + fingerprint
+		msg += "\n\tUse at least 8 hex digits, e.g. 9DA31620334BD75D9DCB49F368818C72E52529D4"
+		log.Fatal(msg)
+	}
+	return func() (exitCode int, exitMessage string) {
+		var fingerprints []string
+		for _, key := range getKeys() {
+			if strings.HasSuffix(strings.ToUpper(key.Fingerprint), wanted) {
+				return 0, ""
+			}
+			fingerprints = append(fingerprints, key.Fingerprint)
+		}
+		return genericError("GPG key not found", wanted, fingerprints)
+	}
+}
+
+// aptKey checks to see whether apt trusts the key with the given fingerprint
+func aptKey(fingerprint string) Thunk {
+	return keyWithFingerprint(fingerprint, getAptKeys)
+}
+
+// rpmKey checks to see whether the key with the given fingerprint has been
+// imported into the rpm database
+func rpmKey(fingerprint string) Thunk {
+	return keyWithFingerprint(fingerprint, getRPMKeys)
+}
+
+// keysNotExpiring checks that none of the given keys expire within the given
+// number of days. It is an abstraction of aptKeysNotExpiring and
+// rpmKeysNotExpiring.
+func keysNotExpiring(days int, getKeys func() []gpgKey) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		deadline := time.Now().AddDate(0, 0, days)
+		var expiring []string
+		for _, key := range getKeys() {
+			if !key.Expires.IsZero() && key.Expires.Before(deadline) {
+				expiring = append(expiring, key.Fingerprint+" ("+key.Expires.Format("2006-01-02")+")")
+			}
+		}
+		if len(expiring) == 0 {
+			return 0, ""
+		}
+		msg := "GPG keys expire within " + fmt.Sprint(days) + " days"
+		return genericError(msg, "none", expiring)
+	}
+}
+
+// aptKeysNotExpiring checks that none of the keys trusted by apt expire
+// within the given number of days
+func aptKeysNotExpiring(days int) Thunk {
+	return keysNotExpiring(days, getAptKeys)
+}
+
+// rpmKeysNotExpiring checks that none of the keys in the rpm database expire
+// within the given number of days
+func rpmKeysNotExpiring(days int) Thunk {
+	return keysNotExpiring(days, getRPMKeys)
+}
+
+// getAptConfig returns apt's effective configuration, as reported by
+// `apt-config dump`. List items (keys ending in "::") have one value each.
+func getAptConfig() map[string][]string {
+	out, err := exec.Command("apt-config", "dump").Output()
+	if err != nil {
+		log.Fatal("Error while executing `apt-config dump`:\n\t" + err.Error())
+	}
+	config := make(map[string][]string)
+	// lines look like: APT::Periodic::Unattended-Upgrade "1";
+	lineRegex := regexp.MustCompile("^([^\\s]+)\\s+\"(.*)\";$")
+	for _, line := range strings.Split(string(out), "\n") {
+		if match := lineRegex.FindStringSubmatch(line); match != nil {
+			config[match[1]] = append(config[match[1]], match[2])
+		}
+	}
+	return config
+}
+
+// unattendedUpgradesEnabled checks that the unattended-upgrades package is
+// installed, and that apt is configured to run it periodically
+func unattendedUpgradesEnabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if exec.Command("dpkg", "-s", "unattended-upgrades").Run() != nil {
+			return 1, "Package was not found:\n\tPackage name: unattended-upgrades"
+		}
+		values := getAptConfig()["APT::Periodic::Unattended-Upgrade"]
+		if len(values) > 0 && values[0] != "0" && values[0] != "" {
+			return 0, ""
+		}
+		msg := "Unattended upgrades are not enabled in APT::Periodic"
+		return genericError(msg, "APT::Periodic::Unattended-Upgrade \"1\"", values)
+	}
+}
+
+// unattendedUpgradesList is an abstraction of unattendedUpgradesOrigin and
+// unattendedUpgradesBlacklist. It checks that the value is in one of the given
+// list settings of the unattended-upgrades configuration.
+func unattendedUpgradesList(value string, description string, keys ...string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		config := getAptConfig()
+		var values []string
+		for _, key := range keys {
+			values = append(values, config["Unattended-Upgrade::"+key+"::"]...)
+		}
+		if strIn(value, values) {
+			return 0, ""
+		}
+		msg := "Unattended-upgrades " + description + " not found"
+		return genericError(msg, value, values)
+	}
+}
+
+// unattendedUpgradesOrigin checks that unattended-upgrades is configured to
+// install updates from the given origin, in either Origins-Pattern (e.g.
+// "origin=Debian,codename=${distro_codename},label=Debian-Security") or
+// Allowed-Origins (e.g. "${distro_id}:${distro_codename}-security")
+func unattendedUpgradesOrigin(origin string) Thunk {
+	return unattendedUpgradesList(origin, "origin", "Origins-Pattern", "Allowed-Origins")
+}
+
+// unattendedUpgradesBlacklist checks that unattended-upgrades is configured to
+// never upgrade the given package
+func unattendedUpgradesBlacklist(pkg string) Thunk {
+	return unattendedUpgradesList(pkg, "blacklisted package", "Package-Blacklist")
+}
+
+// dnfAutomaticEnabled checks that dnf-automatic is installed, that one of its
+// timers is enabled, and that it is configured to apply the updates it finds
+func dnfAutomaticEnabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		args := append(packageRootArgs("rpm"), "-q", "dnf-automatic")
+		if exec.Command("rpm", args...).Run() != nil {
+			return 1, "Package was not found:\n\tPackage name: dnf-automatic"
+		}
+		timers := []string{"dnf-automatic.timer", "dnf-automatic-install.timer"}
+		enabled := false
+		for _, timer := range timers {
+			args := []string{"is-enabled", timer}
+			if imageRoot != "" {
+				// reads the unit files' symlinks, without a running systemd
+				args = append([]string{"--root", imageRoot}, args...)
+			}
+			out, _ := exec.Command("systemctl", args...).Output()
+			if strings.TrimSpace(string(out)) == "enabled" {
+				enabled = true
+				// this timer applies updates regardless of the config file
+				if timer == "dnf-automatic-install.timer" {
+					return 0, ""
+				}
+			}
+		}
+		if !enabled {
+			return genericError("No dnf-automatic timer is enabled", "enabled", timers)
+		}
+		path := "/etc/dnf/automatic.conf"
+		ini, _, err := parseINI(fileToString(hostPath(path)))
+		if err != nil {
+			log.Fatal("Couldn't parse " + path + ":\n\t" + err.Error())
+		}
+		apply := ini["commands"]["apply_updates"]
+		if yumBool(apply, false) {
+			return 0, ""
+		}
+		msg := "dnf-automatic is not configured to apply updates in " + path
+		return genericError(msg, "apply_updates = yes", []string{apply})
+	}
+}
+
 // pacmanIgnore checks to see whether a given package is in /etc/pacman.conf's
 // IgnorePkg setting
 func pacmanIgnore(pkg string) Thunk {
 	return func() (exitCode int, exitMessage string) {
-		data := fileToString("/etc/pacman.conf")
+		data := fileToString(hostPath("/etc/pacman.conf"))
 		re := regexp.MustCompile("[^#]IgnorePkg\\s+=\\s+.+")
 		find := re.FindString(data)
 		var packages []string
@@ -203,3 +779,167 @@ func pacmanIgnore(pkg string) Thunk {
 		return genericError(msg, pkg, packages)
 	}
 }
+
+// packageCommandOutput runs a package tool and returns its output, failing if
+// it couldn't run at all. Non-zero exits with output are tolerated, since
+// several of these tools use them to report findings.
+func packageCommandOutput(name string, args ...string) string {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil && strings.TrimSpace(string(out)) == "" {
+		msg := "Error while executing `" + name + " " + strings.Join(args, " ") + "`:"
+		msg += "\n\t" + err.Error()
+		log.Fatal(msg)
+	}
+	return string(out)
+}
+
+// getOrphanedPackages returns installed packages that were pulled in as
+// dependencies but are no longer required by anything
+func getOrphanedPackages() (orphans []string) {
+	switch getManager(packageManagers) {
+	case "dpkg":
+		// Remv libfoo1 [1.2-3]
+		out := packageCommandOutput("apt-get", "--dry-run", "autoremove")
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 1 && fields[0] == "Remv" {
+				orphans = append(orphans, fields[1])
+			}
+		}
+	case "rpm":
+		out := packageCommandOutput("dnf", "repoquery", "--unneeded", "--quiet")
+		orphans = strings.Fields(out)
+	case "pacman":
+		// pacman -Qdtq exits 1 when there are no orphans
+		out, _ := exec.Command("pacman", "-Qdtq").Output()
+		orphans = strings.Fields(string(out))
+	}
+	return orphans
+}
+
+// orphanedPackagesBelow checks that fewer than max packages are orphaned
+// dependencies that could be autoremoved
+func orphanedPackagesBelow(max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		orphans := getOrphanedPackages()
+		if len(orphans) < max {
+			return 0, ""
+		}
+		msg := "Too many orphaned packages (" + fmt.Sprint(len(orphans)) + ")"
+		return genericError(msg, fmt.Sprint(max), orphans)
+	}
+}
+
+// rpmInstallOnlyProvides are dnf's default installonlypkgs: the capabilities
+// of packages that are installed side by side rather than upgraded
+var rpmInstallOnlyProvides = []string{
+	"kernel", "kernel-PAE", "installonlypkg(kernel)",
+	"installonlypkg(kernel-module)", "installonlypkg(vm)", "multiversion(kernel)",
+}
+
+// getRPMInstallOnly returns the names of installed packages that are allowed
+// several versions: those providing dnf's installonlypkgs, including any added
+// in dnf.conf or yum.conf, and the gpg-pubkey entries for imported keys
+func getRPMInstallOnly() map[string]bool {
+	provides := rpmInstallOnlyProvides
+	for _, path := range []string{"/etc/dnf/dnf.conf", "/etc/yum.conf"} {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		ini, _, err := parseINI(fileToString(path))
+		if err != nil {
+			log.Fatal("Couldn't parse " + path + ":\n\t" + err.Error())
+		}
+		value := strings.Replace(ini["main"]["installonlypkgs"], ",", " ", -1)
+		provides = append(provides, strings.Fields(value)...)
+	}
+	names := map[string]bool{"gpg-pubkey": true}
+	args := append([]string{"-q", "--queryformat", "%{NAME}\n", "--whatprovides"}, provides...)
+	// lines like "no package provides kernel-PAE" are skipped
+	for _, line := range strings.Split(packageCommandOutput("rpm", args...), "\n") {
+		if name := strings.TrimSpace(line); name != "" && !strings.Contains(name, " ") {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// rpmNoDuplicates checks that no package is installed in more than one version
+// for the same architecture, which usually means an interrupted transaction
+func rpmNoDuplicates() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		out := packageCommandOutput("rpm", "-qa", "--queryformat", "%{NAME} %{ARCH}\n")
+		installOnly := getRPMInstallOnly()
+		seen := make(map[string]bool)
+		var duplicates []string
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 || installOnly[fields[0]] {
+				continue
+			}
+			pkg := fields[0] + "." + fields[1]
+			if seen[pkg] && !strIn(pkg, duplicates) {
+				duplicates = append(duplicates, pkg)
+			}
+			seen[pkg] = true
+		}
+		if len(duplicates) == 0 {
+			return 0, ""
+		}
+		return genericError("Duplicate packages installed", "none", duplicates)
+	}
+}
+
+// getUnverifiedPackages returns the packages with files that fail
+// verification, using `rpm -Va` or `debsums`. Config files are ignored, since
+// they're expected to change.
+func getUnverifiedPackages() (packages []string) {
+	switch getManager(packageManagers) {
+	case "dpkg":
+		// debsums: changed file /usr/bin/foo (from foo package)
+		out := packageCommandOutput("debsums", "-s")
+		re := regexp.MustCompile(`\(from (\S+) package\)`)
+		for _, match := range re.FindAllStringSubmatch(out, -1) {
+			if !strIn(match[1], packages) {
+				packages = append(packages, match[1])
+			}
+		}
+	case "rpm":
+		var files []string
+		for _, line := range strings.Split(packageCommandOutput("rpm", "-Va"), "\n") {
+			fields := strings.Fields(line)
+			// S.5....T.  c /etc/foo.conf
+			if len(fields) < 2 || (len(fields) > 2 && fields[1] == "c") {
+				continue
+			}
+			if fields[0] == "missing" || strings.ContainsAny(fields[0], "SM5") {
+				files = append(files, fields[len(fields)-1])
+			}
+		}
+		if len(files) == 0 {
+			return packages
+		}
+		args := append([]string{"-qf", "--queryformat", "%{NAME}\n"}, files...)
+		for _, pkg := range strings.Fields(packageCommandOutput("rpm", args...)) {
+			if !strIn(pkg, packages) {
+				packages = append(packages, pkg)
+			}
+		}
+	default:
+		log.Fatal("Package verification requires rpm or dpkg with debsums")
+	}
+	return packages
+}
+
+// verifyFailuresBelow checks that fewer than max packages have modified or
+// missing files, across every installed package
+func verifyFailuresBelow(max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		failed := getUnverifiedPackages()
+		if len(failed) < max {
+			return 0, ""
+		}
+		msg := "Too many packages fail verification (" + fmt.Sprint(len(failed)) + ")"
+		return genericError(msg, fmt.Sprint(max), failed)
+	}
+}
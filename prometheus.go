@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promSample is a single sample from the Prometheus text exposition format
+type promSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+var (
+	promNameRegex  = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*")
+	promLabelRegex = regexp.MustCompile("^\\s*([a-zA-Z_][a-zA-Z0-9_]*)\\s*=\\s*\"((?:[^\"\\\\]|\\\\.)*)\"\\s*,?")
+)
+
+// parsePromSeries parses a metric name with optional labels, like
+// `http_requests_total{code="200"}`, and returns the rest of the line
+func parsePromSeries(str string) (sample promSample, rest string, err error) {
+	sample.Name = promNameRegex.FindString(str)
+	if sample.Name == "" {
+		return sample, str, fmt.Errorf("invalid metric name: %s", str)
+	}
+	rest = str[len(sample.Name):]
+	sample.Labels = make(map[string]string)
+	if !strings.HasPrefix(rest, "{") {
+		return sample, rest, nil
+	}
+	rest = rest[1:]
+	for !strings.HasPrefix(strings.TrimSpace(rest), "}") {
+		match := promLabelRegex.FindStringSubmatch(rest)
+		if match == nil {
+			return sample, rest, fmt.Errorf("invalid labels: %s", str)
+		}
+		sample.Labels[match[1]] = match[2]
+		rest = rest[len(match[0]):]
+	}
+	rest = strings.TrimSpace(rest)[1:]
+	return sample, rest, nil
+}
+
+// parsePromText parses metrics in the Prometheus text exposition format,
+// returning an error for the first malformed line
+func parsePromText(text string) (samples []promSample, err error) {
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample, rest, err := parsePromSeries(line)
+		if err != nil {
+			return samples, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+		// the value may be followed by a timestamp
+		fields := strings.Fields(rest)
+		if len(fields) < 1 || len(fields) > 2 {
+			return samples, fmt.Errorf("line %d: expected value: %s", i+1, line)
+		}
+		sample.Value, err = strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return samples, fmt.Errorf("line %d: invalid value: %s", i+1, line)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// scrapeExporter fetches and parses the metrics from an exporter's endpoint,
+// and returns an error if the endpoint can't be reached or isn't valid
+func scrapeExporter(url string) ([]promSample, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parsePromText(string(body))
+}
+
+// prometheusExporter checks that the exporter at url responds with metrics in
+// valid Prometheus text exposition format
+func prometheusExporter(url string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if _, err := scrapeExporter(url); err != nil {
+			return 1, "Exporter is not healthy: " + url + "\n\tError: " + err.Error()
+		}
+		return 0, ""
+	}
+}
+
+// prometheusMetric checks that the exporter at url exposes the given metric,
+// optionally with labels (e.g. `up{job="node"}`), and that every matching
+// sample has a value between min and max, inclusive
+func prometheusMetric(url string, metric string, min float64, max float64) Thunk {
+	selector, rest, err := parsePromSeries(metric)
+	if err != nil || rest != "" {
+		log.Fatal("Could not parse metric: " + metric)
+	}
+	// matches reports whether the sample has the selector's name and labels
+	matches := func(sample promSample) bool {
+		if sample.Name != selector.Name {
+			return false
+		}
+		for key, value := range selector.Labels {
+			if sample.Labels[key] != value {
+				return false
+			}
+		}
+		return true
+	}
+	return func() (exitCode int, exitMessage string) {
+		samples, err := scrapeExporter(url)
+		if err != nil {
+			return 1, "Exporter is not healthy: " + url + "\n\tError: " + err.Error()
+		}
+		var values []string
+		inRange := true
+		for _, sample := range samples {
+			if matches(sample) {
+				values = append(values, fmt.Sprint(sample.Value))
+				if sample.Value < min || sample.Value > max || math.IsNaN(sample.Value) {
+					inRange = false
+				}
+			}
+		}
+		if len(values) == 0 {
+			return 1, "Metric not found: " + metric + "\n\tExporter: " + url
+		} else if inRange {
+			return 0, ""
+		}
+		msg := "Metric " + metric + " out of range"
+		return genericError(msg, fmt.Sprint(min)+" to "+fmt.Sprint(max), values)
+	}
+}
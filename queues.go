@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rabbitmqQueueDepthBelow checks that this queue, as reported by the RabbitMQ
+// management API at this URL (e.g. "http://monitor@mq1:15672"), holds fewer
+// than this many messages, counting both ready and unacknowledged ones. The
+// URL's credentials are used for basic auth.
+func rabbitmqQueueDepthBelow(apiURL string, queue string, max int, vhost string) Thunk {
+	if parsed, err := url.Parse(apiURL); err != nil || parsed.Host == "" {
+		log.Fatal("Could not parse RabbitMQ management API URL: " + apiURL)
+	}
+	if vhost == "" {
+		vhost = "/"
+	}
+	// the default vhost, "/", is escaped to %2F
+	queueURL := strings.TrimSuffix(apiURL, "/") + "/api/queues/" +
+		url.PathEscape(vhost) + "/" + url.PathEscape(queue)
+	return func() (exitCode int, exitMessage string) {
+		body, err := httpGetBody(queueURL)
+		if err != nil {
+			msg := "Couldn't get RabbitMQ queue " + queue + " from "
+			return 1, msg + redactURL(apiURL) + ":\n\t" + err.Error()
+		}
+		var info struct {
+			Messages *int `json:"messages"`
+		}
+		if err := json.Unmarshal([]byte(body), &info); err != nil || info.Messages == nil {
+			msg := "Couldn't parse RabbitMQ queue " + queue + " from "
+			return 1, msg + redactURL(apiURL)
+		}
+		if *info.Messages < max {
+			return 0, ""
+		}
+		msg := "RabbitMQ queue depth exceeds maximum: " + queue
+		return genericError(msg, fmt.Sprint(max), []string{fmt.Sprint(*info.Messages)})
+	}
+}
+
+// kafkaAPIVersions sends a Kafka ApiVersions request (v0) to this broker,
+// which every broker since 0.10 answers without authentication, and returns
+// the error code from its response
+func kafkaAPIVersions(broker string) (int16, error) {
+	conn, err := net.DialTimeout("tcp", broker, 10*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	const apiVersionsKey, correlationID = 18, 1
+	clientID := "distributive"
+	// request header: api_key, api_version, correlation_id, client_id
+	request := make([]byte, 4+2+2+4+2+len(clientID))
+	binary.BigEndian.PutUint32(request[0:], uint32(len(request)-4))
+	binary.BigEndian.PutUint16(request[4:], apiVersionsKey)
+	binary.BigEndian.PutUint16(request[6:], 0)
+	binary.BigEndian.PutUint32(request[8:], correlationID)
+	binary.BigEndian.PutUint16(request[12:], uint16(len(clientID)))
+	copy(request[14:], clientID)
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	// response: size, correlation_id, error_code, then the API versions
+	response := make([]byte, 4+4+2)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint32(response[4:]) != correlationID {
+		return 0, fmt.Errorf("not a Kafka broker")
+	}
+	return int16(binary.BigEndian.Uint16(response[8:])), nil
+}
+
+// kafkaBrokerReachable checks that the Kafka broker at this host:port accepts
+// a connection and answers an ApiVersions request
+func kafkaBrokerReachable(broker string) Thunk {
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		log.Fatal("Kafka broker should be given as host:port: " + broker)
+	}
+	return func() (exitCode int, exitMessage string) {
+		code, err := kafkaAPIVersions(broker)
+		if err != nil {
+			return 1, "Kafka broker is not reachable: " + broker + "\n\t" + err.Error()
+		} else if code != 0 {
+			msg := "Kafka broker returned an error: " + broker
+			return genericError(msg, "0", []string{fmt.Sprint(code)})
+		}
+		return 0, ""
+	}
+}
+
+// getKafkaConsumerLag returns the lag of each partition this consumer group
+// reads, keyed by "topic/partition", as reported by kafka-consumer-groups
+func getKafkaConsumerLag(bootstrap string, group string) (map[string]int64, error) {
+	var tool string
+	for _, name := range []string{"kafka-consumer-groups.sh", "kafka-consumer-groups"} {
+		if path, err := exec.LookPath(name); err == nil {
+			tool = path
+			break
+		}
+	}
+	if tool == "" {
+		log.Fatal("Kafka consumer lag checks require kafka-consumer-groups.sh")
+	}
+	cmd := exec.Command(tool, "--bootstrap-server", bootstrap, "--describe",
+		"--group", group)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	// the table's header names its columns, which vary between versions
+	lags := make(map[string]int64)
+	columns := map[string]int{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if strIn("LAG", fields) && strIn("TOPIC", fields) {
+			for i, field := range fields {
+				columns[field] = i
+			}
+			continue
+		}
+		if len(columns) == 0 || len(fields) <= columns["LAG"] {
+			continue
+		}
+		// partitions with no committed offset have a lag of "-"
+		lag, err := strconv.ParseInt(fields[columns["LAG"]], 10, 64)
+		if err != nil {
+			continue
+		}
+		partition := fields[columns["TOPIC"]] + "/" + fields[columns["PARTITION"]]
+		lags[partition] = lag
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	return lags, nil
+}
+
+// kafkaConsumerLagBelow checks that this consumer group, on the Kafka cluster
+// at this bootstrap server, is fewer than this many messages behind, summed
+// across all of the partitions it reads
+func kafkaConsumerLagBelow(bootstrap string, group string, max int64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		lags, err := getKafkaConsumerLag(bootstrap, group)
+		if err != nil {
+			msg := "Couldn't describe Kafka consumer group " + group + ":\n\t"
+			return 1, msg + err.Error()
+		}
+		var total int64
+		var behind []string
+		for partition, lag := range lags {
+			total += lag
+			if lag > 0 {
+				behind = append(behind, partition+": "+fmt.Sprint(lag))
+			}
+		}
+		if total < max {
+			return 0, ""
+		}
+		sort.Strings(behind)
+		msg := "Kafka consumer group lag exceeds maximum: " + group
+		actual := append([]string{"total: " + fmt.Sprint(total)}, behind...)
+		return genericError(msg, fmt.Sprint(max), actual)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// throttledFlags are the lowest bits of the Raspberry Pi firmware's throttled state.
+// The same conditions, shifted left by 16 bits, record whether each has
+// happened since boot.
+var throttledFlags = []string{
+	"under-voltage", "ARM frequency capped", "throttled", "soft temperature limit",
+}
+
+// throttledSysfs is where newer kernels expose the firmware's throttled state
+const throttledSysfs = "/sys/devices/platform/soc/soc:firmware/get_throttled"
+
+// getThrottled returns the Raspberry Pi firmware's throttled state, from
+// sysfs if available, or else `vcgencmd get_throttled` ("throttled=0x50005")
+func getThrottled() uint64 {
+	str := ""
+	if data, err := ioutil.ReadFile(throttledSysfs); err == nil {
+		str = strings.TrimSpace(string(data))
+	} else {
+		out, err := exec.Command("vcgencmd", "get_throttled").CombinedOutput()
+		if err != nil {
+			msg := "Couldn't read throttled state from " + throttledSysfs
+			msg += " or `vcgencmd get_throttled`:\n\t" + strings.TrimSpace(string(out))
+			log.Fatal(msg)
+		}
+		str = strings.TrimPrefix(strings.TrimSpace(string(out)), "throttled=")
+	}
+	flags, err := strconv.ParseUint(strings.TrimPrefix(str, "0x"), 16, 64)
+	if err != nil {
+		log.Fatal("Couldn't parse throttled state: " + str)
+	}
+	return flags
+}
+
+// piNotThrottled checks that a Raspberry Pi is not currently under-voltage,
+// frequency capped, or throttled. If sinceBoot is true, none of these may
+// have happened since boot either.
+func piNotThrottled(sinceBoot bool) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		flags := getThrottled()
+		var problems []string
+		for i, name := range throttledFlags {
+			bit := uint64(1) << uint(i)
+			if flags&bit != 0 {
+				problems = append(problems, name)
+			} else if sinceBoot && flags&(bit<<16) != 0 {
+				problems = append(problems, name+" (since boot)")
+			}
+		}
+		if len(problems) == 0 {
+			return 0, ""
+		}
+		msg := "Raspberry Pi throttling detected (0x" + strconv.FormatUint(flags, 16) + ")"
+		return genericError(msg, "none", problems)
+	}
+}
+
+// sdCardHealthy checks an SD card or eMMC (e.g. "mmcblk0", the default) for
+// signs of wear: being read-only, the eMMC wear and pre-EOL indicators where
+// the card reports them, and kernel I/O errors since boot
+func sdCardHealthy(device string) Thunk {
+	if device == "" {
+		device = "mmcblk0"
+	}
+	device = strings.TrimPrefix(device, "/dev/")
+	dir := filepath.Join("/sys/block", device)
+	return func() (exitCode int, exitMessage string) {
+		ro, err := ioutil.ReadFile(filepath.Join(dir, "ro"))
+		if err != nil {
+			return 1, "No such block device: " + device
+		}
+		var problems []string
+		if strings.TrimSpace(string(ro)) == "1" {
+			problems = append(problems, "device is read-only")
+		}
+		// eMMC only: 0x01 is normal, 0x02 is 80% of reserved blocks used, and
+		// 0x03 is urgent
+		eol := readKernelFlag(filepath.Join(dir, "device", "pre_eol_info"))
+		if eol != "" && eol != "0x01" {
+			problems = append(problems, "pre-EOL info: "+eol)
+		}
+		// eMMC only: estimated life used, in 10% steps, where 0x0b is exceeded
+		for _, lifeTime := range strings.Fields(readKernelFlag(filepath.Join(dir, "device", "life_time"))) {
+			used, err := strconv.ParseUint(strings.TrimPrefix(lifeTime, "0x"), 16, 8)
+			if err == nil && used >= 0x0a {
+				problems = append(problems, "life time used: "+lifeTime)
+			}
+		}
+		for _, line := range getKernelMessages(getUptime()) {
+			if strings.Contains(line, device) && strings.Contains(strings.ToLower(line), "error") {
+				problems = append(problems, line)
+			}
+		}
+		if len(problems) == 0 {
+			return 0, ""
+		}
+		return genericError("SD card shows signs of failure", device, problems)
+	}
+}
+
+// piGPUMemory checks that the GPU's share of a Raspberry Pi's memory is this
+// many megabytes, as set by gpu_mem in config.txt
+func piGPUMemory(megabytes string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		out, err := exec.Command("vcgencmd", "get_mem", "gpu").CombinedOutput()
+		if err != nil {
+			return 1, "Error while executing `vcgencmd get_mem gpu`:\n\t" + strings.TrimSpace(string(out))
+		}
+		// output looks like "gpu=76M"
+		actual := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(string(out)), "gpu="), "M")
+		if actual == strings.TrimSuffix(strings.ToUpper(megabytes), "M") {
+			return 0, ""
+		}
+		return genericError("GPU memory split does not match", megabytes, []string{actual + "M"})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the multipliers for the suffixes parseSize accepts
+var sizeUnits = map[string]uint64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+	"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+}
+
+// parseSize parses a human readable size like "512MB" or "2G" into bytes.
+// Units are powers of 1024.
+func parseSize(str string) uint64 {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(str)
+	}
+	num, err := strconv.ParseFloat(str[:i], 64)
+	multiplier, ok := sizeUnits[strings.TrimSpace(str[i:])]
+	if err != nil || !ok {
+		log.Fatal("Could not parse size: " + str + "\n\tExamples: 512MB, 2G, 1024")
+	}
+	return uint64(num * float64(multiplier))
+}
+
+// formatSize formats a number of bytes for humans, e.g. 2.5GB
+func formatSize(bytes uint64) string {
+	for _, unit := range []string{"TB", "GB", "MB", "KB"} {
+		if bytes >= sizeUnits[unit] {
+			return fmt.Sprintf("%.1f%s", float64(bytes)/float64(sizeUnits[unit]), unit)
+		}
+	}
+	return fmt.Sprint(bytes) + "B"
+}
+
+// getMeminfo returns the values from /proc/meminfo, in bytes
+func getMeminfo() map[string]uint64 {
+	meminfo := make(map[string]uint64)
+	for _, line := range stringToSlice(fileToString("/proc/meminfo")) {
+		if len(line) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(line[1], 10, 64)
+		if err != nil {
+			log.Fatal("Couldn't parse /proc/meminfo: " + strings.Join(line, " "))
+		}
+		// values are in kB, except for counts like HugePages_Total
+		if len(line) > 2 && line[2] == "kB" {
+			value *= 1024
+		}
+		meminfo[strings.TrimSuffix(line[0], ":")] = value
+	}
+	return meminfo
+}
+
+// memoryThreshold is an absolute or relative amount of memory, as given to
+// memoryFreeAbove and memoryUsedBelow, e.g. "2GB" or "10%"
+type memoryThreshold struct {
+	bytes   uint64
+	percent float64
+	given   string
+}
+
+// parseMemoryThreshold parses an amount of memory that is either a size, or a
+// percentage of total memory
+func parseMemoryThreshold(str string) (threshold memoryThreshold) {
+	threshold.given = str
+	if strings.HasSuffix(str, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(str, "%"), 64)
+		if err != nil {
+			log.Fatal("Could not parse percentage: " + str)
+		}
+		threshold.percent = percent
+		return threshold
+	}
+	threshold.bytes = parseSize(str)
+	return threshold
+}
+
+// inBytes returns the threshold in bytes, given the total amount of memory
+func (threshold memoryThreshold) inBytes(total uint64) uint64 {
+	if threshold.bytes == 0 && threshold.percent != 0 {
+		return uint64(float64(total) * threshold.percent / 100)
+	}
+	return threshold.bytes
+}
+
+// memoryFreeAbove checks that the memory available for new processes
+// (MemAvailable in /proc/meminfo) is above the given size or percentage
+func memoryFreeAbove(min string) Thunk {
+	threshold := parseMemoryThreshold(min)
+	return func() (exitCode int, exitMessage string) {
+		meminfo := getMeminfo()
+		free := meminfo["MemAvailable"]
+		if free > threshold.inBytes(meminfo["MemTotal"]) {
+			return 0, ""
+		}
+		msg := "Available memory is below minimum"
+		return genericError(msg, threshold.given, []string{formatSize(free)})
+	}
+}
+
+// memoryUsedBelow checks that the memory in use (total memory less
+// MemAvailable) is below the given size or percentage
+func memoryUsedBelow(max string) Thunk {
+	threshold := parseMemoryThreshold(max)
+	return func() (exitCode int, exitMessage string) {
+		meminfo := getMeminfo()
+		used := meminfo["MemTotal"] - meminfo["MemAvailable"]
+		if used < threshold.inBytes(meminfo["MemTotal"]) {
+			return 0, ""
+		}
+		msg := "Used memory exceeds maximum"
+		return genericError(msg, threshold.given, []string{formatSize(used)})
+	}
+}
+
+// loadAverageBelow checks that the load average over the given period of 1,
+// 5, or 15 minutes is below max, as read from /proc/loadavg
+func loadAverageBelow(period string, max float64) Thunk {
+	columns := map[string]int{"1": 0, "5": 1, "15": 2}
+	column, ok := columns[period]
+	if !ok {
+		log.Fatal("Invalid load average period: " + period + "\n\tValid: 1, 5, 15")
+	}
+	return func() (exitCode int, exitMessage string) {
+		fields := strings.Fields(fileToString("/proc/loadavg"))
+		if len(fields) < 3 {
+			log.Fatal("Couldn't parse /proc/loadavg")
+		}
+		load, err := strconv.ParseFloat(fields[column], 64)
+		if err != nil {
+			log.Fatal("Couldn't parse /proc/loadavg:\n\t" + err.Error())
+		}
+		if load < max {
+			return 0, ""
+		}
+		msg := period + " minute load average exceeds maximum"
+		return genericError(msg, fmt.Sprint(max), []string{fields[column]})
+	}
+}
+
+// cpuCountAtLeast checks that this host has at least the given number of
+// logical CPUs
+func cpuCountAtLeast(min int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		count := runtime.NumCPU()
+		if count >= min {
+			return 0, ""
+		}
+		msg := "Fewer CPUs than required"
+		return genericError(msg, fmt.Sprint(min), []string{fmt.Sprint(count)})
+	}
+}
+
+// entropyAbove checks that the kernel's entropy pool has more than this many
+// bits available. Since Linux 5.18 the pool always reports 256 once it has
+// been initialized, so this mostly catches hosts whose pool never filled.
+func entropyAbove(min int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		path := "/proc/sys/kernel/random/entropy_avail"
+		str := strings.TrimSpace(fileToString(path))
+		avail, err := strconv.ParseInt(str, 10, 32)
+		if err != nil {
+			log.Fatal("Couldn't parse " + path + ": " + str)
+		}
+		if int(avail) > min {
+			return 0, ""
+		}
+		msg := "Available entropy is below minimum"
+		return genericError(msg, fmt.Sprint(min), []string{str})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Role maps a kind of host to the checklists that should be run on it. A host
+// has a role when all of the role's Detect checks pass, e.g. a "web" role
+// might be detected by nginx being installed and running.
+type Role struct {
+	Role, Notes string
+	Detect      []Check  // checks that must all pass for the role to apply
+	Checklists  []string // paths to the checklists for this role
+}
+
+// rolesPath is the path to a JSON file listing Roles, as specified by the -r
+// flag
+var rolesPath string
+
+// getRoles loads a JSON file located at path, and Unmarshals it into a slice
+// of Roles
+func getRoles(path string) (roles []Role) {
+	err := json.Unmarshal(fileToBytes(path), &roles)
+	if err != nil {
+		log.Fatal("Could not parse JSON at " + path + ":\n\t" + err.Error())
+	}
+	return roles
+}
+
+// hasRole runs each of the role's detection checks, and reports whether they
+// all passed
+func hasRole(role Role) bool {
+	for _, chk := range role.Detect {
+		if code, _ := getThunk(chk)(); code != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// detectChecklists returns the paths of the checklists for every role in the
+// roles file at path that this host has, without duplicates
+func detectChecklists(path string) (paths []string) {
+	var detected []string
+	for _, role := range getRoles(path) {
+		if !hasRole(role) {
+			continue
+		}
+		detected = append(detected, role.Role)
+		for _, chklstPath := range role.Checklists {
+			if !strIn(chklstPath, paths) {
+				paths = append(paths, chklstPath)
+			}
+		}
+	}
+	verbosityPrint("Detected roles: "+strings.Join(detected, ", "), maxVerbosity)
+	return paths
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// runLogPath is the path to a JSON Lines file that every check result is
+// appended to, as specified by the -l flag
+var runLogPath string
+
+// runLogMaxSize is the size (e.g. "10MB") at which the run log is rotated to
+// runLogPath + ".1", as specified by the -log-max-size flag. "0" disables
+// rotation.
+var runLogMaxSize string
+
+// runLogEntry is one line of the run log, recording the result of one check
+type runLogEntry struct {
+	Time        string   `json:"time"`
+	Host        string   `json:"host"`
+	Checklist   string   `json:"checklist"`
+	Path        string   `json:"path"`
+	Name        string   `json:"name"`
+	Check       string   `json:"check"`
+	Parameters  []string `json:"parameters"`
+	Code        int      `json:"code"`
+	Message     string   `json:"message,omitempty"`
+	Maintenance bool     `json:"maintenance"`
+	Weight      float64  `json:"weight"`
+	Score       float64  `json:"score"` // of the whole checklist run
+	Grade       string   `json:"grade"`
+}
+
+// redactParameters hides the passwords in any URLs among these parameters,
+// like "redis://:secret@cache1", so they aren't written to the run log
+func redactParameters(parameters []string) (redacted []string) {
+	for _, parameter := range parameters {
+		if strings.Contains(parameter, "@") {
+			parameter = redactURL(parameter)
+		}
+		redacted = append(redacted, parameter)
+	}
+	return redacted
+}
+
+// rotateRunLog moves the run log aside if it has reached its maximum size,
+// replacing the previous rotated log
+func rotateRunLog() {
+	maxSize := parseSize(runLogMaxSize)
+	info, err := os.Stat(runLogPath)
+	if maxSize == 0 || err != nil || uint64(info.Size()) < maxSize {
+		return
+	}
+	if err := os.Rename(runLogPath, runLogPath+".1"); err != nil {
+		log.Fatal("Couldn't rotate run log " + runLogPath + ":\n\t" + err.Error())
+	}
+}
+
+// appendRunLog appends the results of this checklist, which was loaded from
+// path, to the run log, one JSON object per check
+func appendRunLog(path string, chklst Checklist, maintenance bool) {
+	if runLogPath == "" {
+		return
+	}
+	rotateRunLog()
+	host, _ := os.Hostname()
+	now := time.Now().Format(time.RFC3339)
+	var lines []byte
+	for i, chk := range chklst.Checklist {
+		entry := runLogEntry{
+			Time:        now,
+			Host:        host,
+			Checklist:   chklst.Name,
+			Path:        path,
+			Name:        chk.Name,
+			Check:       chk.Check,
+			Parameters:  redactParameters(chk.RawParameters),
+			Code:        chklst.Codes[i],
+			Message:     chklst.Messages[i],
+			Maintenance: maintenance,
+			Weight:      checkWeight(chk),
+			Score:       chklst.Score,
+			Grade:       chklst.Grade,
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Fatal("Couldn't encode run log entry:\n\t" + err.Error())
+		}
+		lines = append(lines, append(line, '\n')...)
+	}
+	// one write per checklist, so concurrent runs don't interleave lines
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	// parameters can include usernames and hosts, so only the owner reads it
+	file, err := os.OpenFile(runLogPath, flags, 0600)
+	if err != nil {
+		log.Fatal("Couldn't open run log " + runLogPath + ":\n\t" + err.Error())
+	}
+	defer file.Close()
+	if _, err := file.Write(lines); err != nil {
+		log.Fatal("Couldn't write to run log " + runLogPath + ":\n\t" + err.Error())
+	}
+}
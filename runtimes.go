@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// runtimeCommands are the default executables and arguments that print the
+// version of each supported language runtime
+var runtimeCommands = map[string][]string{
+	"java":   {"java", "-version"},
+	"python": {"python3", "--version"},
+	"node":   {"node", "--version"},
+	"go":     {"go", "version"},
+	"ruby":   {"ruby", "--version"},
+}
+
+// getRuntimeVersion runs the given runtime's version command, optionally with
+// a specific executable, and returns the first version number in its output
+func getRuntimeVersion(runtime string, executable string) string {
+	command, ok := runtimeCommands[strings.ToLower(runtime)]
+	if !ok {
+		msg := "Unsupported runtime: " + runtime
+		msg += "\n\tSupported: java, python, node, go, ruby"
+		log.Fatal(msg)
+	}
+	if executable == "" {
+		executable = command[0]
+	}
+	// java prints its version to stderr
+	out, err := exec.Command(executable, command[1:]...).CombinedOutput()
+	if err != nil {
+		msg := "Couldn't get runtime version:"
+		msg += "\n\tExecutable: " + executable
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	return parseRuntimeVersion(string(out))
+}
+
+// runtimeVersionRe matches a version number, e.g. 3.4.3 in "Python 3.4.3",
+// 0.12.7 in "v0.12.7", 1.5 in "go1.5", or 17 in "openjdk version "17""
+var runtimeVersionRe = regexp.MustCompile(`[0-9]+(\.[0-9]+)*`)
+
+// quotedVersionRe matches the quoted version in java -version's output, which
+// may follow other lines like "Picked up JAVA_TOOL_OPTIONS: -Xmx512m"
+var quotedVersionRe = regexp.MustCompile(`version "([^"]*)"`)
+
+// parseRuntimeVersion returns the version number in a runtime's version output,
+// preferring a quoted version if there is one
+func parseRuntimeVersion(out string) string {
+	if match := quotedVersionRe.FindStringSubmatch(out); match != nil {
+		if version := runtimeVersionRe.FindString(match[1]); version != "" {
+			return version
+		}
+	}
+	return runtimeVersionRe.FindString(out)
+}
+
+// compareVersions compares two dotted version strings numerically, returning
+// -1, 0, or 1. Missing components count as zero, so 1.8 == 1.8.0.
+func compareVersions(a string, b string) int {
+	aSplit := strings.Split(a, ".")
+	bSplit := strings.Split(b, ".")
+	for i := 0; i < len(aSplit) || i < len(bSplit); i++ {
+		var aNum, bNum int
+		if i < len(aSplit) {
+			aNum, _ = strconv.Atoi(aSplit[i])
+		}
+		if i < len(bSplit) {
+			bNum, _ = strconv.Atoi(bSplit[i])
+		}
+		if aNum < bNum {
+			return -1
+		} else if aNum > bNum {
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionSatisfies reports whether the version meets a constraint made of
+// comma-separated comparisons, like ">=1.7,<1.9". A version without an
+// operator is a minimum.
+func versionSatisfies(version string, constraint string) bool {
+	operatorRegex := regexp.MustCompile("^(>=|<=|==|!=|>|<)?\\s*([0-9]+(\\.[0-9]+)*)$")
+	for _, part := range strings.Split(constraint, ",") {
+		match := operatorRegex.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			log.Fatal("Couldn't parse version constraint: " + constraint)
+		}
+		cmp := compareVersions(version, match[2])
+		var ok bool
+		switch match[1] {
+		case ">=", "":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// runtimeVersion checks that the version of a language runtime (java, python,
+// node, go, or ruby) meets a constraint, like ">=1.8" or ">=3.4,<3.6". If
+// executable is not empty, that installation is checked instead of the one
+// first in $PATH.
+func runtimeVersion(runtime string, constraint string, executable string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		version := getRuntimeVersion(runtime, executable)
+		if version != "" && versionSatisfies(version, constraint) {
+			return 0, ""
+		}
+		msg := "Version of " + runtime + " does not satisfy constraint"
+		return genericError(msg, constraint, []string{version})
+	}
+}
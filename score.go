@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// healthGrades are the lowest scores that earn each letter grade
+var healthGrades = []struct {
+	min   float64
+	grade string
+}{
+	{90, "A"}, {80, "B"}, {70, "C"}, {60, "D"}, {0, "F"},
+}
+
+// checkWeight returns how much this check counts toward the health score,
+// which is 1 unless its Weight says otherwise. A weight of 0 makes a check
+// informational.
+func checkWeight(chk Check) float64 {
+	if chk.Weight == nil {
+		return 1
+	} else if *chk.Weight < 0 {
+		log.Fatal("Check weight can't be negative: " + fmt.Sprint(*chk.Weight))
+	}
+	return *chk.Weight
+}
+
+// healthScore returns the weighted percentage of this checklist's checks
+// that passed, from 0 to 100. A checklist with no weighted checks scores 100.
+func healthScore(chklst Checklist) float64 {
+	var passed, total float64
+	for i, chk := range chklst.Checklist {
+		weight := checkWeight(chk)
+		total += weight
+		if chklst.Codes[i] == 0 {
+			passed += weight
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return 100 * passed / total
+}
+
+// healthGrade returns the letter grade for this health score
+func healthGrade(score float64) string {
+	for _, grade := range healthGrades {
+		if score >= grade.min {
+			return grade.grade
+		}
+	}
+	return "F"
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// selinuxfs is where the kernel exposes SELinux's state
+const selinuxfs = "/sys/fs/selinux/"
+
+// readKernelFlag reads a small file from sysfs or securityfs, returning ""
+// if it doesn't exist (e.g. because the LSM isn't enabled)
+func readKernelFlag(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// selinuxEnforcing checks that SELinux is enabled and in enforcing mode
+func selinuxEnforcing() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if _, err := os.Stat(selinuxfs + "enforce"); err != nil {
+			return 1, "SELinux is disabled"
+		}
+		if readKernelFlag(selinuxfs+"enforce") == "1" {
+			return 0, ""
+		}
+		return 1, "SELinux is in permissive mode"
+	}
+}
+
+// selinuxBooleanIs checks that an SELinux boolean is currently on or off
+func selinuxBooleanIs(name string, value string) Thunk {
+	wanted := ""
+	switch strings.ToLower(value) {
+	case "on", "1", "true":
+		wanted = "1"
+	case "off", "0", "false":
+		wanted = "0"
+	default:
+		log.Fatal("SELinux boolean must be on or off, got: " + value)
+	}
+	return func() (exitCode int, exitMessage string) {
+		// the file holds the current and pending values, e.g. "1 1"
+		fields := strings.Fields(readKernelFlag(selinuxfs + "booleans/" + name))
+		if len(fields) == 0 {
+			return 1, "SELinux boolean does not exist: " + name
+		}
+		if fields[0] == wanted {
+			return 0, ""
+		}
+		actual := map[string]string{"0": "off", "1": "on"}[fields[0]]
+		msg := "SELinux boolean does not have value: " + name
+		return genericError(msg, value, []string{actual})
+	}
+}
+
+// errNoXattr is returned by getXattr for a file without that attribute
+var errNoXattr = errors.New("no such attribute")
+
+// getSELinuxContext returns a file's security context, e.g.
+// system_u:object_r:httpd_sys_content_t:s0
+func getSELinuxContext(path string) (string, error) {
+	value, err := getXattr(path, "security.selinux")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\x00"), nil
+}
+
+// fileHasSELinuxContext checks a file's SELinux context. A full context
+// (user:role:type:level) must match exactly; a bare type like
+// httpd_sys_content_t only has to match the type.
+func fileHasSELinuxContext(path string, context string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		actual, err := getSELinuxContext(path)
+		if err != nil {
+			return 1, "Couldn't read SELinux context of: " + path + "\n\t" + err.Error()
+		}
+		parts := strings.Split(actual, ":")
+		if actual == context || (!strings.Contains(context, ":") && len(parts) > 2 && parts[2] == context) {
+			return 0, ""
+		}
+		msg := "File does not have SELinux context: " + path
+		return genericError(msg, context, []string{actual})
+	}
+}
+
+// apparmorEnabled checks that the AppArmor LSM is enabled in the kernel
+func apparmorEnabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if readKernelFlag("/sys/module/apparmor/parameters/enabled") == "Y" {
+			return 0, ""
+		}
+		return 1, "AppArmor is not enabled"
+	}
+}
+
+// getAppArmorProfiles returns the mode of each loaded AppArmor profile, from
+// securityfs, where lines look like: /usr/sbin/ntpd (enforce)
+func getAppArmorProfiles() map[string]string {
+	profiles := make(map[string]string)
+	data := readKernelFlag("/sys/kernel/security/apparmor/profiles")
+	for _, line := range strings.Split(data, "\n") {
+		i := strings.LastIndex(line, " (")
+		if i < 0 {
+			continue
+		}
+		profiles[line[:i]] = strings.TrimSuffix(line[i+2:], ")")
+	}
+	return profiles
+}
+
+// apparmorProfileEnforced checks that an AppArmor profile is loaded in
+// enforce mode
+func apparmorProfileEnforced(profile string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		profiles := getAppArmorProfiles()
+		mode, ok := profiles[profile]
+		if !ok {
+			return 1, "AppArmor profile is not loaded: " + profile
+		}
+		if mode == "enforce" {
+			return 0, ""
+		}
+		msg := "AppArmor profile is not enforced: " + profile
+		return genericError(msg, "enforce", []string{mode})
+	}
+}
+
+// fipsEnabled checks that the kernel is running in FIPS mode
+func fipsEnabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if readKernelFlag("/proc/sys/crypto/fips_enabled") == "1" {
+			return 0, ""
+		}
+		return 1, "Kernel is not in FIPS mode"
+	}
+}
+
+// getCryptoPolicy returns the system-wide crypto policy (e.g. DEFAULT or
+// FIPS:OSPP), preferring the policy that was last applied over the
+// configured one
+func getCryptoPolicy() string {
+	for _, path := range []string{"/etc/crypto-policies/state/current", "/etc/crypto-policies/config"} {
+		if policy := readKernelFlag(path); policy != "" {
+			return policy
+		}
+	}
+	out, err := exec.Command("update-crypto-policies", "--show").Output()
+	if err != nil {
+		log.Fatal("Couldn't determine crypto policy: no /etc/crypto-policies or `update-crypto-policies`")
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// cryptoPolicyIs checks that the system-wide crypto policy is this profile,
+// including any subpolicies, e.g. FIPS or DEFAULT:NO-SHA1
+func cryptoPolicyIs(policy string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		actual := getCryptoPolicy()
+		if strings.EqualFold(actual, policy) {
+			return 0, ""
+		}
+		return genericError("Crypto policy does not match", policy, []string{actual})
+	}
+}
+
+// secureBootVar is the EFI variable holding the Secure Boot state
+const secureBootVar = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// secureBootEnabled checks that the host booted with UEFI Secure Boot on
+func secureBootEnabled() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if _, err := os.Stat("/sys/firmware/efi"); err != nil {
+			return 1, "System was not booted with UEFI"
+		}
+		data, err := ioutil.ReadFile(secureBootVar)
+		if err != nil {
+			return 1, "Couldn't read Secure Boot state:\n\t" + err.Error()
+		}
+		// four bytes of attributes, then the value
+		if len(data) == 5 && data[4] == 1 {
+			return 0, ""
+		}
+		return 1, "Secure Boot is not enabled"
+	}
+}
+
+// tpmSysfs is where the kernel describes the first TPM
+const tpmSysfs = "/sys/class/tpm/tpm0/"
+
+// tpm2Present checks that a TPM 2.0 device is present, and that its resource
+// manager device (/dev/tpmrm0) can be opened
+func tpm2Present() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		if _, err := os.Stat(tpmSysfs); err != nil {
+			return 1, "No TPM device found"
+		}
+		// tpm_version_major was added in Linux 5.6; the resource manager
+		// device only exists for TPM 2.0 on older kernels
+		version := readKernelFlag(tpmSysfs + "tpm_version_major")
+		if _, err := os.Stat("/dev/tpmrm0"); version != "2" && err != nil {
+			return genericError("TPM is not version 2.0", "2", []string{version})
+		}
+		file, err := os.OpenFile("/dev/tpmrm0", os.O_RDWR, 0)
+		if err != nil {
+			return 1, "TPM device is not accessible:\n\t" + err.Error()
+		}
+		file.Close()
+		return 0, ""
+	}
+}
+
+// tpmPCRBank checks that a TPM PCR bank (e.g. sha256) is active and, for each
+// of the given PCR indexes, that the PCR has been extended (isn't all zeros)
+func tpmPCRBank(bank string, pcrs []string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		dir := tpmSysfs + "pcr-" + strings.ToLower(bank) + "/"
+		if _, err := os.Stat(dir); err != nil {
+			return 1, "TPM PCR bank is not active (requires Linux 5.12+): " + bank
+		}
+		var empty []string
+		for _, pcr := range pcrs {
+			value := readKernelFlag(dir + pcr)
+			if value == "" {
+				return 1, "TPM PCR does not exist: " + bank + ":" + pcr
+			}
+			if strings.Trim(value, "0") == "" {
+				empty = append(empty, pcr)
+			}
+		}
+		if len(empty) == 0 {
+			return 0, ""
+		}
+		return genericError("TPM PCRs have not been extended", bank, empty)
+	}
+}
+
+// imaSecurityfs is where the kernel exposes IMA's policy and measurements
+const imaSecurityfs = "/sys/kernel/security/ima/"
+
+// getIMAMeasurementCount returns the number of entries in the IMA runtime
+// measurement list, or -1 if IMA isn't available
+func getIMAMeasurementCount() int {
+	count, err := strconv.Atoi(readKernelFlag(imaSecurityfs + "runtime_measurements_count"))
+	if err != nil {
+		return -1
+	}
+	return count
+}
+
+// imaPolicyLoaded checks that an IMA policy is loaded. The policy can only be
+// read back on kernels built with IMA_READ_POLICY; elsewhere, a policy is
+// inferred from measurements beyond the boot aggregate.
+func imaPolicyLoaded() Thunk {
+	return func() (exitCode int, exitMessage string) {
+		count := getIMAMeasurementCount()
+		if count < 0 {
+			return 1, "IMA is not enabled (no " + imaSecurityfs + ")"
+		}
+		if readKernelFlag(imaSecurityfs+"policy") != "" || count > 1 {
+			return 0, ""
+		}
+		return 1, "No IMA policy is loaded"
+	}
+}
+
+// imaMeasurementsAbove checks that the IMA measurement list has more than
+// this many entries, i.e. that files are actually being measured
+func imaMeasurementsAbove(min int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		count := getIMAMeasurementCount()
+		if count < 0 {
+			return 1, "IMA is not enabled (no " + imaSecurityfs + ")"
+		}
+		if count > min {
+			return 0, ""
+		}
+		msg := "Too few IMA measurements"
+		return genericError(msg, fmt.Sprint(min), []string{fmt.Sprint(count)})
+	}
+}
+
+// imaTemplateIs checks that every IMA measurement uses this template (e.g.
+// ima-ng or ima-sig), from lines like: 10 <hash> ima-ng sha256:<hash> /path
+func imaTemplateIs(template string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		data := readKernelFlag(imaSecurityfs + "ascii_runtime_measurements")
+		if data == "" {
+			return 1, "Couldn't read IMA measurement list"
+		}
+		var others []string
+		for _, line := range strings.Split(data, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 2 && fields[2] != template && !strIn(fields[2], others) {
+				others = append(others, fields[2])
+			}
+		}
+		if len(others) == 0 {
+			return 0, ""
+		}
+		return genericError("IMA measurements use other templates", template, others)
+	}
+}
+
+// loginBanners are the banners shown before login, by short name
+var loginBanners = map[string]string{
+	"issue":     "/etc/issue",
+	"issue.net": "/etc/issue.net",
+	"motd":      "/etc/motd",
+}
+
+// getBannerPath returns the file holding a login banner, given its short name,
+// "sshd" for the file named by sshd's Banner directive, or a path
+func getBannerPath(banner string) (path string, errMsg string) {
+	if path, ok := loginBanners[banner]; ok {
+		return path, ""
+	} else if banner == "sshd" {
+		values := getSshdConfig("")["banner"]
+		if len(values) == 0 || strings.EqualFold(values[0], "none") {
+			return "", "sshd has no Banner configured"
+		}
+		return values[0], ""
+	}
+	return banner, ""
+}
+
+// bannerMatches checks that a login banner contains text matching this
+// regular expression, e.g. a required legal notice
+func bannerMatches(banner string, pattern string) Thunk {
+	re := compileRegex(pattern)
+	return func() (exitCode int, exitMessage string) {
+		path, errMsg := getBannerPath(banner)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		data, errMsg := readFileOrFail(path)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		if re.MatchString(data) {
+			return 0, ""
+		}
+		msg := "Login banner does not contain required text: " + path
+		return genericError(msg, pattern, []string{strings.TrimSpace(data)})
+	}
+}
+
+// bannerChecksum checks that a login banner has exactly this checksum, for
+// audits that mandate word-for-word text
+func bannerChecksum(algorithm string, checksum string, banner string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		path, errMsg := getBannerPath(banner)
+		if errMsg != "" {
+			return 1, errMsg
+		}
+		if _, errMsg := readFileOrFail(path); errMsg != "" {
+			return 1, errMsg
+		}
+		return Checksum(algorithm, checksum, path)()
+	}
+}
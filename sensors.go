@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cpuSensors are the names of the thermal zones and hwmon chips that report
+// CPU temperatures, across Intel, AMD, and ARM boards
+var cpuSensors = []string{
+	"x86_pkg_temp", "coretemp", "k10temp", "zenpower", "cpu_thermal",
+	"cpu-thermal", "soc_thermal", "cpu",
+}
+
+// readSysfsNumber reads a file holding a single number, as sysfs sensors do
+func readSysfsNumber(path string) (float64, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	return value, err == nil
+}
+
+// getSensorReadings returns every temperature (°C) and fan speed (RPM) the
+// kernel reports, keyed by sensor name. Thermal zones are named by their type
+// (e.g. "acpitz"), and hwmon sensors by chip and label (e.g.
+// "coretemp/Package id 0", or "nvme/temp1" for unlabelled ones). If the
+// kernel reports none, lm-sensors is asked instead.
+func getSensorReadings() map[string]float64 {
+	readings := make(map[string]float64)
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	for _, zone := range zones {
+		name := strings.TrimSpace(fileToStringOrEmpty(filepath.Join(zone, "type")))
+		if temp, ok := readSysfsNumber(filepath.Join(zone, "temp")); ok && name != "" {
+			readings[name] = temp / 1000
+		}
+	}
+	chips, _ := filepath.Glob("/sys/class/hwmon/hwmon*")
+	for _, chip := range chips {
+		chipName := strings.TrimSpace(fileToStringOrEmpty(filepath.Join(chip, "name")))
+		inputs, _ := filepath.Glob(filepath.Join(chip, "*_input"))
+		for _, input := range inputs {
+			sensor := strings.TrimSuffix(filepath.Base(input), "_input")
+			scale := 1.0
+			if strings.HasPrefix(sensor, "temp") {
+				scale = 1000
+			} else if !strings.HasPrefix(sensor, "fan") {
+				continue
+			}
+			label := strings.TrimSpace(fileToStringOrEmpty(filepath.Join(chip, sensor+"_label")))
+			if label == "" {
+				label = sensor
+			}
+			if value, ok := readSysfsNumber(input); ok {
+				readings[chipName+"/"+label] = value / scale
+			}
+		}
+	}
+	if len(readings) == 0 {
+		readings = getLmSensorsReadings()
+	}
+	return readings
+}
+
+// fileToStringOrEmpty reads a small sysfs attribute, which may not exist
+func fileToStringOrEmpty(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// getLmSensorsReadings parses the raw output of `sensors -u`, which lists
+// each chip (e.g. "coretemp-isa-0000"), then each of its sensors' labels,
+// followed by indented values like "temp1_input: 45.000"
+func getLmSensorsReadings() map[string]float64 {
+	readings := make(map[string]float64)
+	out, err := exec.Command("sensors", "-u").Output()
+	if err != nil {
+		return readings
+	}
+	chip, label := "", ""
+	for _, line := range strings.Split(string(out), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "Adapter:"):
+			continue
+		case !strings.HasPrefix(line, " ") && !strings.HasSuffix(trimmed, ":"):
+			chip = strings.SplitN(trimmed, "-", 2)[0]
+		case !strings.HasPrefix(line, " "):
+			label = strings.TrimSuffix(trimmed, ":")
+		default:
+			parts := strings.SplitN(trimmed, ":", 2)
+			isInput := strings.HasSuffix(parts[0], "_input")
+			isTempOrFan := strings.HasPrefix(parts[0], "temp") || strings.HasPrefix(parts[0], "fan")
+			if len(parts) == 2 && isInput && isTempOrFan {
+				if value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err == nil {
+					readings[chip+"/"+label] = value
+				}
+			}
+		}
+	}
+	return readings
+}
+
+// sensorNames returns the names of the given sensor readings, in order
+func sensorNames(readings map[string]float64) (names []string) {
+	for name := range readings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cpuTempBelow checks that the hottest CPU temperature sensor is below this
+// many degrees Celsius
+func cpuTempBelow(max float64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		readings := getSensorReadings()
+		hottest, found := "", false
+		for _, name := range sensorNames(readings) {
+			chip := strings.SplitN(name, "/", 2)[0]
+			if !strIn(chip, cpuSensors) {
+				continue
+			}
+			if !found || readings[name] > readings[hottest] {
+				hottest, found = name, true
+			}
+		}
+		if !found {
+			msg := "Couldn't find a CPU temperature sensor. Available sensors:"
+			return genericError(msg, fmt.Sprint(cpuSensors), sensorNames(readings))
+		}
+		if readings[hottest] < max {
+			return 0, ""
+		}
+		msg := "CPU temperature exceeds maximum: " + hottest
+		return genericError(msg, fmt.Sprint(max), []string{fmt.Sprint(readings[hottest])})
+	}
+}
+
+// sensorBelow checks that a temperature or fan speed sensor, named as
+// getSensorReadings names it, reads below this value
+func sensorBelow(sensor string, max float64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		readings := getSensorReadings()
+		value, ok := readings[sensor]
+		if !ok {
+			return genericError("Sensor not found", sensor, sensorNames(readings))
+		}
+		if value < max {
+			return 0, ""
+		}
+		msg := "Sensor reading exceeds maximum: " + sensor
+		return genericError(msg, fmt.Sprint(max), []string{fmt.Sprint(value)})
+	}
+}
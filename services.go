@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// getInitSystem detects which init system manages services on this host:
+// "systemd" | "openrc" | "runit" | "sysv"
+func getInitSystem() string {
+	if initSystem := detectInitSystem(); initSystem != "" {
+		return initSystem
+	}
+	log.Fatal("Couldn't detect an init system. Attempted: systemd, openrc, runit, sysv")
+	return "" // never reaches this return
+}
+
+// detectInitSystem is getInitSystem, but returns "" if none is found
+func detectInitSystem() string {
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	inPath := func(program string) bool {
+		_, err := exec.LookPath(program)
+		return err == nil
+	}
+	switch {
+	case exists("/run/systemd/system"):
+		return "systemd"
+	case exists("/run/openrc") || inPath("openrc"):
+		return "openrc"
+	case exists("/run/runit") || exists("/etc/runit"):
+		return "runit"
+	case inPath("service") || exists("/etc/init.d"):
+		return "sysv"
+	}
+	return ""
+}
+
+// runitServiceDirs are where runit looks for enabled services, depending on
+// the distribution
+var runitServiceDirs = []string{
+	"/etc/service", "/var/service", "/etc/runit/runsvdir/default",
+}
+
+// serviceIsActive asks the given init system whether the service is running
+func serviceIsActive(initSystem string, name string) bool {
+	switch initSystem {
+	case "systemd":
+		out, _ := exec.Command("systemctl", "is-active", name).Output()
+		return strings.TrimSpace(string(out)) == "active"
+	case "openrc":
+		return exec.Command("rc-service", name, "status").Run() == nil
+	case "runit":
+		out, _ := exec.Command("sv", "status", name).Output()
+		return strings.HasPrefix(string(out), "run:")
+	}
+	// LSB init scripts exit 0 from status only when the service is running
+	return exec.Command("service", name, "status").Run() == nil
+}
+
+// serviceIsEnabled asks the given init system whether the service will be
+// started at boot
+func serviceIsEnabled(initSystem string, name string) bool {
+	switch initSystem {
+	case "systemd":
+		out, _ := exec.Command("systemctl", "is-enabled", name).Output()
+		return strings.TrimSpace(string(out)) == "enabled"
+	case "openrc":
+		// lines look like "sshd | default"
+		out, _ := exec.Command("rc-update", "show").Output()
+		for _, line := range strings.Split(string(out), "\n") {
+			split := strings.Split(line, "|")
+			if len(split) > 1 && strings.TrimSpace(split[0]) == name {
+				return strings.TrimSpace(split[1]) != ""
+			}
+		}
+		return false
+	case "runit":
+		for _, dir := range runitServiceDirs {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	// SysV services are enabled by start links in a multi-user runlevel
+	for _, runlevel := range []string{"2", "3", "4", "5"} {
+		links, _ := filepath.Glob("/etc/rc" + runlevel + ".d/S[0-9][0-9]" + name)
+		if len(links) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceActive checks that a service is running, using whichever of
+// systemd, OpenRC, runit, or SysV init manages services on this host
+func serviceActive(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		initSystem := getInitSystem()
+		if serviceIsActive(initSystem, name) {
+			return 0, ""
+		}
+		return 1, "Service is not active: " + name + "\n\tInit system: " + initSystem
+	}
+}
+
+// serviceEnabled checks that a service is started at boot, using whichever of
+// systemd, OpenRC, runit, or SysV init manages services on this host
+func serviceEnabled(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		initSystem := getInitSystem()
+		if serviceIsEnabled(initSystem, name) {
+			return 0, ""
+		}
+		return 1, "Service is not enabled: " + name + "\n\tInit system: " + initSystem
+	}
+}
+
+// getEnabledServices lists the services the given init system will start at
+// boot
+func getEnabledServices(initSystem string) (services []string) {
+	add := func(name string) {
+		if name != "" && !strIn(name, services) {
+			services = append(services, name)
+		}
+	}
+	switch initSystem {
+	case "systemd":
+		units, statuses := getUnitFilesWithStatuses("")
+		for i, unit := range units {
+			if strings.HasSuffix(unit, ".service") && statuses[i] == "enabled" {
+				add(strings.TrimSuffix(unit, ".service"))
+			}
+		}
+	case "openrc":
+		out, _ := exec.Command("rc-update", "show").Output()
+		for _, line := range strings.Split(string(out), "\n") {
+			split := strings.Split(line, "|")
+			if len(split) > 1 && strings.TrimSpace(split[1]) != "" {
+				add(strings.TrimSpace(split[0]))
+			}
+		}
+	case "runit":
+		for _, dir := range runitServiceDirs {
+			entries, _ := ioutil.ReadDir(dir)
+			for _, entry := range entries {
+				add(entry.Name())
+			}
+		}
+	default:
+		for _, runlevel := range []string{"2", "3", "4", "5"} {
+			links, _ := filepath.Glob("/etc/rc" + runlevel + ".d/S[0-9][0-9]*")
+			for _, link := range links {
+				add(filepath.Base(link)[3:])
+			}
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// noUnexpectedEnabledServices checks that no service outside the allowlist
+// is started at boot
+func noUnexpectedEnabledServices(allowed []string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		initSystem := getInitSystem()
+		var unexpected []string
+		for _, service := range getEnabledServices(initSystem) {
+			if !strIn(service, allowed) {
+				unexpected = append(unexpected, service)
+			}
+		}
+		if len(unexpected) == 0 {
+			return 0, ""
+		}
+		msg := "Unexpected services are enabled in " + initSystem
+		return genericError(msg, strings.Join(allowed, ","), unexpected)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// smartReport is the part of `smartctl --json` output that the SMART checks
+// read, for both ATA and NVMe devices
+type smartReport struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATAAttributes struct {
+		Table []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+			Raw  struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth map[string]interface{} `json:"nvme_smart_health_information_log"`
+}
+
+// smartFailingAttributes are the ATA attributes whose raw values should be
+// zero on a healthy disk: reallocated, pending, and uncorrectable sectors
+var smartFailingAttributes = []int{5, 197, 198}
+
+// getSmartReport runs smartctl on a device and parses its JSON output
+func getSmartReport(device string) smartReport {
+	out, err := exec.Command("smartctl", "--json", "-H", "-A", device).Output()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("SMART checks require smartctl (smartmontools)")
+	}
+	var report smartReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		log.Fatal("Couldn't parse `smartctl --json` output for: " + device + "\n\t" + err.Error())
+	}
+	// bits 0 and 1 of the exit status mean the device couldn't be queried
+	if report.Smartctl.ExitStatus&3 != 0 {
+		msg := "smartctl couldn't read device: " + device
+		for _, message := range report.Smartctl.Messages {
+			msg += "\n\t" + message.String
+		}
+		log.Fatal(msg)
+	}
+	return report
+}
+
+// getSmartAttribute returns an ATA attribute's raw value, given its name (e.g.
+// Reallocated_Sector_Ct) or ID, or a field of the NVMe health log (e.g.
+// media_errors)
+func getSmartAttribute(report smartReport, attribute string) (int64, bool) {
+	id, err := strconv.Atoi(attribute)
+	for _, attr := range report.ATAAttributes.Table {
+		if strings.EqualFold(attr.Name, attribute) || (err == nil && attr.ID == id) {
+			return attr.Raw.Value, true
+		}
+	}
+	if value, ok := report.NVMeHealth[strings.ToLower(attribute)].(float64); ok {
+		return int64(value), true
+	}
+	return 0, false
+}
+
+// smartHealthy checks that a device passes its SMART overall-health
+// self-assessment, and has no reallocated, pending, or uncorrectable sectors
+// (or NVMe media errors or critical warnings)
+func smartHealthy(device string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		report := getSmartReport(device)
+		if report.SmartStatus == nil {
+			return 1, "Device does not report SMART health: " + device
+		}
+		if !report.SmartStatus.Passed {
+			return 1, "SMART overall-health self-assessment FAILED: " + device
+		}
+		var failing []string
+		for _, attr := range report.ATAAttributes.Table {
+			for _, id := range smartFailingAttributes {
+				if attr.ID == id && attr.Raw.Value > 0 {
+					failing = append(failing, attr.Name+"="+fmt.Sprint(attr.Raw.Value))
+				}
+			}
+		}
+		for _, field := range []string{"critical_warning", "media_errors"} {
+			if value, ok := getSmartAttribute(report, field); ok && value > 0 {
+				failing = append(failing, field+"="+fmt.Sprint(value))
+			}
+		}
+		if len(failing) == 0 {
+			return 0, ""
+		}
+		return genericError("Device has failing SMART attributes", device, failing)
+	}
+}
+
+// smartAttributeBelow checks that a SMART attribute's raw value is below max
+func smartAttributeBelow(device string, attribute string, max int64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		report := getSmartReport(device)
+		value, ok := getSmartAttribute(report, attribute)
+		if !ok {
+			return 1, "Device does not report SMART attribute: " + device + ": " + attribute
+		}
+		if value < max {
+			return 0, ""
+		}
+		msg := "SMART attribute exceeds maximum: " + device + ": " + attribute
+		return genericError(msg, fmt.Sprint(max), []string{fmt.Sprint(value)})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// authorizedKey is a single public key from an authorized_keys file
+type authorizedKey struct {
+	Type    string
+	Blob    []byte
+	Comment string
+}
+
+// sha256Fingerprint formats a key's fingerprint like `ssh-keygen -l` does
+func (key authorizedKey) sha256Fingerprint() string {
+	sum := sha256.Sum256(key.Blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// md5Fingerprint formats a key's legacy MD5 fingerprint, e.g. MD5:de:ad:...
+func (key authorizedKey) md5Fingerprint() string {
+	sum := md5.Sum(key.Blob)
+	var hexes []string
+	for _, b := range sum {
+		hexes = append(hexes, fmt.Sprintf("%02x", b))
+	}
+	return "MD5:" + strings.Join(hexes, ":")
+}
+
+// matches reports whether a fingerprint (SHA256:..., MD5:..., or bare MD5
+// hex) or comment identifies this key
+func (key authorizedKey) matches(identifier string) bool {
+	md5Fingerprint := key.md5Fingerprint()
+	return identifier == key.sha256Fingerprint() ||
+		strings.EqualFold(identifier, md5Fingerprint) ||
+		strings.EqualFold("MD5:"+identifier, md5Fingerprint) ||
+		(key.Comment != "" && identifier == key.Comment)
+}
+
+// isSSHKeyType reports whether str is a public key algorithm name
+func isSSHKeyType(str string) bool {
+	return strings.HasPrefix(str, "ssh-") || strings.HasPrefix(str, "ecdsa-sha2-") ||
+		strings.HasPrefix(str, "sk-")
+}
+
+// parseAuthorizedKeys parses the keys in an authorized_keys file. Each line
+// is [options] keytype base64-key [comment]; options may contain quoted spaces,
+// so the key type is located rather than assumed to come first.
+func parseAuthorizedKeys(data string) (keys []authorizedKey) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for i := 0; i+1 < len(fields); i++ {
+			if !isSSHKeyType(fields[i]) {
+				continue
+			}
+			blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+			if err != nil {
+				continue
+			}
+			comment := strings.Join(fields[i+2:], " ")
+			keys = append(keys, authorizedKey{fields[i], blob, comment})
+			break
+		}
+	}
+	return keys
+}
+
+// getAuthorizedKeysFiles returns the paths sshd reads a user's authorized keys
+// from, according to the AuthorizedKeysFile directive
+func getAuthorizedKeysFiles(usernameOrUid string) (paths []string, err error) {
+	usr, err := lookupUser(usernameOrUid)
+	if err != nil {
+		return paths, err
+	}
+	patterns := []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+	if values := getSshdConfig("")["authorizedkeysfile"]; len(values) > 0 {
+		patterns = strings.Fields(values[0])
+	}
+	replacer := strings.NewReplacer("%%", "%", "%h", usr.HomeDir, "%u", usr.Username, "%U", usr.Uid)
+	for _, pattern := range patterns {
+		if pattern == "none" {
+			continue
+		}
+		path := replacer.Replace(pattern)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(usr.HomeDir, path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// getAuthorizedKeys returns every key in a user's authorized keys files.
+// Files that don't exist are skipped.
+func getAuthorizedKeys(usernameOrUid string) (keys []authorizedKey, err error) {
+	paths, err := getAuthorizedKeysFiles(usernameOrUid)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(hostPath(path))
+		if err == nil {
+			keys = append(keys, parseAuthorizedKeys(string(data))...)
+		}
+	}
+	return keys, err
+}
+
+// authorizedKeyThunk checks whether a user's authorized keys do (or, if absent is
+// true, don't) include a key with this fingerprint or comment. It is an
+// abstraction of authorizedKeyPresent and authorizedKeyAbsent.
+func authorizedKeyThunk(usernameOrUid string, identifier string, absent bool) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		keys, err := getAuthorizedKeys(usernameOrUid)
+		if err != nil {
+			return 1, "User does not exist: " + usernameOrUid
+		}
+		var fingerprints []string
+		for _, key := range keys {
+			if key.matches(identifier) {
+				if absent {
+					msg := "User has authorized key: " + usernameOrUid
+					return genericError(msg, identifier, []string{key.sha256Fingerprint()})
+				}
+				return 0, ""
+			}
+			fingerprints = append(fingerprints, key.sha256Fingerprint())
+		}
+		if absent {
+			return 0, ""
+		}
+		msg := "User does not have authorized key: " + usernameOrUid
+		return genericError(msg, identifier, fingerprints)
+	}
+}
+
+// authorizedKeyPresent checks that a user's authorized keys include a key
+// with this fingerprint (e.g. SHA256:...) or comment
+func authorizedKeyPresent(usernameOrUid string, identifier string) Thunk {
+	return authorizedKeyThunk(usernameOrUid, identifier, false)
+}
+
+// authorizedKeyAbsent checks that a user's authorized keys don't include a
+// key with this fingerprint or comment, e.g. after rotating it out
+func authorizedKeyAbsent(usernameOrUid string, identifier string) Thunk {
+	return authorizedKeyThunk(usernameOrUid, identifier, true)
+}
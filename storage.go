@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mdArray is a software RAID array from /proc/mdstat
+type mdArray struct {
+	Name   string
+	Active bool
+	// Status has one character per member device, U for up and _ for down
+	Status string
+	Failed []string
+}
+
+// getMdArrays parses /proc/mdstat, where each array has a line like
+// "md0 : active raid1 sdb1[1] sda1(F)[0]", followed by one ending with its
+// member status, like "[2/1] [U_]"
+func getMdArrays() (arrays []mdArray) {
+	statusRe := regexp.MustCompile(`\[([U_]+)\]`)
+	for _, line := range strings.Split(fileToString("/proc/mdstat"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && strings.HasPrefix(fields[0], "md") && fields[1] == ":" {
+			array := mdArray{Name: fields[0], Active: fields[2] == "active"}
+			for _, member := range fields[3:] {
+				if strings.Contains(member, "(F)") {
+					array.Failed = append(array.Failed, member)
+				}
+			}
+			arrays = append(arrays, array)
+		} else if match := statusRe.FindStringSubmatch(line); match != nil && len(arrays) > 0 {
+			arrays[len(arrays)-1].Status = match[1]
+		}
+	}
+	return arrays
+}
+
+// mdraidHealthy checks that every software RAID array (or just the named
+// one) is active, with no failed or missing members
+func mdraidHealthy(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		found := false
+		for _, array := range getMdArrays() {
+			if name != "" && array.Name != strings.TrimPrefix(name, "/dev/") {
+				continue
+			}
+			found = true
+			switch {
+			case !array.Active:
+				return 1, "RAID array is not active: " + array.Name
+			case len(array.Failed) > 0:
+				return genericError("RAID array has failed members", array.Name, array.Failed)
+			case strings.Contains(array.Status, "_"):
+				return genericError("RAID array is degraded", array.Name, []string{array.Status})
+			}
+		}
+		if !found && name != "" {
+			return 1, "RAID array does not exist: " + name
+		}
+		return 0, ""
+	}
+}
+
+// lvmReport runs an LVM reporting command (lvs, vgs, pvs) with the given
+// fields, and returns its rows
+func lvmReport(command string, fields string, args ...string) (rows [][]string) {
+	args = append([]string{"--noheadings", "--separator", "|", "-o", fields}, args...)
+	out, err := exec.Command(command, args...).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("LVM checks require the lvm2 tools")
+	} else if err != nil {
+		msg := "Error while executing `" + command + "`:"
+		msg += "\n\tOutput: " + strings.TrimSpace(string(out))
+		log.Fatal(msg)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var row []string
+		for _, field := range strings.Split(line, "|") {
+			row = append(row, strings.TrimSpace(field))
+		}
+		if len(row) > 0 && row[0] != "" {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// lvExists checks that a logical volume exists, given as vg/lv
+func lvExists(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var volumes []string
+		for _, row := range lvmReport("lvs", "vg_name,lv_name") {
+			if len(row) > 1 {
+				volumes = append(volumes, row[0]+"/"+row[1])
+			}
+		}
+		if strIn(strings.TrimPrefix(name, "/dev/"), volumes) {
+			return 0, ""
+		}
+		return genericError("Logical volume does not exist", name, volumes)
+	}
+}
+
+// vgFreeSpaceAbove checks that a volume group has more than this much free
+// space left for new or extended logical volumes
+func vgFreeSpaceAbove(vg string, min uint64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		rows := lvmReport("vgs", "vg_name,vg_free", "--units", "b", "--nosuffix")
+		for _, row := range rows {
+			if len(row) < 2 || row[0] != vg {
+				continue
+			}
+			free, err := strconv.ParseUint(row[1], 10, 64)
+			if err != nil {
+				log.Fatal("Couldn't parse free space of volume group: " + row[1])
+			}
+			if free > min {
+				return 0, ""
+			}
+			msg := "Volume group free space is too low: " + vg
+			return genericError(msg, formatSize(min), []string{formatSize(free)})
+		}
+		return 1, "Volume group does not exist: " + vg
+	}
+}
+
+// pvPresent checks that a device is an LVM physical volume, and isn't missing
+func pvPresent(device string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var devices []string
+		for _, row := range lvmReport("pvs", "pv_name,pv_attr") {
+			if len(row) < 2 {
+				continue
+			}
+			if row[0] == device {
+				// the third attribute is m when the PV is missing
+				if len(row[1]) > 2 && row[1][2] == 'm' {
+					return 1, "Physical volume is missing: " + device
+				}
+				return 0, ""
+			}
+			devices = append(devices, row[0])
+		}
+		return genericError("Physical volume not found", device, devices)
+	}
+}
+
+// storageCommand runs a ZFS or Btrfs tool and returns its output, failing
+// with a helpful message if the tool isn't installed
+func storageCommand(tools string, command string, args ...string) string {
+	out, err := exec.Command(command, args...).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal(tools + " checks require `" + command + "`")
+	} else if err != nil {
+		msg := "Error while executing `" + command + " " + strings.Join(args, " ") + "`:"
+		msg += "\n\tOutput: " + strings.TrimSpace(string(out))
+		log.Fatal(msg)
+	}
+	return string(out)
+}
+
+// getZpools returns the columns asked for of every ZFS pool, keyed by name
+func getZpools(columns string) map[string][]string {
+	pools := make(map[string][]string)
+	out := storageCommand("ZFS", "zpool", "list", "-H", "-p", "-o", "name,"+columns)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) > 1 {
+			pools[fields[0]] = fields[1:]
+		}
+	}
+	return pools
+}
+
+// poolNames returns the names of the pools returned by getZpools, in order
+func poolNames(pools map[string][]string) (names []string) {
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// zpoolHealthy checks that every ZFS pool (or just the named one) is ONLINE,
+// rather than DEGRADED, FAULTED, etc.
+func zpoolHealthy(pool string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		pools := getZpools("health")
+		if pool != "" {
+			if _, ok := pools[pool]; !ok {
+				return genericError("ZFS pool does not exist", pool, poolNames(pools))
+			}
+		}
+		for _, name := range poolNames(pools) {
+			if pool != "" && name != pool {
+				continue
+			}
+			if health := pools[name][0]; health != "ONLINE" {
+				msg := "ZFS pool is not healthy: " + name
+				return genericError(msg, "ONLINE", []string{health})
+			}
+		}
+		return 0, ""
+	}
+}
+
+// zpoolCapacityBelow checks that less than this percentage of a ZFS pool is
+// allocated. ZFS performance drops sharply as pools fill up.
+func zpoolCapacityBelow(pool string, max int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		pools := getZpools("capacity")
+		columns, ok := pools[pool]
+		if !ok {
+			return genericError("ZFS pool does not exist", pool, poolNames(pools))
+		}
+		capacityStr := strings.TrimSuffix(columns[0], "%")
+		capacity, err := strconv.ParseInt(capacityStr, 10, 32)
+		if err != nil {
+			log.Fatal("Couldn't parse capacity of ZFS pool: " + columns[0])
+		}
+		if int(capacity) < max {
+			return 0, ""
+		}
+		msg := "ZFS pool capacity is too high: " + pool
+		return genericError(msg, fmt.Sprint(max)+"%", []string{capacityStr + "%"})
+	}
+}
+
+// zfsDatasetExists checks that a ZFS dataset, volume, or snapshot exists
+// (e.g. "tank/home" or "tank/home@daily")
+func zfsDatasetExists(name string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		out, err := exec.Command("zfs", "list", "-H", "-t", "all", "-o", "name", name).CombinedOutput()
+		if err != nil && strings.Contains(err.Error(), "executable file not found") {
+			log.Fatal("ZFS checks require `zfs`")
+		} else if err != nil {
+			// zfs exits non-zero when the dataset doesn't exist
+			return 1, "ZFS dataset does not exist: " + name
+		}
+		if strings.TrimSpace(string(out)) == name {
+			return 0, ""
+		}
+		return 1, "ZFS dataset does not exist: " + name
+	}
+}
+
+// btrfsNoDeviceErrors checks that none of the devices of the Btrfs filesystem
+// mounted at this path have recorded read, write, flush, corruption, or
+// generation errors. `btrfs device stats` prints a line per counter, like
+// "[/dev/sda].write_io_errs    0".
+func btrfsNoDeviceErrors(mount string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		// -c makes btrfs exit non-zero when any counter isn't zero, which
+		// storageCommand would treat as a failure to run, so it's not used
+		out := storageCommand("Btrfs", "btrfs", "device", "stats", mount)
+		var errors []string
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[1] != "0" {
+				errors = append(errors, fields[0]+" "+fields[1])
+			}
+		}
+		if len(errors) == 0 {
+			return 0, ""
+		}
+		return genericError("Btrfs device errors found", mount, errors)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // systemctlExists returns whether or not systemctl is available ona given
@@ -24,29 +29,151 @@ func systemctlShouldExist() {
 	}
 }
 
+// systemctlCommand returns a command that runs systemctl with the given
+// arguments. If user is not empty, it runs `systemctl --user` against the
+// session of that user (a username or UID), which requires being that user or
+// root.
+func systemctlCommand(user string, args ...string) *exec.Cmd {
+	if user == "" {
+		return exec.Command("systemctl", args...)
+	}
+	usr, err := lookupUser(user)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	args = append([]string{"--user"}, args...)
+	cmd := exec.Command("systemctl", args...)
+	if fmt.Sprint(os.Getuid()) != usr.Uid {
+		runuserArgs := append([]string{"-u", usr.Username, "--", "systemctl"}, args...)
+		cmd = exec.Command("runuser", runuserArgs...)
+	}
+	// systemctl finds the user's manager through their runtime directory
+	runtimeDir := "/run/user/" + usr.Uid
+	cmd.Env = append(os.Environ(),
+		"XDG_RUNTIME_DIR="+runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path="+runtimeDir+"/bus",
+	)
+	return cmd
+}
+
+// systemctlJSONVersion is the first version of systemd that can print its
+// tables as JSON with --output=json
+const systemctlJSONVersion = 246
+
+// systemctlVersion returns the version of systemd, as reported by
+// `systemctl --version`
+func systemctlVersion() int {
+	out, err := exec.Command("systemctl", "--version").Output()
+	if err != nil {
+		log.Fatal("Couldn't execute `systemctl --version`:\n\t" + err.Error())
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		log.Fatal("Couldn't parse output of `systemctl --version`: " + string(out))
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil {
+		log.Fatal("Couldn't parse systemd version: " + fields[1])
+	}
+	return version
+}
+
+// systemctlTable runs a systemctl command that prints a table (list-units,
+// list-sockets, etc.) and returns its rows as maps from column name to value.
+// Column names are lowercase, with spaces replaced by underscores, as in
+// systemctl's JSON output (e.g. "unit_file"). Newer versions of systemd are
+// asked for JSON, and older ones for plain text, where the columns are named
+// by the given list. The last column takes the rest of each line, since it
+// is often a free-text description. If user is not empty, the table comes from
+// that user's systemd session.
+func systemctlTable(user string, columns []string, args ...string) (rows []map[string]string) {
+	systemctlShouldExist()
+	useJSON := systemctlVersion() >= systemctlJSONVersion
+	flags := []string{"--no-pager", "--plain", "--no-legend", "--full"}
+	if useJSON {
+		flags = []string{"--no-pager", "--full", "--output=json"}
+	}
+	cmd := systemctlCommand(user, append(flags, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		msg := "Couldn't execute systemctl:"
+		msg += "\n\tArguments: " + fmt.Sprint(cmd.Args)
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	rows, err = parseSystemctlTable(out, columns, useJSON)
+	if err != nil {
+		msg := "Couldn't parse JSON from systemctl:"
+		msg += "\n\tArguments: " + fmt.Sprint(cmd.Args)
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	return rows
+}
+
+// parseSystemctlTable parses the output of a systemctl table command, as
+// described for systemctlTable, either as JSON or as plain text columns
+func parseSystemctlTable(out []byte, columns []string, useJSON bool) (rows []map[string]string, err error) {
+	if useJSON {
+		var values []map[string]interface{}
+		if err := json.Unmarshal(out, &values); err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			row := make(map[string]string)
+			for key, val := range value {
+				if val != nil {
+					row[key] = fmt.Sprint(val)
+				}
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < len(columns) {
+			continue
+		}
+		row := make(map[string]string)
+		for i, column := range columns {
+			row[column] = fields[i]
+		}
+		last := len(columns) - 1
+		row[columns[last]] = strings.Join(fields[last:], " ")
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// tableColumn returns the values of a single column of the rows returned by
+// systemctlTable
+func tableColumn(column string, rows []map[string]string) (values []string) {
+	for _, row := range rows {
+		values = append(values, row[column])
+	}
+	return values
+}
+
+// listUnitsColumns are the columns of `systemctl list-units`
+var listUnitsColumns = []string{"unit", "load", "active", "sub", "description"}
+
 // systemctlServices checks on either the loaded or active field of
 // `systemctl list-units`. It is an abstraction of systemctlLoaded and
 // systemctlActive.
-func systemctlService(service string, loaded bool) Thunk {
+func systemctlService(service string, loaded bool, user string) Thunk {
 	return func() (exitCode int, exitMessage string) {
-		systemctlShouldExist() // error out if the command doesn't work
-		column := 2            // active, not loaded
+		column := "active"
 		state := "active"
-		if loaded { // loaded, not active
-			column = 1
+		if loaded {
+			column = "load"
 			state = "loaded"
 		}
-		// get columns
-		cmd := exec.Command("systemctl", "--no-pager", "list-units")
-		names := commandColumnNoHeader(1, cmd)
-		// can't execute the same command twice
-		cmd = exec.Command("systemctl", "--no-pager", "list-units")
-		statuses := commandColumnNoHeader(column+1, cmd) // weird offset
-		// parse through columns
+		rows := systemctlTable(user, listUnitsColumns, "list-units", "--all")
 		var actualState string
-		for i, srv := range names {
-			if service == srv && len(statuses) > i {
-				actualState = statuses[i]
+		for _, row := range rows {
+			if row["unit"] == service {
+				actualState = row[column]
 				if actualState == state {
 					return 0, ""
 				}
@@ -58,27 +185,26 @@ func systemctlService(service string, loaded bool) Thunk {
 }
 
 // systemctlLoaded checks to see whether or not a given service is loaded
-func systemctlLoaded(service string) Thunk {
-	return systemctlService(service, true)
+func systemctlLoaded(service string, user string) Thunk {
+	return systemctlService(service, true, user)
 }
 
 // systemctlActive checks to see whether or not a given service is active
-func systemctlActive(service string) Thunk {
-	return systemctlService(service, false)
+func systemctlActive(service string, user string) Thunk {
+	return systemctlService(service, false, user)
 }
 
 // systemctlSock is an abstraction of systemctlSockPath and systemctlSockUnit,
 // it reads from `systemctl list-sockets` and sees if the value is in the
 // appropriate column.
-func systemctlSock(value string, path bool) Thunk {
+func systemctlSock(value string, path bool, user string) Thunk {
 	return func() (exitCode int, exitMessage string) {
-		systemctlShouldExist() // log.Fatal if it doesn't
-		column := 1
+		column := "unit"
 		if path {
-			column = 0
+			column = "listen"
 		}
-		cmd := exec.Command("systemctl", "list-sockets")
-		values := commandColumnNoHeader(column, cmd)
+		columns := []string{"listen", "unit", "activates"}
+		values := tableColumn(column, systemctlTable(user, columns, "list-sockets"))
 		if strIn(value, values) {
 			return 0, ""
 		}
@@ -88,20 +214,20 @@ func systemctlSock(value string, path bool) Thunk {
 
 // systemctlSock checks to see whether the sock at the given path is registered
 // within systemd using the sock's filesystem path.
-func systemctlSockPath(path string) Thunk {
-	return systemctlSock(path, true)
+func systemctlSockPath(path string, user string) Thunk {
+	return systemctlSock(path, true, user)
 }
 
 // systemctlSock checks to see whether the sock at the given path is registered
 // within systemd using the sock's unit name.
-func systemctlSockUnit(name string) Thunk {
-	return systemctlSock(name, false)
+func systemctlSockUnit(name string, user string) Thunk {
+	return systemctlSock(name, false, user)
 }
 
-func getTimers(all bool) []string {
-	cmd := exec.Command("systemctl", "list-timers")
+func getTimers(all bool, user string) []string {
+	cmd := systemctlCommand(user, "list-timers")
 	if all {
-		cmd = exec.Command("systemctl", "list-timers", "--all")
+		cmd = systemctlCommand(user, "list-timers", "--all")
 	}
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -114,9 +240,9 @@ func getTimers(all bool) []string {
 }
 
 // timersThunk is pure DRY for systemctlTimer and systemctlTimerLoaded
-func timersThunk(unit string, all bool) Thunk {
+func timersThunk(unit string, all bool, user string) Thunk {
 	return func() (exitCode int, exitMessage string) {
-		timers := getTimers(all)
+		timers := getTimers(all, user)
 		if strIn(unit, timers) {
 			return 0, ""
 		}
@@ -125,41 +251,264 @@ func timersThunk(unit string, all bool) Thunk {
 }
 
 // systemctlTimer reports whether a given timer is running (by unit).
-func systemctlTimer(unit string) Thunk {
-	return timersThunk(unit, false)
+func systemctlTimer(unit string, user string) Thunk {
+	return timersThunk(unit, false, user)
 }
 
 // systemctlTimerLoaded checks to see if a timer is loaded, even if it might
 // not be active
-func systemctlTimerLoaded(unit string) Thunk {
-	return timersThunk(unit, true)
+func systemctlTimerLoaded(unit string, user string) Thunk {
+	return timersThunk(unit, true, user)
 }
 
-// systemctlUnitFileStatus checks whether or not the given unit file has the
-// given status: static | enabled | disabled
-func systemctlUnitFileStatus(unit string, status string) Thunk {
-	// getUnitFilesWithStatuses returns a pair of string slices that hold
-	// the name of unit files with their current statuses.
-	getUnitFilesWithStatuses := func() (units []string, statuses []string) {
-		cmd := exec.Command("systemctl", "--no-pager", "list-unit-files")
-		units = commandColumnNoHeader(0, cmd)
-		cmd = exec.Command("systemctl", "--no-pager", "list-unit-files")
-		statuses = commandColumnNoHeader(1, cmd)
-		// last two are empty line and junk statistics we don't care about
-		return units[:len(units)-2], statuses[:len(statuses)-2]
+// getUnitFilesWithStatuses returns a pair of string slices that hold the name
+// of unit files with their current statuses, from `systemctl list-unit-files`
+func getUnitFilesWithStatuses(user string) (units []string, statuses []string) {
+	return unitFileStatuses(systemctlTable(user, unitFileColumns, "list-unit-files"))
+}
+
+// unitFileColumns are the columns of `systemctl list-unit-files`
+var unitFileColumns = []string{"unit_file", "state"}
+
+// unitFileStatuses picks the unit files and their statuses out of the rows
+// of `systemctl list-unit-files`. Rows without a state are skipped.
+func unitFileStatuses(rows []map[string]string) (units []string, statuses []string) {
+	// unit names always have a type suffix, which skips the "N unit files
+	// listed." footer that some versions print regardless of --no-legend
+	unitRegex := regexp.MustCompile("^[^\\s]+\\.[a-z]+$")
+	for _, row := range rows {
+		// newer versions add a preset column after the state
+		state := strings.Fields(row["state"])
+		if unitRegex.MatchString(row["unit_file"]) && len(state) > 0 {
+			units = append(units, row["unit_file"])
+			statuses = append(statuses, state[0])
+		}
 	}
+	return units, statuses
+}
+
+// unitFileHasStatus is an abstraction of systemctlUnitFileStatus, unitEnabled,
+// unitDisabled, and unitMasked. It checks whether or not the given unit file
+// has one of the given statuses.
+func unitFileHasStatus(unit string, user string, accepted ...string) Thunk {
 	return func() (exitCode int, exitMessage string) {
-		units, statuses := getUnitFilesWithStatuses()
+		units, statuses := getUnitFilesWithStatuses(user)
 		var actualStatus string
 		for i, un := range units {
 			if un == unit {
 				actualStatus = statuses[i]
-				if actualStatus == status {
+				if strIn(actualStatus, accepted) {
 					return 0, ""
 				}
 			}
 		}
 		msg := "Unit didn't have status"
-		return genericError(msg, status, []string{actualStatus})
+		return genericError(msg, accepted[0], []string{actualStatus})
+	}
+}
+
+// systemctlUnitFileStatus checks whether or not the given unit file has the
+// given status: static | enabled | disabled
+func systemctlUnitFileStatus(unit string, status string, user string) Thunk {
+	return unitFileHasStatus(unit, user, status)
+}
+
+// unitEnabled checks whether or not the given unit file is enabled
+func unitEnabled(unit string, user string) Thunk {
+	return unitFileHasStatus(unit, user, "enabled", "enabled-runtime")
+}
+
+// unitDisabled checks whether or not the given unit file is disabled
+func unitDisabled(unit string, user string) Thunk {
+	return unitFileHasStatus(unit, user, "disabled")
+}
+
+// unitMasked checks whether or not the given unit file is masked
+func unitMasked(unit string, user string) Thunk {
+	return unitFileHasStatus(unit, user, "masked", "masked-runtime")
+}
+
+// getFailedUnits returns the names of all units in the failed state, as
+// reported by `systemctl --failed`
+func getFailedUnits(user string) (units []string) {
+	rows := systemctlTable(user, listUnitsColumns, "list-units", "--failed")
+	return tableColumn("unit", rows)
+}
+
+// failedUnitsBelow checks that fewer than max units are in the failed state
+func failedUnitsBelow(max int, user string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		failed := getFailedUnits(user)
+		if len(failed) < max {
+			return 0, ""
+		}
+		msg := "Too many failed units (" + fmt.Sprint(len(failed)) + ")"
+		return genericError(msg, "fewer than "+fmt.Sprint(max), failed)
+	}
+}
+
+// noFailedUnits checks that no units are in the failed state
+func noFailedUnits(user string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		failed := getFailedUnits(user)
+		if len(failed) == 0 {
+			return 0, ""
+		}
+		return genericError("Units are in the failed state", "none", failed)
+	}
+}
+
+// getUnitProperty returns the value of a property of a unit, as reported by
+// `systemctl show`
+func getUnitProperty(unit string, property string, user string) string {
+	systemctlShouldExist()
+	cmd := systemctlCommand(user, "show", "--property="+property, unit)
+	out, err := cmd.Output()
+	if err != nil {
+		msg := "Couldn't execute `systemctl show`:"
+		msg += "\n\tUnit: " + unit
+		msg += "\n\tProperty: " + property
+		msg += "\n\tError: " + err.Error()
+		log.Fatal(msg)
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), property+"=")
+}
+
+// unitSizeRe matches a property value that is a size, like "2G" or
+// "2147483648", which systemctl show always prints in bytes
+var unitSizeRe = regexp.MustCompile(`(?i)^[0-9]+(\.[0-9]+)?\s*([KMGT](i?B)?|B)?$`)
+
+// isUnitInfinity reports whether a property value means no limit, which older
+// versions of systemd print as the largest 64-bit value
+func isUnitInfinity(value string) bool {
+	return strings.EqualFold(value, "infinity") || value == "18446744073709551615"
+}
+
+// unitPropertyMatches reports whether a property's value is the expected one,
+// comparing sizes in bytes, so that MemoryMax=2G matches 2147483648
+func unitPropertyMatches(actual string, expected string) bool {
+	if actual == expected {
+		return true
+	} else if isUnitInfinity(actual) || isUnitInfinity(expected) {
+		return isUnitInfinity(actual) && isUnitInfinity(expected)
+	} else if unitSizeRe.MatchString(actual) && unitSizeRe.MatchString(expected) {
+		return parseSize(actual) == parseSize(expected)
+	}
+	return false
+}
+
+// unitProperty checks whether a property of a unit, as shown by
+// `systemctl show`, has the given value (e.g. Restart=always). Sizes can be
+// given with units, e.g. MemoryMax=2G.
+func unitProperty(unit string, property string, value string, user string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		actual := getUnitProperty(unit, property, user)
+		if unitPropertyMatches(actual, value) {
+			return 0, ""
+		}
+		msg := "Unit property did not have expected value:"
+		msg += "\n\tUnit: " + unit
+		msg += "\n\tProperty: " + property
+		return genericError(msg, value, []string{actual})
+	}
+}
+
+// systemStateRunning checks that `systemctl is-system-running` reports that
+// the system is fully operational, and not e.g. degraded or still starting
+func systemStateRunning(user string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		systemctlShouldExist()
+		// is-system-running exits non-zero for any state other than running
+		out, err := systemctlCommand(user, "is-system-running").Output()
+		state := strings.TrimSpace(string(out))
+		if err != nil && state == "" {
+			log.Fatal("Couldn't execute `systemctl is-system-running`:\n\t" + err.Error())
+		}
+		if state == "running" {
+			return 0, ""
+		}
+		return genericError("System is not running", "running", []string{state})
+	}
+}
+
+// parseSystemdTimespan parses the time spans printed by systemd tools, like
+// "1min 2.345s" or "850ms", into a time.Duration
+func parseSystemdTimespan(span string) time.Duration {
+	re := regexp.MustCompile("([0-9]+(?:\\.[0-9]+)?)(w|d|h|min|ms|us|s)")
+	units := map[string]time.Duration{
+		"w": 7 * 24 * time.Hour, "d": 24 * time.Hour, "h": time.Hour, "min": time.Minute, "s": time.Second,
+		"ms": time.Millisecond, "us": time.Microsecond,
+	}
+	var total time.Duration
+	for _, match := range re.FindAllStringSubmatch(span, -1) {
+		num, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			log.Fatal("Couldn't parse systemd time span: " + span)
+		}
+		total += time.Duration(num * float64(units[match[2]]))
+	}
+	return total
+}
+
+// bootTimeBelow checks that the total boot time reported by systemd-analyze
+// is below the given number of seconds
+func bootTimeBelow(max time.Duration) Thunk {
+	// getBootTime returns the total time from the "Startup finished" line of
+	// systemd-analyze
+	getBootTime := func() time.Duration {
+		out, err := exec.Command("systemd-analyze", "time").CombinedOutput()
+		outstr := strings.TrimSpace(string(out))
+		if err != nil {
+			msg := "Couldn't execute `systemd-analyze time`:"
+			msg += "\n\tError: " + err.Error()
+			msg += "\n\tOutput: " + outstr
+			log.Fatal(msg)
+		}
+		split := strings.Split(strings.Split(outstr, "\n")[0], "=")
+		return parseSystemdTimespan(split[len(split)-1])
+	}
+	return func() (exitCode int, exitMessage string) {
+		bootTime := getBootTime()
+		if bootTime < max {
+			return 0, ""
+		}
+		msg := "Boot time exceeds defined maximum"
+		return genericError(msg, max.String(), []string{bootTime.String()})
+	}
+}
+
+// getTimerNextElapse returns how long it is until the given timer next
+// activates, and false if it isn't scheduled to activate again
+func getTimerNextElapse(unit string, user string) (time.Duration, bool) {
+	realtime := getUnitProperty(unit, "NextElapseUSecRealtime", user)
+	if realtime != "" && realtime != "n/a" {
+		layout := "Mon 2006-01-02 15:04:05 MST"
+		next, err := time.ParseInLocation(layout, realtime, time.Local)
+		if err != nil {
+			log.Fatal("Couldn't parse next activation of " + unit + ": " + realtime)
+		}
+		return next.Sub(time.Now()), true
+	}
+	// monotonic timers (OnBootSec, OnUnitActiveSec...) count from boot
+	monotonic := getUnitProperty(unit, "NextElapseUSecMonotonic", user)
+	if monotonic != "" && monotonic != "infinity" && monotonic != "0" {
+		return parseSystemdTimespan(monotonic) - getUptime(), true
+	}
+	return 0, false
+}
+
+// timerWillRunWithin checks that a timer is scheduled to activate again
+// within the given duration, catching timers that silently stopped scheduling
+func timerWillRunWithin(unit string, within time.Duration, user string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		next, scheduled := getTimerNextElapse(unit, user)
+		if !scheduled {
+			return 1, "Timer is not scheduled to run again: " + unit
+		}
+		if next <= within {
+			return 0, ""
+		}
+		msg := "Timer will not run within the given time: " + unit
+		return genericError(msg, within.String(), []string{next.String()})
 	}
 }
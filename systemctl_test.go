@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// listUnitFilesOutputs is captured `systemctl list-unit-files` output from
+// several versions of systemd, with the unit files and statuses each should
+// parse to
+var listUnitFilesOutputs = []struct {
+	name     string
+	out      string
+	useJSON  bool
+	units    []string
+	statuses []string
+}{
+	{
+		// systemd 219 (CentOS 7), --plain --no-legend
+		name: "219 columns",
+		out: `cups.path                                   enabled
+sshd.service                                static
+tmp.mount                                   disabled
+`,
+		units:    []string{"cups.path", "sshd.service", "tmp.mount"},
+		statuses: []string{"enabled", "static", "disabled"},
+	},
+	{
+		// systemd 245 (Ubuntu 20.04), which prints a vendor preset column and
+		// a footer despite --no-legend
+		name: "245 columns with preset",
+		out: `ssh.service                            enabled         enabled
+systemd-fsck@.service                  static          enabled
+apt-daily.timer                        enabled-runtime enabled
+getty@.service                         masked          enabled
+
+4 unit files listed.
+`,
+		units:    []string{"ssh.service", "systemd-fsck@.service", "apt-daily.timer", "getty@.service"},
+		statuses: []string{"enabled", "static", "enabled-runtime", "masked"},
+	},
+	{
+		// systemd 246 and later, --output=json
+		name:    "246 JSON",
+		useJSON: true,
+		out: `[{"unit_file":"ssh.service","state":"enabled","preset":"enabled"},` +
+			`{"unit_file":"rescue.target","state":"static","preset":null},` +
+			`{"unit_file":"nginx.service","state":"disabled","preset":"enabled"}]`,
+		units:    []string{"ssh.service", "rescue.target", "nginx.service"},
+		statuses: []string{"enabled", "static", "disabled"},
+	},
+	{
+		// rows with an empty or missing state are skipped
+		name:    "JSON without states",
+		useJSON: true,
+		out: `[{"unit_file":"ssh.service","state":""},` +
+			`{"unit_file":"cron.service"},` +
+			`{"unit_file":"rsync.service","state":"disabled"}]`,
+		units:    []string{"rsync.service"},
+		statuses: []string{"disabled"},
+	},
+}
+
+func TestUnitFileStatuses(t *testing.T) {
+	for _, tc := range listUnitFilesOutputs {
+		rows, err := parseSystemctlTable([]byte(tc.out), unitFileColumns, tc.useJSON)
+		if err != nil {
+			t.Errorf("%s: couldn't parse table: %s", tc.name, err)
+			continue
+		}
+		units, statuses := unitFileStatuses(rows)
+		if !reflect.DeepEqual(units, tc.units) {
+			t.Errorf("%s: units = %v, want %v", tc.name, units, tc.units)
+		}
+		if !reflect.DeepEqual(statuses, tc.statuses) {
+			t.Errorf("%s: statuses = %v, want %v", tc.name, statuses, tc.statuses)
+		}
+	}
+}
+
+func TestParseSystemctlTableLastColumn(t *testing.T) {
+	out := "ssh.service loaded active running OpenBSD Secure Shell server\n"
+	rows, err := parseSystemctlTable([]byte(out), listUnitsColumns, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["description"] != "OpenBSD Secure Shell server" {
+		t.Errorf("rows = %v, want one row with the full description", rows)
+	}
+}
+
+func TestParseSystemctlTableBadJSON(t *testing.T) {
+	if _, err := parseSystemctlTable([]byte("not json"), unitFileColumns, true); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestUnitPropertyMatches(t *testing.T) {
+	cases := []struct {
+		actual, expected string
+		want             bool
+	}{
+		{"always", "always", true},
+		{"always", "on-failure", false},
+		{"2147483648", "2G", true},
+		{"2147483648", "2GB", true},
+		{"536870912", "512M", true},
+		{"2147483648", "1G", false},
+		{"infinity", "infinity", true},
+		{"18446744073709551615", "infinity", true},
+		{"infinity", "2G", false},
+		{"2147483648", "infinity", false},
+		{"5s", "5", false},
+	}
+	for _, tc := range cases {
+		if got := unitPropertyMatches(tc.actual, tc.expected); got != tc.want {
+			t.Errorf("unitPropertyMatches(%q, %q) = %v, want %v", tc.actual, tc.expected, got, tc.want)
+		}
+	}
+}
@@ -123,6 +123,58 @@ func fileToLines(path string) [][]byte {
 	return bytes.Split(fileToBytes(path), []byte("\n"))
 }
 
+// parseINI parses INI-style data into a map of section names to key/value
+// pairs, along with the section names in the order they appeared. Keys outside
+// of any section are placed in the section "". Lines starting with # or ; are
+// treated as comments, and indented lines continue the previous value.
+func parseINI(data string) (sections map[string]map[string]string, order []string, err error) {
+	sections = map[string]map[string]string{"": map[string]string{}}
+	section := ""
+	lastKey := ""
+	for i, rawLine := range strings.Split(data, "\n") {
+		line := strings.TrimSpace(rawLine)
+		indented := line != "" && strings.TrimLeft(rawLine, " \t") != rawLine
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case indented && lastKey != "":
+			sections[section][lastKey] += "\n" + line
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				return sections, order, fmt.Errorf("line %d: malformed section header: %s", i+1, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			lastKey = ""
+			if _, ok := sections[section]; !ok {
+				sections[section] = map[string]string{}
+				order = append(order, section)
+			}
+		default:
+			split := strings.SplitN(line, "=", 2)
+			if len(split) != 2 {
+				return sections, order, fmt.Errorf("line %d: expected key=value: %s", i+1, line)
+			}
+			key := strings.TrimSpace(split[0])
+			if key == "" {
+				return sections, order, fmt.Errorf("line %d: empty key: %s", i+1, line)
+			}
+			sections[section][key] = strings.TrimSpace(split[1])
+			lastKey = key
+		}
+	}
+	return sections, order, nil
+}
+
+// compileRegex compiles a regular expression given in a checklist, failing
+// with a readable message if it is invalid
+func compileRegex(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatal("Invalid regular expression: " + pattern + "\n\t" + err.Error())
+	}
+	return re
+}
+
 // genericError is a general error where the requested variable was not found in
 // a given list of variables. This is pure DRY.
 func genericError(msg string, name string, actual []string) (exitCode int, exitMessage string) {
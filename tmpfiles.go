@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tmpDirs are the directories that tmpClean inspects
+var tmpDirs = []string{"/tmp", "/var/tmp"}
+
+// staleFilesSize walks dir and returns the total size of regular files that
+// haven't been modified since cutoff. Unreadable entries are skipped.
+func staleFilesSize(dir string, cutoff time.Time) (total uint64) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// skip unreadable subdirectories rather than aborting the walk
+			if info != nil && info.IsDir() && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && info.ModTime().Before(cutoff) {
+			total += uint64(info.Size())
+		}
+		return nil
+	})
+	return total
+}
+
+// tmpClean checks that files in /tmp and /var/tmp older than this many days
+// take up less than maxSize, and that systemd-tmpfiles-clean.timer is active
+// on systemd hosts, so that temporary files don't slowly fill the disk.
+func tmpClean(days int, maxSize uint64) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		for _, dir := range tmpDirs {
+			size := staleFilesSize(dir, cutoff)
+			if size >= maxSize {
+				msg := "Stale files in " + dir + " take up too much space"
+				return genericError(msg, formatSize(maxSize), []string{formatSize(size)})
+			}
+		}
+		timer := "systemd-tmpfiles-clean.timer"
+		if getInitSystem() == "systemd" && !serviceIsActive("systemd", timer) {
+			return 1, "Timer is not active: " + timer
+		}
+		return 0, ""
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Group is a struct that contains all relevant information that can be parsed
@@ -19,7 +21,7 @@ type Group struct {
 
 // getGroups returns a list of Group structs, as parsed from /etc/group
 func getGroups() (groups []Group) {
-	data := fileToString("/etc/group")
+	data := fileToString(hostPath("/etc/group"))
 	rowSep := regexp.MustCompile("\n")
 	colSep := regexp.MustCompile(":")
 	lines := separateString(rowSep, colSep, data)
@@ -106,6 +108,9 @@ func GroupId(name string, id int) Thunk {
 // exist? Given argument can either be a string that can be parsed as an int
 // (UID) or just a username
 func lookupUser(usernameOrUid string) (*user.User, error) {
+	if imageRoot != "" {
+		return lookupImageUser(usernameOrUid)
+	}
 	usr, err := user.LookupId(usernameOrUid)
 	if err != nil {
 		usr, err = user.Lookup(usernameOrUid)
@@ -116,6 +121,27 @@ func lookupUser(usernameOrUid string) (*user.User, error) {
 	return usr, nil
 }
 
+// lookupImageUser is lookupUser for a container image, which has its own
+// /etc/passwd that the os/user package can't be pointed at
+func lookupImageUser(usernameOrUid string) (*user.User, error) {
+	for _, line := range strings.Split(fileToString(hostPath("/etc/passwd")), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			continue
+		}
+		if fields[0] == usernameOrUid || fields[2] == usernameOrUid {
+			return &user.User{
+				Username: fields[0],
+				Uid:      fields[2],
+				Gid:      fields[3],
+				Name:     fields[4],
+				HomeDir:  fields[5],
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("Couldn't find user: %s", usernameOrUid)
+}
+
 // userHasField checks to see if the user of a given username or uid's struct
 // field "fieldName" matches the given value. An abstraction of HasUID, HasGID,
 // HasName, HasHomeDir, and UserExists
@@ -198,3 +224,148 @@ func UserHasName(usernameOrUid string, name string) Thunk {
 func UserHasHomeDir(usernameOrUid string, homeDir string) Thunk {
 	return genericUserField(usernameOrUid, "HomeDir", homeDir)
 }
+
+// ShadowEntry is a user's password aging information from /etc/shadow. Dates
+// are in days since the epoch; fields that aren't set are -1.
+type ShadowEntry struct {
+	Username   string
+	Password   string
+	LastChange int
+	MaxAge     int
+	Expire     int
+}
+
+// getShadowEntry returns the /etc/shadow entry of the user with the given
+// username or UID, and whether it was found
+func getShadowEntry(usernameOrUid string) (ShadowEntry, bool) {
+	usr, err := lookupUser(usernameOrUid)
+	if err != nil {
+		return ShadowEntry{}, false
+	}
+	// parseDaysField parses an optional numeric field from /etc/shadow
+	parseDaysField := func(field string) int {
+		if field == "" {
+			return -1
+		}
+		days, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			log.Fatal("Couldn't parse /etc/shadow field: " + field)
+		}
+		return int(days)
+	}
+	for _, line := range strings.Split(fileToString(hostPath("/etc/shadow")), "\n") {
+		// name:password:lastchg:min:max:warn:inactive:expire:reserved
+		fields := strings.Split(line, ":")
+		if len(fields) < 8 || fields[0] != usr.Username {
+			continue
+		}
+		entry := ShadowEntry{
+			Username:   fields[0],
+			Password:   fields[1],
+			LastChange: parseDaysField(fields[2]),
+			MaxAge:     parseDaysField(fields[4]),
+			Expire:     parseDaysField(fields[7]),
+		}
+		return entry, true
+	}
+	return ShadowEntry{}, false
+}
+
+// daysSinceEpoch returns today's date in the format /etc/shadow uses
+func daysSinceEpoch() int {
+	return int(time.Now().Unix() / (24 * 60 * 60))
+}
+
+// shadowThunk is an abstraction of the password and account aging checks.
+// check returns whether the entry passes, and a message if it doesn't.
+func shadowThunk(usernameOrUid string, check func(ShadowEntry) (bool, string)) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		entry, ok := getShadowEntry(usernameOrUid)
+		if !ok {
+			return 1, "User does not exist in /etc/shadow: " + usernameOrUid
+		}
+		if passed, msg := check(entry); !passed {
+			return 1, msg
+		}
+		return 0, ""
+	}
+}
+
+// PasswordExpiresWithin checks that the user's password expires within this
+// many days from now, i.e. that password aging is enforced for them
+func PasswordExpiresWithin(usernameOrUid string, days int) Thunk {
+	return shadowThunk(usernameOrUid, func(entry ShadowEntry) (bool, string) {
+		if entry.MaxAge < 0 || entry.LastChange < 0 || entry.MaxAge >= 99999 {
+			return false, "Password never expires: " + entry.Username
+		}
+		remaining := entry.LastChange + entry.MaxAge - daysSinceEpoch()
+		if remaining <= days {
+			return true, ""
+		}
+		msg := "Password does not expire within " + fmt.Sprint(days) + " days: "
+		msg += entry.Username
+		msg += "\n\tExpires in: " + fmt.Sprint(remaining) + " days"
+		return false, msg
+	})
+}
+
+// PasswordMaxAgeBelow checks that the user's maximum password age is set,
+// and is less than this many days
+func PasswordMaxAgeBelow(usernameOrUid string, days int) Thunk {
+	return shadowThunk(usernameOrUid, func(entry ShadowEntry) (bool, string) {
+		if entry.MaxAge >= 0 && entry.MaxAge < days {
+			return true, ""
+		}
+		msg := "Maximum password age is not below " + fmt.Sprint(days) + " days: "
+		msg += entry.Username
+		msg += "\n\tActual: " + fmt.Sprint(entry.MaxAge)
+		return false, msg
+	})
+}
+
+// AccountLocked checks that the user can't log in with a password, because
+// it's been locked (e.g. `usermod -L`) or was never set
+func AccountLocked(usernameOrUid string) Thunk {
+	return shadowThunk(usernameOrUid, func(entry ShadowEntry) (bool, string) {
+		if strings.HasPrefix(entry.Password, "!") || entry.Password == "*" {
+			return true, ""
+		}
+		return false, "Account is not locked: " + entry.Username
+	})
+}
+
+// AccountNotExpired checks that the user's account has no expiry date, or one
+// that hasn't passed yet
+func AccountNotExpired(usernameOrUid string) Thunk {
+	return shadowThunk(usernameOrUid, func(entry ShadowEntry) (bool, string) {
+		if entry.Expire < 0 || entry.Expire > daysSinceEpoch() {
+			return true, ""
+		}
+		expired := time.Unix(int64(entry.Expire)*24*60*60, 0).UTC()
+		msg := "Account has expired: " + entry.Username
+		msg += "\n\tExpired: " + expired.Format("2006-01-02")
+		return false, msg
+	})
+}
+
+// noUnexpectedUID0Users checks that no user besides those allowed (root, by
+// default) has UID 0, and so full root privileges
+func noUnexpectedUID0Users(allowed []string) Thunk {
+	if len(allowed) == 0 {
+		allowed = []string{"root"}
+	}
+	return func() (exitCode int, exitMessage string) {
+		var unexpected []string
+		for _, line := range strings.Split(fileToString(hostPath("/etc/passwd")), "\n") {
+			fields := strings.Split(line, ":")
+			if len(fields) > 2 && fields[2] == "0" && !strIn(fields[0], allowed) {
+				unexpected = append(unexpected, fields[0])
+			}
+		}
+		if len(unexpected) == 0 {
+			return 0, ""
+		}
+		msg := "Unexpected users with UID 0 found"
+		return genericError(msg, strings.Join(allowed, ","), unexpected)
+	}
+}
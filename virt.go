@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// dmiHypervisors map strings found in DMI vendor and product names to the
+// names systemd-detect-virt gives those hypervisors
+var dmiHypervisors = []struct{ dmi, name string }{
+	{"KVM", "kvm"}, {"QEMU", "qemu"}, {"VMware", "vmware"},
+	{"VirtualBox", "oracle"}, {"innotek", "oracle"}, {"Xen", "xen"},
+	{"Microsoft Corporation", "microsoft"}, {"Amazon EC2", "amazon"},
+	{"Google Compute Engine", "google"}, {"Parallels", "parallels"},
+	{"bhyve", "bhyve"}, {"BHYVE", "bhyve"},
+}
+
+// detectVirt asks systemd-detect-virt for this kind ("--vm" or
+// "--container") of virtualization, returning false if it isn't installed
+func detectVirt(kind string) (string, bool) {
+	out, err := exec.Command("systemd-detect-virt", kind).Output()
+	// it exits with 1 and prints "none" when there's no virtualization
+	if len(out) == 0 && err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// getVirtualization returns the hypervisor this host runs on, like "kvm" or
+// "vmware", or "none" on bare metal. Without systemd-detect-virt, it's
+// guessed from DMI, Xen's /proc and /sys entries, and the CPU's hypervisor
+// flag.
+func getVirtualization() string {
+	if virt, ok := detectVirt("--vm"); ok {
+		return virt
+	}
+	for _, field := range []string{"sys_vendor", "product_name", "bios_vendor"} {
+		value := fileToStringOrEmpty("/sys/class/dmi/id/" + field)
+		for _, hypervisor := range dmiHypervisors {
+			if strings.Contains(value, hypervisor.dmi) {
+				return hypervisor.name
+			}
+		}
+	}
+	if strings.TrimSpace(fileToStringOrEmpty("/sys/hypervisor/type")) == "xen" {
+		return "xen"
+	} else if _, err := os.Stat("/proc/xen"); err == nil {
+		return "xen"
+	}
+	for _, line := range strings.Split(fileToStringOrEmpty("/proc/cpuinfo"), "\n") {
+		if strings.HasPrefix(line, "flags") && strIn("hypervisor", strings.Fields(line)) {
+			return "vm-other"
+		}
+	}
+	return "none"
+}
+
+// getContainer returns the container engine this host runs in, like
+// "docker", "podman", or "lxc", or "none" if it isn't in one. Without
+// systemd-detect-virt, it's guessed from the files engines leave behind, the
+// init process's $container, and its cgroup.
+func getContainer() string {
+	if container, ok := detectVirt("--container"); ok {
+		return container
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	} else if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	// reading init's environment needs root
+	for _, env := range strings.Split(fileToStringOrEmpty("/proc/1/environ"), "\x00") {
+		if strings.HasPrefix(env, "container=") {
+			return strings.TrimPrefix(env, "container=")
+		}
+	}
+	cgroup := fileToStringOrEmpty("/proc/1/cgroup")
+	for _, engine := range []string{"docker", "lxc", "kubepods"} {
+		if strings.Contains(cgroup, "/"+engine) {
+			if engine == "kubepods" {
+				return "container-other"
+			}
+			return engine
+		}
+	}
+	if strings.Contains(strings.ToLower(fileToStringOrEmpty("/proc/sys/kernel/osrelease")), "microsoft") {
+		return "wsl"
+	}
+	return "none"
+}
+
+// environmentIs checks that detect, which finds a kind of virtualization,
+// returns one of these names. With no names, anything but "none" passes.
+func environmentIs(what string, names []string, detect func() string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		actual := detect()
+		if (len(names) == 0 && actual != "none") || strIn(actual, names) {
+			return 0, ""
+		}
+		expected := strings.Join(names, ",")
+		if expected == "" {
+			expected = "any"
+		}
+		return genericError("Unexpected "+what, expected, []string{actual})
+	}
+}
+
+// runningOnVirtualization checks that this host runs on one of these
+// hypervisors (e.g. "kvm", "vmware", or "none" for bare metal), or on any
+// hypervisor if none are given
+func runningOnVirtualization(names []string) Thunk {
+	return environmentIs("virtualization", names, getVirtualization)
+}
+
+// runningInContainer checks that this host is one of these kinds of
+// container (e.g. "docker", "podman", "lxc", or "none"), or in any container
+// if none are given
+func runningInContainer(names []string) Thunk {
+	return environmentIs("container environment", names, getContainer)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wireguardPeer is a peer of a WireGuard interface, from `wg show <iface> dump`
+type wireguardPeer struct {
+	PublicKey     string
+	Endpoint      string
+	LastHandshake time.Time
+}
+
+// getWireguardPeers returns the peers of a WireGuard interface, and false if
+// the interface doesn't exist
+func getWireguardPeers(iface string) (peers []wireguardPeer, exists bool) {
+	out, err := exec.Command("wg", "show", iface, "dump").CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("WireGuard checks require `wg` (wireguard-tools)")
+	} else if err != nil {
+		return peers, false
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	// the first line describes the interface itself; each following line is a
+	// peer: public-key preshared-key endpoint allowed-ips latest-handshake ...
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		peer := wireguardPeer{PublicKey: fields[0], Endpoint: fields[2]}
+		if seconds, err := strconv.ParseInt(fields[4], 10, 64); err == nil && seconds > 0 {
+			peer.LastHandshake = time.Unix(seconds, 0)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, true
+}
+
+// wireguardHasPeer checks that a WireGuard interface exists and has a peer
+// with this public key
+func wireguardHasPeer(iface string, publicKey string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		peers, exists := getWireguardPeers(iface)
+		if !exists {
+			return 1, "WireGuard interface does not exist: " + iface
+		}
+		var keys []string
+		for _, peer := range peers {
+			if peer.PublicKey == publicKey {
+				return 0, ""
+			}
+			keys = append(keys, peer.PublicKey)
+		}
+		return genericError("WireGuard interface does not have peer: "+iface, publicKey, keys)
+	}
+}
+
+// wireguardHandshakeWithin checks that every peer of a WireGuard interface (or
+// just the peer with this public key, if given) completed a handshake within
+// this long. WireGuard renews handshakes every two minutes while traffic flows.
+func wireguardHandshakeWithin(iface string, within time.Duration, publicKey string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		peers, exists := getWireguardPeers(iface)
+		if !exists {
+			return 1, "WireGuard interface does not exist: " + iface
+		}
+		found := false
+		for _, peer := range peers {
+			if publicKey != "" && peer.PublicKey != publicKey {
+				continue
+			}
+			found = true
+			if peer.LastHandshake.IsZero() {
+				return 1, "WireGuard peer has never completed a handshake: " + peer.PublicKey
+			}
+			if age := time.Since(peer.LastHandshake); age > within {
+				msg := "WireGuard peer's latest handshake is too old: " + peer.PublicKey
+				return genericError(msg, within.String(), []string{age.String()})
+			}
+		}
+		if !found && publicKey != "" {
+			return 1, "WireGuard interface does not have peer: " + iface + ": " + publicKey
+		} else if !found {
+			return 1, "WireGuard interface has no peers: " + iface
+		}
+		return 0, ""
+	}
+}
+
+// wireguardTunnelPasses checks that traffic to this host is routed through the
+// WireGuard interface, and that the host answers a ping sent through it
+func wireguardTunnelPasses(iface string, host string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		out, err := exec.Command("ip", "route", "get", host).CombinedOutput()
+		if err != nil {
+			return 1, "Couldn't get route to host: " + host + "\n\t" + strings.TrimSpace(string(out))
+		}
+		fields := strings.Fields(string(out))
+		device := ""
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "dev" {
+				device = fields[i+1]
+			}
+		}
+		if device != iface {
+			msg := "Traffic to " + host + " is not routed through interface"
+			return genericError(msg, iface, []string{device})
+		}
+		err = exec.Command("ping", "-c", "1", "-W", "3", "-I", iface, host).Run()
+		if err != nil {
+			return 1, "Host did not answer ping through tunnel: " + host + " via " + iface
+		}
+		return 0, ""
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// iwCommand runs iw with the given arguments and returns its output
+func iwCommand(args ...string) string {
+	out, err := exec.Command("iw", args...).CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "executable file not found") {
+		log.Fatal("Wi-Fi checks require `iw`")
+	} else if err != nil {
+		msg := "Error while executing `iw " + strings.Join(args, " ") + "`:"
+		msg += "\n\tOutput: " + strings.TrimSpace(string(out))
+		log.Fatal(msg)
+	}
+	return string(out)
+}
+
+// getWifiLink returns the fields `iw dev <iface> link` reports for the
+// interface's current association (e.g. "SSID" and "signal"), or nil if it
+// isn't associated. Fields look like "signal: -54 dBm".
+func getWifiLink(iface string) map[string]string {
+	out := iwCommand("dev", iface, "link")
+	if strings.HasPrefix(strings.TrimSpace(out), "Not connected") {
+		return nil
+	}
+	link := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 {
+			link[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	return link
+}
+
+// wifiConnected checks that a wireless interface is associated with the
+// network with this SSID
+func wifiConnected(iface string, ssid string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		link := getWifiLink(iface)
+		if link == nil {
+			return 1, "Wireless interface is not connected: " + iface
+		}
+		if link["SSID"] == ssid {
+			return 0, ""
+		}
+		msg := "Wireless interface is connected to the wrong network: " + iface
+		return genericError(msg, ssid, []string{link["SSID"]})
+	}
+}
+
+// wifiSignalAbove checks that a wireless interface's signal strength is above
+// this many dBm (e.g. -70)
+func wifiSignalAbove(iface string, min int) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		link := getWifiLink(iface)
+		if link == nil {
+			return 1, "Wireless interface is not connected: " + iface
+		}
+		fields := strings.Fields(link["signal"])
+		if len(fields) == 0 {
+			return 1, "Couldn't find signal strength of wireless interface: " + iface
+		}
+		signal, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			log.Fatal("Couldn't parse signal strength: " + link["signal"])
+		}
+		if int(signal) > min {
+			return 0, ""
+		}
+		msg := "Wireless signal is too weak: " + iface
+		return genericError(msg, fmt.Sprint(min)+" dBm", []string{link["signal"]})
+	}
+}
+
+// regDomainRe matches the country lines of `iw reg get`, like
+// "country US: DFS-FCC". The first is the global domain, and any others belong
+// to devices that manage their own.
+var regDomainRe = regexp.MustCompile(`(?m)^country (\w\w):`)
+
+// wifiRegDomain checks that the wireless regulatory domain is set to this
+// country code (e.g. "US" or "DE"), so that only legal channels and transmit
+// powers are used. Domains that are unset ("00") are ignored, but at least
+// one must be set.
+func wifiRegDomain(country string) Thunk {
+	return func() (exitCode int, exitMessage string) {
+		var domains []string
+		for _, match := range regDomainRe.FindAllStringSubmatch(iwCommand("reg", "get"), -1) {
+			domains = append(domains, match[1])
+		}
+		matched := false
+		for _, domain := range domains {
+			if domain == "00" {
+				continue
+			} else if !strings.EqualFold(domain, country) {
+				matched = false
+				break
+			}
+			matched = true
+		}
+		if matched {
+			return 0, ""
+		}
+		msg := "Wireless regulatory domain does not match"
+		return genericError(msg, country, domains)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "syscall"
+
+// getXattr reads a file's extended attribute, returning errNoXattr if the
+// file doesn't have it
+func getXattr(path string, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err == syscall.ENODATA {
+		return nil, errNoXattr
+	} else if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// getXattr reads a file's extended attribute. Only Linux is supported.
+func getXattr(path string, name string) ([]byte, error) {
+	return nil, errors.New("extended attributes are only supported on Linux")
+}